@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/elinx/saturn/pkg/config"
+	"github.com/elinx/saturn/pkg/epub"
+	"github.com/elinx/saturn/pkg/saturn"
+)
+
+// runExportNotes implements `saturn export-notes [options] <book.epub>`
+func runExportNotes(paths config.Paths, args []string) int {
+	flags := flag.NewFlagSet("export-notes", flag.ExitOnError)
+	formatName := flags.String("format", string(saturn.ExportMarkdown), "output format: markdown, json or csv")
+	output := flags.String("o", "", "write to the file instead of stdout")
+	dbPath := flags.String("db", paths.DBPath(), "path of the annotation database")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: saturn export-notes [options] <book.epub>\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	format, err := saturn.ParseExportFormat(*formatName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	// the notes are written once they are all exported, a failure leaves no
+	// file behind
+	var notes bytes.Buffer
+	if err := exportNotes(flags.Arg(0), *dbPath, format, &notes); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer file.Close()
+		w = file
+	}
+	if _, err := notes.WriteTo(w); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func exportNotes(filename, dbPath string, format saturn.ExportFormat, w io.Writer) error {
+	book := epub.NewEpub(filename)
+	if err := book.Open(); err != nil {
+		return err
+	}
+	defer book.Close()
+
+	store, err := findStore(dbPath, book)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	parser := saturn.NewParser(book)
+	if err := parser.Parse(); err != nil {
+		return err
+	}
+	annos, err := store.Annotations()
+	if err != nil {
+		return err
+	}
+	chapters := saturn.GroupAnnotations(book.GetTableOfContent(), parser.GetBuffer(), annos)
+	return saturn.ExportAnnotations(w, book.Title(), chapters, format)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/elinx/saturn/pkg/clippings"
+	"github.com/elinx/saturn/pkg/config"
+	"github.com/elinx/saturn/pkg/epub"
+	"github.com/elinx/saturn/pkg/saturn"
+)
+
+// runImport implements `saturn import [options] <book.epub> <clippings>`
+func runImport(paths config.Paths, args []string) int {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	format := flags.String("format", "auto", "format of the clippings: kindle, koreader or auto")
+	title := flags.String("title", "", "title of the book in the clippings, the title of the epub by default")
+	dbPath := flags.String("db", paths.DBPath(), "path of the annotation database")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: saturn import [options] <book.epub> <My Clippings.txt|metadata.epub.lua>\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+	if *format == "auto" {
+		*format = "kindle"
+		if strings.EqualFold(filepath.Ext(flags.Arg(1)), ".lua") {
+			*format = "koreader"
+		}
+	}
+	if *format != "kindle" && *format != "koreader" {
+		fmt.Fprintf(os.Stderr, "unknown clippings format: %s\n", *format)
+		return 2
+	}
+	if err := importClippings(flags.Arg(0), flags.Arg(1), *format, *title, *dbPath); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func importClippings(filename, clippingsFile, format, title, dbPath string) error {
+	book := epub.NewEpub(filename)
+	if err := book.Open(); err != nil {
+		return err
+	}
+	defer book.Close()
+
+	file, err := os.Open(clippingsFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var clips []clippings.Clipping
+	if format == "koreader" {
+		// the sidecar belongs to one book so its title isn't checked
+		clips, err = clippings.ParseKOReader(file)
+	} else {
+		if title == "" {
+			title = book.Title()
+		}
+		clips, err = clippings.ParseKindle(file)
+		clips = clippings.ForBook(clips, title)
+	}
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore(dbPath, book)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	parser := saturn.NewParser(book)
+	if err := parser.Parse(); err != nil {
+		return err
+	}
+	imported, missing, err := saturn.ImportClippings(store, parser.GetBuffer(), clips)
+	if err != nil {
+		return err
+	}
+	for _, clip := range missing {
+		fmt.Fprintf(os.Stderr, "not found in the book: %q\n", clip.Text)
+	}
+	fmt.Printf("%d of %d clippings imported\n", imported, len(clips))
+	return nil
+}
@@ -1,43 +1,287 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"io"
 	"os"
+	"sync"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/elinx/saturn/pkg/config"
 	"github.com/elinx/saturn/pkg/db"
 	"github.com/elinx/saturn/pkg/epub"
-	_ "github.com/elinx/saturn/pkg/logconfig"
+	"github.com/elinx/saturn/pkg/logconfig"
 	"github.com/elinx/saturn/pkg/saturn"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
+// legacyDbPath is where the database was kept before the data directory
+const legacyDbPath = "db.sqlite"
+
 func main() {
-	log.Println("start app...")
-	book := epub.NewEpub(os.Args[1])
+	os.Exit(run(os.Args[1:]))
+}
+
+// run implements `saturn [options] [book.epub]` and dispatches the
+// subcommands, the options apply to every subcommand. The library is shown
+// without a book.
+func run(args []string) int {
+	flags := flag.NewFlagSet("saturn", flag.ExitOnError)
+	overrides := config.Overrides{}
+	flags.StringVar(&overrides.DataDir, "data-dir", "", "directory of the annotation database (env SATURN_DATA_DIR)")
+	flags.StringVar(&overrides.ConfigDir, "config-dir", "", "directory of the configuration (env SATURN_CONFIG_DIR)")
+	flags.StringVar(&overrides.CacheDir, "cache-dir", "", "directory of the cache (env SATURN_CACHE_DIR)")
+	flags.StringVar(&overrides.LogFile, "log-file", "", "file of the log, - for stderr (env SATURN_LOG_FILE)")
+	logLevel := flags.String("log-level", "warn", "log level: debug, info, warn or error")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: saturn [options] [book.epub]\n"+
+			"       saturn [options] search|export-notes|import ...\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	paths, err := config.ResolvePaths(overrides)
+	if err == nil {
+		err = paths.EnsureDirs()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	logFile, err := logconfig.Init(logconfig.Options{Level: *logLevel, File: paths.LogFile})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer logFile.Close()
+	if err := adoptLegacyDb(paths); err != nil {
+		log.Error(err)
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	args = flags.Args()
+	filename := ""
+	if len(args) > 0 {
+		switch args[0] {
+		case "search":
+			return runSearch(args[1:])
+		case "export-notes":
+			return runExportNotes(paths, args[1:])
+		case "import":
+			return runImport(paths, args[1:])
+		}
+		filename = args[0]
+	}
+	if err := runReader(paths, filename); err != nil {
+		log.Error(err)
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// runReader opens the book for reading, the library is shown instead if
+// there is no book
+func runReader(paths config.Paths, filename string) error {
+	log.Info("start app...")
+	cfg, err := config.Load(paths.ConfigFile())
+	if err != nil {
+		return err
+	}
+	if cfg.Export.Dir == "" {
+		cfg.Export.Dir = paths.NotesDir()
+	}
+	if err := saturn.Configure(cfg); err != nil {
+		return errors.Wrapf(err, "invalid config %s", paths.ConfigFile())
+	}
+
+	store, err := db.NewDb(paths.DBPath())
+	if err != nil {
+		return err
+	}
+	// the annotations made just before quitting are flushed here
+	defer func() {
+		if err := store.Close(); err != nil {
+			log.Error(err)
+		}
+	}()
+	opener := &bookOpener{paths: paths, cfg: cfg, store: store}
+	defer opener.Close()
+
+	var model tea.Model
+	if filename == "" {
+		model = saturn.NewLibraryModel(store, cfg.Library.Dirs, opener.Open)
+	} else if model, err = opener.Open(filename); err != nil {
+		return err
+	}
+	program := tea.NewProgram(model, tea.WithAltScreen(), tea.WithMouseAllMotion())
+	return program.Start()
+}
+
+// bookOpener opens the books to read, they are kept open until Close
+type bookOpener struct {
+	paths config.Paths
+	cfg   config.Config
+	store *db.DB
+
+	mu    sync.Mutex
+	books []*epub.Epub
+}
+
+// Open parses the book and returns the model showing it, the book is added to
+// the library and its annotations are read from the store
+func (o *bookOpener) Open(filename string) (tea.Model, error) {
+	book := epub.NewEpub(filename)
+	o.mu.Lock()
+	o.books = append(o.books, book)
+	o.mu.Unlock()
 	if err := book.Open(); err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	hash, err := db.FileHash(filename)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := saturn.NewLibraryBook(book, hash)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.store.AddBook(entry); err != nil {
+		log.Error(err)
+	}
+	if err := o.store.Run(entry.Book); err != nil {
+		return nil, err
 	}
-	defer book.Close()
 
-	db, err := db.NewDb("db.sqlite")
+	cache := openCache(o.paths, o.cfg.Cache, hash)
+	buffer, err := parseBook(book, cache)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	renderer := saturn.NewRender(book, buffer)
+	renderer.SetLayout(o.cfg.Reader)
+	renderer.SetCache(cache)
+	if annos, err := o.store.Annotations(); err != nil {
+		log.Error(err)
+	} else {
+		renderer.SetAnnotations(annos)
+	}
+	return saturn.NewMainModel(book, o.store, renderer), nil
+}
+
+// Close closes the books opened
+func (o *bookOpener) Close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, book := range o.books {
+		book.Close()
+	}
+}
+
+// adoptLegacyDb copies the database of the working directory into the data
+// directory on the first run, its tables are migrated when it's opened. The
+// legacy file is left in place.
+func adoptLegacyDb(paths config.Paths) error {
+	if _, err := os.Stat(legacyDbPath); err != nil {
+		return nil
+	}
+	if _, err := os.Stat(paths.DBPath()); err == nil {
+		return nil
+	}
+	src, err := os.Open(legacyDbPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to read the legacy database")
+	}
+	defer src.Close()
+	dst, err := os.CreateTemp(paths.DataDir, ".tmp-*")
+	if err != nil {
+		return errors.Wrap(err, "failed to copy the legacy database")
+	}
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(dst.Name(), paths.DBPath())
 	}
-	defer db.Close()
-	err = db.Run(book.Title())
 	if err != nil {
-		log.Fatal(err)
+		os.Remove(dst.Name())
+		return errors.Wrap(err, "failed to copy the legacy database")
 	}
+	log.Infof("copied %s to %s", legacyDbPath, paths.DBPath())
+	fmt.Fprintf(os.Stderr, "the annotations of %s are kept in %s from now on, %s isn't used anymore\n",
+		legacyDbPath, paths.DBPath(), legacyDbPath)
+	return nil
+}
 
+// parseBook reads the parsed book from the cache, the book is laid out to be
+// parsed as it's read if it isn't there. It's cached by the renderer once
+// every spine item is parsed.
+func parseBook(book *epub.Epub, cache *saturn.BookCache) (*saturn.Buffer, error) {
+	if cache != nil {
+		if buffer, ok := cache.LoadBuffer(); ok {
+			return buffer, nil
+		}
+	}
 	parser := saturn.NewParser(book)
-	if err := parser.Parse(); err != nil {
-		log.Fatal(err)
+	if err := parser.ParseLazy(); err != nil {
+		return nil, err
 	}
-	renderer := saturn.NewRender(book, parser.GetBuffer())
+	return parser.GetBuffer(), nil
+}
 
-	program := tea.NewProgram(saturn.NewMainModel(book, db, renderer),
-		tea.WithAltScreen(), tea.WithMouseAllMotion())
-	if err := program.Start(); err != nil {
-		panic(err)
+// openCache returns the cache of the book with the hash, nil if the cache is
+// disabled
+func openCache(paths config.Paths, cfg config.Cache, hash string) *saturn.BookCache {
+	if !cfg.Enabled {
+		return nil
+	}
+	return saturn.NewBookCache(paths.BookCacheDir(), hash, int64(cfg.MaxSizeMB)<<20)
+}
+
+// openStore opens the database and the book in it, the book is added if it
+// was never opened
+func openStore(dbPath string, book *epub.Epub) (*db.DB, error) {
+	hash, err := db.FileHash(book.Filename)
+	if err != nil {
+		return nil, err
+	}
+	store, err := db.NewDb(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	err = store.Run(db.Book{Identifier: book.Identifier(), FileHash: hash, Title: book.Title()})
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// errNoNotes is returned when the notes of a book never opened are read
+var errNoNotes = errors.New("no notes")
+
+// findStore opens the database to read the notes of the book, nothing is
+// written to it. errNoNotes is returned if the book was never opened.
+func findStore(dbPath string, book *epub.Epub) (*db.DB, error) {
+	hash, err := db.FileHash(book.Filename)
+	if err != nil {
+		return nil, err
+	}
+	store, err := db.NewDb(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	found, err := store.Find(db.Book{Identifier: book.Identifier(), FileHash: hash, Title: book.Title()})
+	if err == nil && !found {
+		err = errNoNotes
+	}
+	if err != nil {
+		store.Close()
+		return nil, err
 	}
+	return store, nil
 }
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/elinx/saturn/pkg/saturn"
+)
+
+type searchResult struct {
+	file    string
+	matches []saturn.BookMatch
+	err     error
+}
+
+// runSearch implements `saturn search [options] <pattern> <dir|files...>`
+func runSearch(args []string) int {
+	flags := flag.NewFlagSet("search", flag.ExitOnError)
+	jsonOutput := flags.Bool("json", false, "print matches as JSON lines")
+	regex := flags.Bool("regex", false, "treat the pattern as a regular expression")
+	smartCase := flags.Bool("smart-case", true, "ignore case unless the pattern has upper case letters")
+	contextWidth := flags.Int("context", 40, "runes of context around each match")
+	workers := flags.Int("jobs", runtime.NumCPU(), "number of books searched concurrently")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: saturn search [options] <pattern> <dir|files...>\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() < 2 {
+		flags.Usage()
+		return 2
+	}
+	pattern := flags.Arg(0)
+	files, err := collectEpubFiles(flags.Args()[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	re, err := saturn.CompilePattern(pattern, saturn.SearchOptions{Regex: *regex, SmartCase: *smartCase})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	results := searchBooks(files, re, *contextWidth, *workers)
+
+	failed := false
+	for result := range results {
+		if result.err != nil {
+			failed = true
+			fmt.Fprintf(os.Stderr, "%s: %v\n", result.file, result.err)
+			continue
+		}
+		if err := printMatches(os.Stdout, result.matches, *jsonOutput); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// collectEpubFiles expands the directories in paths to the epub files in them
+func collectEpubFiles(paths []string) ([]string, error) {
+	files := []string{}
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		err = filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && strings.EqualFold(filepath.Ext(path), ".epub") {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// searchBooks searches the books on a pool of workers, the results of each
+// book are sent as a whole once the book is done
+func searchBooks(files []string, re *regexp.Regexp, contextWidth, workers int) <-chan searchResult {
+	if workers < 1 {
+		workers = 1
+	}
+	jobs := make(chan string)
+	results := make(chan searchResult)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range jobs {
+				matches, err := saturn.SearchBook(file, re, contextWidth)
+				results <- searchResult{file, matches, err}
+			}
+		}()
+	}
+	go func() {
+		for _, file := range files {
+			jobs <- file
+		}
+		close(jobs)
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+func printMatches(w io.Writer, matches []saturn.BookMatch, jsonOutput bool) error {
+	encoder := json.NewEncoder(w)
+	for _, match := range matches {
+		if jsonOutput {
+			if err := encoder.Encode(match); err != nil {
+				return err
+			}
+			continue
+		}
+		_, err := fmt.Fprintf(w, "%s [%s] %s:%d: %s\n", match.Title, match.Section,
+			filepath.Base(match.File), match.Line, strings.Join(strings.Fields(match.Context), " "))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
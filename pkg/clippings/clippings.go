@@ -0,0 +1,53 @@
+// Package clippings parses the highlights exported by other ebook readers
+package clippings
+
+import "strings"
+
+type Kind string
+
+const (
+	KindHighlight Kind = "highlight"
+	KindNote      Kind = "note"
+	KindBookmark  Kind = "bookmark"
+)
+
+// Clipping is a highlight made in another reader, Note is the comment of the
+// reader on the highlighted Text
+type Clipping struct {
+	BookTitle string
+	Author    string
+	Kind      Kind
+	Text      string
+	Note      string
+	// Style is the way the reader draws the highlight, e.g. "underscore"
+	Style string
+	Color string
+	// Date is in RFC3339 if it could be parsed, the original text otherwise
+	Date    string
+	Chapter string
+	// Location is the start and end location of Kindle clippings, zero for
+	// the other readers
+	Location [2]int
+}
+
+// ForBook keeps the clippings whose book title matches the title, the case
+// and the subtitles are ignored
+func ForBook(clippings []Clipping, title string) []Clipping {
+	want := normalizeTitle(title)
+	result := []Clipping{}
+	for _, c := range clippings {
+		got := normalizeTitle(c.BookTitle)
+		if got != "" && got == want {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// normalizeTitle drops the subtitle after ":", the case and the extra spaces
+func normalizeTitle(title string) string {
+	if i := strings.Index(title, ":"); i >= 0 {
+		title = title[:i]
+	}
+	return strings.Join(strings.Fields(strings.ToLower(title)), " ")
+}
@@ -0,0 +1,111 @@
+package clippings
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// kindleSeparator ends every clipping in "My Clippings.txt"
+const kindleSeparator = "=========="
+
+var (
+	kindleKindRe     = regexp.MustCompile(`(?i)^-\s*Your (Highlight|Note|Bookmark)`)
+	kindleLocationRe = regexp.MustCompile(`(?i)Location (\d+)(?:-(\d+))?`)
+	kindleAuthorRe   = regexp.MustCompile(`^(.*)\s+\(([^()]*)\)$`)
+	kindleDateLayout = "Monday, January 2, 2006 3:04:05 PM"
+)
+
+// ParseKindle parses the "My Clippings.txt" of a Kindle, the notes are merged
+// into the highlight they were made on and the bookmarks are dropped.
+func ParseKindle(r io.Reader) ([]Clipping, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	clippings := []Clipping{}
+	lines := []string{}
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) != kindleSeparator {
+			lines = append(lines, line)
+			continue
+		}
+		if clipping, ok := parseKindleClipping(lines); ok {
+			clippings = append(clippings, clipping)
+		}
+		lines = lines[:0]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mergeKindleNotes(clippings), nil
+}
+
+// parseKindleClipping parses the lines between two separators: the title, the
+// metadata, an empty line and the text
+func parseKindleClipping(lines []string) (Clipping, bool) {
+	if len(lines) < 2 {
+		return Clipping{}, false
+	}
+	c := Clipping{BookTitle: strings.TrimSpace(strings.TrimPrefix(lines[0], "\ufeff"))}
+	if m := kindleAuthorRe.FindStringSubmatch(c.BookTitle); m != nil {
+		c.BookTitle, c.Author = m[1], m[2]
+	}
+	m := kindleKindRe.FindStringSubmatch(lines[1])
+	if m == nil {
+		return Clipping{}, false
+	}
+	c.Kind = Kind(strings.ToLower(m[1]))
+	if m := kindleLocationRe.FindStringSubmatch(lines[1]); m != nil {
+		c.Location[0], _ = strconv.Atoi(m[1])
+		c.Location[1] = c.Location[0]
+		if m[2] != "" {
+			c.Location[1], _ = strconv.Atoi(m[2])
+		}
+	}
+	if i := strings.Index(lines[1], "Added on "); i >= 0 {
+		c.Date = parseDate(strings.TrimSpace(lines[1][i+len("Added on "):]), kindleDateLayout)
+	}
+	c.Text = strings.TrimSpace(strings.Join(lines[2:], "\n"))
+	return c, true
+}
+
+// mergeKindleNotes puts each note onto the highlight of the same book ending
+// at its location, the notes without a highlight are kept as they are
+func mergeKindleNotes(clippings []Clipping) []Clipping {
+	result := []Clipping{}
+	for _, c := range clippings {
+		switch c.Kind {
+		case KindBookmark:
+			continue
+		case KindNote:
+			merged := false
+			for i := len(result) - 1; i >= 0; i-- {
+				h := &result[i]
+				if h.Kind == KindHighlight && h.BookTitle == c.BookTitle && h.Note == "" &&
+					c.Location[0] >= h.Location[0] && c.Location[0] <= h.Location[1] {
+					h.Note = c.Text
+					merged = true
+					break
+				}
+			}
+			if merged {
+				continue
+			}
+		}
+		result = append(result, c)
+	}
+	return result
+}
+
+// parseDate converts the date to RFC3339, the text is returned as it is if it
+// isn't in the layout
+func parseDate(text, layout string) string {
+	t, err := time.ParseInLocation(layout, text, time.Local)
+	if err != nil {
+		return text
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,83 @@
+package clippings
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const kindleClippings = "\ufeffTao Te Ching (Lao Tzu)\r\n" +
+	"- Your Highlight on page 1 | Location 10-12 | Added on Sunday, March 1, 2020 10:00:00 PM\r\n" +
+	"\r\n" +
+	"The way you can go isn't the real way.\r\n" +
+	"==========\r\n" +
+	"Tao Te Ching (Lao Tzu)\r\n" +
+	"- Your Note on page 1 | Location 12 | Added on Sunday, March 1, 2020 10:01:00 PM\r\n" +
+	"\r\n" +
+	"a note\r\n" +
+	"==========\r\n" +
+	"Tao Te Ching (Lao Tzu)\r\n" +
+	"- Your Bookmark on page 2 | Location 20 | Added on Sunday, March 1, 2020 10:02:00 PM\r\n" +
+	"\r\n" +
+	"\r\n" +
+	"==========\r\n" +
+	"Another Book\r\n" +
+	"- Your Highlight on Location 5 | Added on someday\r\n" +
+	"\r\n" +
+	"Line one\r\n" +
+	"line two\r\n" +
+	"==========\r\n"
+
+func TestParseKindle(t *testing.T) {
+	clippings, err := ParseKindle(strings.NewReader(kindleClippings))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clippings) != 2 {
+		t.Fatalf("got %d clippings, expect 2: %v", len(clippings), clippings)
+	}
+	first := clippings[0]
+	first.Date = ""
+	expect := Clipping{
+		BookTitle: "Tao Te Ching",
+		Author:    "Lao Tzu",
+		Kind:      KindHighlight,
+		Text:      "The way you can go isn't the real way.",
+		Note:      "a note",
+		Location:  [2]int{10, 12},
+	}
+	if !reflect.DeepEqual(first, expect) {
+		t.Errorf("got %+v, expect %+v", first, expect)
+	}
+	if !strings.HasPrefix(clippings[0].Date, "2020-03-01T22:00:00") {
+		t.Errorf("unexpected date %q", clippings[0].Date)
+	}
+	if second := clippings[1]; second.Text != "Line one\nline two" || second.Date != "someday" || second.Author != "" {
+		t.Errorf("unexpected clipping %+v", second)
+	}
+}
+
+func TestForBook(t *testing.T) {
+	clippings := []Clipping{
+		{BookTitle: "Tao Te Ching: A New English Version"},
+		{BookTitle: "tao  te ching"},
+		{BookTitle: "Another Book"},
+		{BookTitle: ""},
+		{BookTitle: "Tao"},
+		{BookTitle: "Tao Te Ching Commentary"},
+	}
+	testcases := []struct {
+		title  string
+		expect int
+	}{
+		{"Tao Te Ching", 2},
+		{"TAO TE CHING: The Book of the Way", 2},
+		{"Tao", 1},
+		{"The", 0},
+	}
+	for _, c := range testcases {
+		if got := ForBook(clippings, c.title); len(got) != c.expect {
+			t.Errorf("case %s: got %v, expect %d clippings", c.title, got, c.expect)
+		}
+	}
+}
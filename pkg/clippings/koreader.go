@@ -0,0 +1,90 @@
+package clippings
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const koreaderDateLayout = "2006-01-02 15:04:05"
+
+// ParseKOReader parses the metadata.epub.lua sidecar of a book, both the
+// `annotations` of the recent versions and the `highlight` of the older ones
+// are read.
+func ParseKOReader(r io.Reader) ([]Clipping, error) {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	value, err := parseLua(string(src))
+	if err != nil {
+		return nil, err
+	}
+	root, ok := value.(luaTable)
+	if !ok {
+		return nil, fmt.Errorf("koreader: sidecar is not a table")
+	}
+	title, author := "", ""
+	if props, ok := root["doc_props"].(luaTable); ok {
+		title, author = luaString(props, "title"), luaString(props, "authors")
+	}
+	clippings := []Clipping{}
+	if annotations, ok := root["annotations"].(luaTable); ok {
+		for _, entry := range luaList(annotations) {
+			// bookmarks of pages have no highlighted text
+			if luaString(entry, "text") == "" || entry["pos0"] == nil {
+				continue
+			}
+			clippings = append(clippings, koreaderClipping(entry, title, author, luaString(entry, "note")))
+		}
+		return clippings, nil
+	}
+	if pages, ok := root["highlight"].(luaTable); ok {
+		for _, page := range luaList(pages) {
+			for _, entry := range luaList(page) {
+				if luaString(entry, "text") != "" {
+					clippings = append(clippings, koreaderClipping(entry, title, author, ""))
+				}
+			}
+		}
+	}
+	return clippings, nil
+}
+
+func koreaderClipping(entry luaTable, title, author, note string) Clipping {
+	return Clipping{
+		BookTitle: title,
+		Author:    author,
+		Kind:      KindHighlight,
+		Text:      strings.TrimSpace(luaString(entry, "text")),
+		Note:      strings.TrimSpace(note),
+		Style:     luaString(entry, "drawer"),
+		Color:     luaString(entry, "color"),
+		Date:      parseDate(luaString(entry, "datetime"), koreaderDateLayout),
+		Chapter:   luaString(entry, "chapter"),
+	}
+}
+
+func luaString(table luaTable, key string) string {
+	s, _ := table[key].(string)
+	return s
+}
+
+// luaList returns the tables in the table ordered by their numeric keys
+func luaList(table luaTable) []luaTable {
+	keys := []int{}
+	for key, value := range table {
+		n, err := strconv.Atoi(key)
+		if _, ok := value.(luaTable); ok && err == nil {
+			keys = append(keys, n)
+		}
+	}
+	sort.Ints(keys)
+	list := []luaTable{}
+	for _, n := range keys {
+		list = append(list, table[strconv.Itoa(n)].(luaTable))
+	}
+	return list
+}
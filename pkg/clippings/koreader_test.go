@@ -0,0 +1,100 @@
+package clippings
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const koreaderSidecar = `-- we can read Lua syntax here!
+return {
+    ["annotations"] = {
+        [1] = {
+            ["chapter"] = "Chapter 1",
+            ["color"] = "yellow",
+            ["datetime"] = "2023-01-02 10:00:00",
+            ["drawer"] = "lighten",
+            ["note"] = "a \"quoted\"\
+note",
+            ["pos0"] = "/body/DocFragment[2]/body/p[1]/text().0",
+            ["pos1"] = "/body/DocFragment[2]/body/p[1]/text().12",
+            ["text"] = "The way you can go",
+        },
+        [2] = {
+            ["datetime"] = "2023-01-02 10:05:00",
+            ["page"] = 3,
+        },
+        [3] = {
+            ["drawer"] = "underscore",
+            ["pos0"] = "/body/DocFragment[3]/body/p[2]/text().0",
+            ["text"] = "isn't the real way",
+        },
+    },
+    ["doc_props"] = {
+        ["authors"] = "Lao Tzu",
+        ["title"] = "Tao Te Ching",
+    },
+    ["percent_finished"] = 0.25,
+    ["summary"] = { ["status"] = "reading", },
+    flags = { true, false, nil, -1, 0x10 },
+}
+`
+
+func TestParseKOReader(t *testing.T) {
+	clippings, err := ParseKOReader(strings.NewReader(koreaderSidecar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clippings) != 2 {
+		t.Fatalf("got %d clippings, expect 2: %v", len(clippings), clippings)
+	}
+	first := clippings[0]
+	first.Date = ""
+	expect := Clipping{
+		BookTitle: "Tao Te Ching",
+		Author:    "Lao Tzu",
+		Kind:      KindHighlight,
+		Text:      "The way you can go",
+		Note:      "a \"quoted\"\nnote",
+		Style:     "lighten",
+		Color:     "yellow",
+		Chapter:   "Chapter 1",
+	}
+	if !reflect.DeepEqual(first, expect) {
+		t.Errorf("got %+v, expect %+v", first, expect)
+	}
+	if clippings[1].Style != "underscore" || clippings[1].Date != "" {
+		t.Errorf("unexpected clipping %+v", clippings[1])
+	}
+}
+
+func TestParseKOReaderHighlight(t *testing.T) {
+	sidecar := `return {
+    ["highlight"] = {
+        [12] = { [1] = { ["text"] = "second page", ["drawer"] = "lighten" } },
+        [3] = {
+            [1] = { ["text"] = "first", ["datetime"] = "2020-01-01 08:00:00" },
+            [2] = { ["text"] = "second" },
+        },
+    },
+}`
+	clippings, err := ParseKOReader(strings.NewReader(sidecar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, c := range clippings {
+		got = append(got, c.Text)
+	}
+	if expect := []string{"first", "second", "second page"}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("got %v, expect %v", got, expect)
+	}
+}
+
+func TestParseLuaError(t *testing.T) {
+	for _, src := range []string{`return {`, `return { ["a"] = "b }`, `return { a = @ }`, `return {} x`} {
+		if _, err := parseLua(src); err == nil {
+			t.Errorf("expect error for %q", src)
+		}
+	}
+}
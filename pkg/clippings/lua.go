@@ -0,0 +1,219 @@
+package clippings
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// luaTable is a table of the sidecar files, the keys are converted to
+// strings so `[1]` and `["1"]` are the same key
+type luaTable map[string]interface{}
+
+// luaParser reads the subset of Lua used by the KOReader sidecar files:
+// `return` followed by a table of tables, strings, numbers and booleans.
+type luaParser struct {
+	src []rune
+	pos int
+}
+
+func parseLua(src string) (interface{}, error) {
+	p := &luaParser{src: []rune(src)}
+	p.skipSpace()
+	if p.hasPrefix("return") {
+		p.pos += len("return")
+	}
+	value, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return nil, p.errorf("unexpected %q", p.src[p.pos])
+	}
+	return value, nil
+}
+
+func (p *luaParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("lua: offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *luaParser) hasPrefix(s string) bool {
+	return strings.HasPrefix(string(p.src[p.pos:]), s)
+}
+
+// skipSpace skips the white spaces and the comments
+func (p *luaParser) skipSpace() {
+	for p.pos < len(p.src) {
+		if unicode.IsSpace(p.src[p.pos]) {
+			p.pos++
+		} else if p.hasPrefix("--") {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		} else {
+			return
+		}
+	}
+}
+
+func (p *luaParser) value() (interface{}, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return nil, p.errorf("unexpected end")
+	}
+	switch c := p.src[p.pos]; {
+	case c == '{':
+		return p.table()
+	case c == '"' || c == '\'':
+		return p.str()
+	case c == '-' || c == '.' || unicode.IsDigit(c):
+		return p.number()
+	case unicode.IsLetter(c):
+		name := p.name()
+		switch name {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "nil":
+			return nil, nil
+		}
+		return nil, p.errorf("unexpected name %q", name)
+	default:
+		return nil, p.errorf("unexpected %q", c)
+	}
+}
+
+func (p *luaParser) table() (luaTable, error) {
+	p.pos++ // {
+	table := luaTable{}
+	index := 1
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			return nil, p.errorf("unclosed table")
+		}
+		if p.src[p.pos] == '}' {
+			p.pos++
+			return table, nil
+		}
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		if key == "" {
+			key = strconv.Itoa(index)
+			index++
+		}
+		table[key] = value
+		p.skipSpace()
+		if p.pos < len(p.src) && (p.src[p.pos] == ',' || p.src[p.pos] == ';') {
+			p.pos++
+		}
+	}
+}
+
+// key reads `[key] =` or `name =`, an empty key is returned for the values
+// without a key
+func (p *luaParser) key() (string, error) {
+	start := p.pos
+	var key string
+	switch {
+	case p.src[p.pos] == '[':
+		p.pos++
+		value, err := p.value()
+		if err != nil {
+			return "", err
+		}
+		key = fmt.Sprint(value)
+		p.skipSpace()
+		if p.pos >= len(p.src) || p.src[p.pos] != ']' {
+			return "", p.errorf("expect ]")
+		}
+		p.pos++
+	case unicode.IsLetter(p.src[p.pos]) || p.src[p.pos] == '_':
+		key = p.name()
+	default:
+		return "", nil
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) && p.src[p.pos] == '=' {
+		p.pos++
+		return key, nil
+	}
+	// not a key, e.g. a value `true` in a list
+	p.pos = start
+	return "", nil
+}
+
+func (p *luaParser) name() string {
+	start := p.pos
+	for p.pos < len(p.src) && (unicode.IsLetter(p.src[p.pos]) || unicode.IsDigit(p.src[p.pos]) || p.src[p.pos] == '_') {
+		p.pos++
+	}
+	return string(p.src[start:p.pos])
+}
+
+func (p *luaParser) number() (interface{}, error) {
+	start := p.pos
+	for p.pos < len(p.src) && strings.ContainsRune("+-.0123456789eExXabcdefABCDEF", p.src[p.pos]) {
+		p.pos++
+	}
+	text := string(p.src[start:p.pos])
+	if n, err := strconv.ParseInt(text, 0, 64); err == nil {
+		return n, nil
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, p.errorf("invalid number %q", text)
+	}
+	return f, nil
+}
+
+func (p *luaParser) str() (string, error) {
+	quote := p.src[p.pos]
+	p.pos++
+	var b strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		p.pos++
+		switch {
+		case c == quote:
+			return b.String(), nil
+		case c == '\\' && p.pos < len(p.src):
+			e := p.src[p.pos]
+			p.pos++
+			switch e {
+			case 'n':
+				b.WriteRune('\n')
+			case 't':
+				b.WriteRune('\t')
+			case 'r':
+				b.WriteRune('\r')
+			case '\n':
+				b.WriteRune('\n')
+			default:
+				if unicode.IsDigit(e) {
+					// \ddd is a byte in decimal
+					n := int(e - '0')
+					for i := 0; i < 2 && p.pos < len(p.src) && unicode.IsDigit(p.src[p.pos]); i++ {
+						n = n*10 + int(p.src[p.pos]-'0')
+						p.pos++
+					}
+					b.WriteByte(byte(n))
+				} else {
+					b.WriteRune(e)
+				}
+			}
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return "", p.errorf("unclosed string")
+}
@@ -0,0 +1,279 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+)
+
+// Config is the user configuration read from config.toml, the options left
+// out keep their defaults
+type Config struct {
+	Keys    Keys             `toml:"keys"`
+	Styles  map[string]Style `toml:"styles"`
+	Reader  Reader           `toml:"reader"`
+	Cache   Cache            `toml:"cache"`
+	Library Library          `toml:"library"`
+	Export  Export           `toml:"export"`
+}
+
+// Keys rebinds the actions of the reader, each action is bound to all the
+// keys of its list, the actions left out keep their default keys
+type Keys struct {
+	PageDown        []string `toml:"page_down"`
+	PageUp          []string `toml:"page_up"`
+	HalfPageUp      []string `toml:"half_page_up"`
+	HalfPageDown    []string `toml:"half_page_down"`
+	Down            []string `toml:"down"`
+	Up              []string `toml:"up"`
+	Top             []string `toml:"top"`
+	Bottom          []string `toml:"bottom"`
+	ChapterForward  []string `toml:"chapter_forward"`
+	ChapterBackward []string `toml:"chapter_backward"`
+}
+
+// Actions returns the keys of each action by its name in the file, nil if
+// the action isn't rebound
+func (k Keys) Actions() map[string][]string {
+	return map[string][]string{
+		"page_down":        k.PageDown,
+		"page_up":          k.PageUp,
+		"half_page_up":     k.HalfPageUp,
+		"half_page_down":   k.HalfPageDown,
+		"down":             k.Down,
+		"up":               k.Up,
+		"top":              k.Top,
+		"bottom":           k.Bottom,
+		"chapter_forward":  k.ChapterForward,
+		"chapter_backward": k.ChapterBackward,
+	}
+}
+
+// Style is the style of a tag or the gutter, the colors are ANSI numbers
+// (0-255) or hex(#rgb or #rrggbb), the unset attributes are inherited. A
+// color can list alternatives separated by spaces, the first one the
+// terminal supports is used.
+type Style struct {
+	Foreground string `toml:"foreground"`
+	Background string `toml:"background"`
+	Bold       *bool  `toml:"bold"`
+	Italic     *bool  `toml:"italic"`
+	Underline  *bool  `toml:"underline"`
+	Reverse    *bool  `toml:"reverse"`
+}
+
+// Merge returns the style with the attributes set in o replaced
+func (s Style) Merge(o Style) Style {
+	if o.Foreground != "" {
+		s.Foreground = o.Foreground
+	}
+	if o.Background != "" {
+		s.Background = o.Background
+	}
+	if o.Bold != nil {
+		s.Bold = o.Bold
+	}
+	if o.Italic != nil {
+		s.Italic = o.Italic
+	}
+	if o.Underline != nil {
+		s.Underline = o.Underline
+	}
+	if o.Reverse != nil {
+		s.Reverse = o.Reverse
+	}
+	return s
+}
+
+// Bool returns a pointer to b for the attributes of Style
+func Bool(b bool) *bool {
+	return &b
+}
+
+// Reader sets how the text is laid out and scrolled
+type Reader struct {
+	// LineNumbers shows the line number gutter
+	LineNumbers bool `toml:"line_numbers"`
+	// WrapWidth is the widest the text is wrapped at, 0 fills the screen
+	WrapWidth int `toml:"wrap_width"`
+	// Margin is the number of blank columns on the left of the text
+	Margin int `toml:"margin"`
+	// MouseWheelDelta is the number of lines scrolled by the mouse wheel
+	MouseWheelDelta int `toml:"mouse_wheel_delta"`
+	// Theme is the name of the built-in theme the reader starts with
+	Theme string `toml:"theme"`
+}
+
+// Cache sets the cache of the parsed books in the cache directory
+type Cache struct {
+	// Enabled keeps the parsed books and their layouts to open them faster
+	// next time
+	Enabled bool `toml:"enabled"`
+	// MaxSizeMB is the most the cache takes on disk, the least recently used
+	// files are removed first
+	MaxSizeMB int `toml:"max_size_mb"`
+}
+
+// Library sets where the books of the library are looked for
+type Library struct {
+	// Dirs are scanned for the epub files recursively when saturn starts
+	// without a book, ~ is the home directory
+	Dirs []string `toml:"dirs"`
+}
+
+// Export sets where the notes are exported from the annotation browser
+type Export struct {
+	// Dir keeps the exported notes, ~ is the home directory. The notes
+	// directory of the data directory is used if it's empty.
+	Dir string `toml:"dir"`
+}
+
+// minWrapWidth keeps the wrap width usable
+const minWrapWidth = 10
+
+// Default returns the configuration used without a config file
+func Default() Config {
+	return Config{
+		Styles: map[string]Style{},
+		Reader: Reader{
+			LineNumbers:     true,
+			MouseWheelDelta: 3,
+			Theme:           "dark",
+		},
+		Cache: Cache{
+			Enabled:   true,
+			MaxSizeMB: 200,
+		},
+	}
+}
+
+// Load reads the configuration file, the default configuration is returned
+// if the file doesn't exist
+func Load(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Default(), nil
+	} else if err != nil {
+		return Config{}, errors.Wrap(err, "failed to read config")
+	}
+	cfg, err := Parse(string(data))
+	if err != nil {
+		return Config{}, errors.Wrapf(err, "invalid config %s", path)
+	}
+	return cfg, nil
+}
+
+// Parse decodes the configuration over the default one and validates it
+func Parse(data string) (Config, error) {
+	cfg := Default()
+	meta, err := toml.Decode(data, &cfg)
+	if err != nil {
+		return Config{}, err
+	}
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		keys := []string{}
+		for _, key := range undecoded {
+			keys = append(keys, key.String())
+		}
+		return Config{}, errors.Errorf("unknown options: %s", strings.Join(keys, ", "))
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// ValidationError lists all the problems found in a configuration
+type ValidationError []string
+
+func (e ValidationError) Error() string {
+	return strings.Join(e, "; ")
+}
+
+var hexColor = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// validColor tells whether every alternative of the color is an ANSI number
+// or a hex color
+func validColor(color string) bool {
+	alternatives := strings.Fields(color)
+	for _, c := range alternatives {
+		if hexColor.MatchString(c) {
+			continue
+		}
+		if n, err := strconv.Atoi(c); err != nil || n < 0 || n > 255 {
+			return false
+		}
+	}
+	return len(alternatives) > 0
+}
+
+// Validate checks the values of the configuration, the names of the styles
+// and the conflicts with the default keys are checked by the reader which
+// knows them
+func (c Config) Validate() error {
+	var problems ValidationError
+	actions := c.Keys.Actions()
+	names := make([]string, 0, len(actions))
+	for name := range actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		keys := actions[name]
+		if keys == nil {
+			continue
+		}
+		if len(keys) == 0 {
+			problems = append(problems, fmt.Sprintf("keys.%s: no key bound", name))
+		}
+		for _, key := range keys {
+			if key == "" {
+				problems = append(problems, fmt.Sprintf("keys.%s: empty key", name))
+			}
+		}
+	}
+	styles := make([]string, 0, len(c.Styles))
+	for name := range c.Styles {
+		styles = append(styles, name)
+	}
+	sort.Strings(styles)
+	for _, name := range styles {
+		style := c.Styles[name]
+		for _, color := range []struct{ attr, value string }{
+			{"foreground", style.Foreground},
+			{"background", style.Background},
+		} {
+			if color.value != "" && !validColor(color.value) {
+				problems = append(problems, fmt.Sprintf("styles.%s.%s: invalid color %q, expect 0-255 or #rrggbb",
+					name, color.attr, color.value))
+			}
+		}
+	}
+	if c.Reader.WrapWidth != 0 && c.Reader.WrapWidth < minWrapWidth {
+		problems = append(problems, fmt.Sprintf("reader.wrap_width: %d is less than %d", c.Reader.WrapWidth, minWrapWidth))
+	}
+	if c.Reader.Margin < 0 {
+		problems = append(problems, fmt.Sprintf("reader.margin: %d is negative", c.Reader.Margin))
+	}
+	if c.Reader.MouseWheelDelta < 1 {
+		problems = append(problems, fmt.Sprintf("reader.mouse_wheel_delta: %d is less than 1", c.Reader.MouseWheelDelta))
+	}
+	if c.Cache.MaxSizeMB < 1 {
+		problems = append(problems, fmt.Sprintf("cache.max_size_mb: %d is less than 1", c.Cache.MaxSizeMB))
+	}
+	for i, dir := range c.Library.Dirs {
+		if strings.TrimSpace(dir) == "" {
+			problems = append(problems, fmt.Sprintf("library.dirs: directory %d is empty", i+1))
+		}
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	testcases := []struct {
+		name   string
+		data   string
+		expect func(c *Config)
+	}{
+		{
+			name:   "empty",
+			data:   "",
+			expect: func(c *Config) {},
+		},
+		{
+			name: "all sections",
+			data: `
+[keys]
+down = ["j", "ctrl+n"]
+top = ["home"]
+
+[styles.h1]
+foreground = "#ff8800"
+bold = false
+
+[styles.linum]
+background = "236"
+
+[styles.p]
+foreground = "#5f4b32 95 3"
+
+[reader]
+line_numbers = false
+theme = "sepia"
+wrap_width = 80
+margin = 2
+mouse_wheel_delta = 1
+
+[cache]
+enabled = false
+max_size_mb = 50
+
+[library]
+dirs = ["~/Books", "/media/books"]
+
+[export]
+dir = "~/notes"
+`,
+			expect: func(c *Config) {
+				c.Keys.Down = []string{"j", "ctrl+n"}
+				c.Keys.Top = []string{"home"}
+				c.Styles["h1"] = Style{Foreground: "#ff8800", Bold: Bool(false)}
+				c.Styles["linum"] = Style{Background: "236"}
+				c.Styles["p"] = Style{Foreground: "#5f4b32 95 3"}
+				c.Reader = Reader{LineNumbers: false, WrapWidth: 80, Margin: 2, MouseWheelDelta: 1, Theme: "sepia"}
+				c.Cache = Cache{Enabled: false, MaxSizeMB: 50}
+				c.Library = Library{Dirs: []string{"~/Books", "/media/books"}}
+				c.Export = Export{Dir: "~/notes"}
+			},
+		},
+		{
+			name: "partial reader",
+			data: "[reader]\nmargin = 4\n",
+			expect: func(c *Config) {
+				c.Reader.Margin = 4
+			},
+		},
+	}
+	for _, c := range testcases {
+		got, err := Parse(c.data)
+		if err != nil {
+			t.Errorf("case %s: unexpected error %v", c.name, err)
+			continue
+		}
+		expect := Default()
+		c.expect(&expect)
+		if !reflect.DeepEqual(got, expect) {
+			t.Errorf("case %s: got %+v, expect %+v", c.name, got, expect)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	testcases := []struct {
+		name   string
+		data   string
+		expect []string
+	}{
+		{"syntax", "[reader\n", []string{"line 2"}},
+		{"type", "[reader]\nmargin = \"2\"\n", []string{"margin"}},
+		{"unknown action", "[keys]\nfly = [\"x\"]\n", []string{"unknown options: keys.fly"}},
+		{"unknown section", "[colors]\nfg = 1\n", []string{"unknown options: colors"}},
+		{"no key", "[keys]\nup = []\n", []string{"keys.up: no key bound"}},
+		{"empty key", "[keys]\nup = [\"\"]\n", []string{"keys.up: empty key"}},
+		{
+			"colors",
+			"[styles.p]\nforeground = \"blue\"\nbackground = \"256\"\n",
+			[]string{`styles.p.foreground: invalid color "blue"`, `styles.p.background: invalid color "256"`},
+		},
+		{"alternatives", "[styles.a]\nforeground = \"#5f4b32 blue\"\n", []string{`styles.a.foreground: invalid color "#5f4b32 blue"`}},
+		{
+			"reader",
+			"[reader]\nwrap_width = 5\nmargin = -1\nmouse_wheel_delta = 0\n",
+			[]string{"reader.wrap_width: 5 is less than 10", "reader.margin: -1 is negative",
+				"reader.mouse_wheel_delta: 0 is less than 1"},
+		},
+		{"cache", "[cache]\nmax_size_mb = 0\n", []string{"cache.max_size_mb: 0 is less than 1"}},
+		{"library", "[library]\ndirs = [\"~/Books\", \" \"]\n", []string{"library.dirs: directory 2 is empty"}},
+	}
+	for _, c := range testcases {
+		_, err := Parse(c.data)
+		if err == nil {
+			t.Errorf("case %s: got no error, expect %v", c.name, c.expect)
+			continue
+		}
+		for _, expect := range c.expect {
+			if !strings.Contains(err.Error(), expect) {
+				t.Errorf("case %s: got %v, expect %s", c.name, err, expect)
+			}
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	cfg, err := Load(filepath.Join(dir, "missing.toml"))
+	if err != nil || !reflect.DeepEqual(cfg, Default()) {
+		t.Errorf("missing file: got %+v %v, expect the default", cfg, err)
+	}
+	path := filepath.Join(dir, "config.toml")
+	if err := os.WriteFile(path, []byte("[reader]\nmargin = -2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), path) {
+		t.Errorf("invalid file: got %v, expect the error to name %s", err, path)
+	}
+}
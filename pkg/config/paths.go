@@ -0,0 +1,116 @@
+// Package config resolves where saturn keeps its files and reads its settings
+package config
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// appName is the directory of saturn under each base directory
+const appName = "saturn"
+
+const (
+	dbFilename     = "saturn.db"
+	logFilename    = "saturn.log"
+	configFilename = "config.toml"
+	// bookCacheDirname is the directory of the cached books in CacheDir
+	bookCacheDirname = "books"
+	// notesDirname is the directory of the exported notes in DataDir
+	notesDirname = "notes"
+)
+
+// Paths are the locations of the files of saturn
+type Paths struct {
+	// DataDir keeps the annotation database
+	DataDir string
+	// ConfigDir keeps the configuration file
+	ConfigDir string
+	// CacheDir keeps the files which can be rebuilt
+	CacheDir string
+	// LogFile is where the log is written
+	LogFile string
+}
+
+// Overrides come from the command line flags, the empty ones are ignored
+type Overrides struct {
+	DataDir   string
+	ConfigDir string
+	CacheDir  string
+	LogFile   string
+}
+
+// ResolvePaths resolves the paths with the environment of the process
+func ResolvePaths(o Overrides) (Paths, error) {
+	return resolvePaths(o, os.Getenv)
+}
+
+// resolvePaths picks each path from the first of: the override, the
+// SATURN_* variable, the XDG base directory variable, the XDG default under
+// the home directory
+func resolvePaths(o Overrides, getenv func(string) string) (Paths, error) {
+	home := getenv("HOME")
+	if home == "" {
+		var err error
+		if home, err = os.UserHomeDir(); err != nil {
+			return Paths{}, errors.Wrap(err, "failed to find the home directory")
+		}
+	}
+	dir := func(override, env, xdgEnv string, xdgDefault ...string) string {
+		if override != "" {
+			return override
+		}
+		if v := getenv(env); v != "" {
+			return v
+		}
+		// XDG base directories must be absolute, relative ones are ignored
+		if v := getenv(xdgEnv); filepath.IsAbs(v) {
+			return filepath.Join(v, appName)
+		}
+		return filepath.Join(append(append([]string{home}, xdgDefault...), appName)...)
+	}
+	paths := Paths{
+		DataDir:   dir(o.DataDir, "SATURN_DATA_DIR", "XDG_DATA_HOME", ".local", "share"),
+		ConfigDir: dir(o.ConfigDir, "SATURN_CONFIG_DIR", "XDG_CONFIG_HOME", ".config"),
+		CacheDir:  dir(o.CacheDir, "SATURN_CACHE_DIR", "XDG_CACHE_HOME", ".cache"),
+	}
+	paths.LogFile = o.LogFile
+	if paths.LogFile == "" {
+		paths.LogFile = getenv("SATURN_LOG_FILE")
+	}
+	if paths.LogFile == "" {
+		paths.LogFile = filepath.Join(dir("", "SATURN_STATE_DIR", "XDG_STATE_HOME", ".local", "state"), logFilename)
+	}
+	return paths, nil
+}
+
+// DBPath returns the path of the annotation database
+func (p Paths) DBPath() string {
+	return filepath.Join(p.DataDir, dbFilename)
+}
+
+// BookCacheDir returns the directory of the cached books
+func (p Paths) BookCacheDir() string {
+	return filepath.Join(p.CacheDir, bookCacheDirname)
+}
+
+// NotesDir returns the directory the notes are exported to by default
+func (p Paths) NotesDir() string {
+	return filepath.Join(p.DataDir, notesDirname)
+}
+
+// ConfigFile returns the path of the configuration file
+func (p Paths) ConfigFile() string {
+	return filepath.Join(p.ConfigDir, configFilename)
+}
+
+// EnsureDirs creates the directories of the paths
+func (p Paths) EnsureDirs() error {
+	for _, dir := range []string{p.DataDir, p.ConfigDir, p.CacheDir, filepath.Dir(p.LogFile)} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return errors.Wrapf(err, "failed to create %s", dir)
+		}
+	}
+	return nil
+}
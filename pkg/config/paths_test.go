@@ -0,0 +1,75 @@
+package config
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestResolvePaths(t *testing.T) {
+	testcases := []struct {
+		name      string
+		env       map[string]string
+		overrides Overrides
+		expect    Paths
+	}{
+		{
+			name: "defaults",
+			env:  map[string]string{"HOME": "/home/u"},
+			expect: Paths{
+				DataDir:   "/home/u/.local/share/saturn",
+				ConfigDir: "/home/u/.config/saturn",
+				CacheDir:  "/home/u/.cache/saturn",
+				LogFile:   "/home/u/.local/state/saturn/saturn.log",
+			},
+		},
+		{
+			name: "xdg",
+			env: map[string]string{
+				"HOME":            "/home/u",
+				"XDG_DATA_HOME":   "/data",
+				"XDG_CONFIG_HOME": "/config",
+				"XDG_CACHE_HOME":  "relative/is/ignored",
+				"XDG_STATE_HOME":  "/state",
+			},
+			expect: Paths{
+				DataDir:   "/data/saturn",
+				ConfigDir: "/config/saturn",
+				CacheDir:  "/home/u/.cache/saturn",
+				LogFile:   "/state/saturn/saturn.log",
+			},
+		},
+		{
+			name: "saturn variables and overrides",
+			env: map[string]string{
+				"HOME":             "/home/u",
+				"XDG_DATA_HOME":    "/data",
+				"SATURN_DATA_DIR":  "/saturn/data",
+				"SATURN_CACHE_DIR": "/saturn/cache",
+				"SATURN_LOG_FILE":  "/saturn/log",
+			},
+			overrides: Overrides{CacheDir: "/flag/cache", LogFile: "/flag/log"},
+			expect: Paths{
+				DataDir:   "/saturn/data",
+				ConfigDir: "/home/u/.config/saturn",
+				CacheDir:  "/flag/cache",
+				LogFile:   "/flag/log",
+			},
+		},
+	}
+	for _, tc := range testcases {
+		paths, err := resolvePaths(tc.overrides, func(key string) string { return tc.env[key] })
+		if err != nil {
+			t.Errorf("case %s: %v", tc.name, err)
+		} else if !reflect.DeepEqual(paths, tc.expect) {
+			t.Errorf("case %s: got %+v, expect %+v", tc.name, paths, tc.expect)
+		}
+	}
+	paths := Paths{DataDir: "/data/saturn"}
+	if db := paths.DBPath(); db != filepath.Join("/data/saturn", "saturn.db") {
+		t.Errorf("unexpected db path %s", db)
+	}
+	if notes := paths.NotesDir(); notes != filepath.Join("/data/saturn", "notes") {
+		t.Errorf("unexpected notes dir %s", notes)
+	}
+}
@@ -10,13 +10,17 @@ const (
 
 type AnnotationOption func(*Annotation)
 
+// Annotation covers the text from Start to End(exclusive), the positions don't
+// depend on the width of the terminal. Prefix and Suffix are the text around
+// the quote, they are used to find the text again if the positions are lost.
 type Annotation struct {
+	ID      int64          `json:"id"`
 	Type    AnnotationType `json:"type"`
 	Text    string         `json:"text"`
-	StartX  int            `json:"startx"`
-	StartY  int            `json:"starty"`
-	EndX    int            `json:"endx"`
-	EndY    int            `json:"endy"`
+	Start   Position       `json:"start"`
+	End     Position       `json:"end"`
+	Prefix  string         `json:"prefix"`
+	Suffix  string         `json:"suffix"`
 	Color   string         `json:"color"`
 	Author  string         `json:"author"`
 	Date    string         `json:"date"`
@@ -37,11 +41,34 @@ func WithComment(comment string) AnnotationOption {
 	}
 }
 
-func WithLocation(startx, starty, endx, endy int) AnnotationOption {
+func WithColor(color string) AnnotationOption {
 	return func(a *Annotation) {
-		a.StartX = startx
-		a.StartY = starty
-		a.EndX = endx
-		a.EndY = endy
+		a.Color = color
 	}
 }
+
+func WithDate(date string) AnnotationOption {
+	return func(a *Annotation) {
+		a.Date = date
+	}
+}
+
+func WithRange(start, end Position) AnnotationOption {
+	return func(a *Annotation) {
+		a.Start = start
+		a.End = end
+	}
+}
+
+func WithContext(prefix, suffix string) AnnotationOption {
+	return func(a *Annotation) {
+		a.Prefix = prefix
+		a.Suffix = suffix
+	}
+}
+
+// Anchored returns false for the annotations made before they were anchored
+// on the text, they only had the screen coordinates
+func (a Annotation) Anchored() bool {
+	return a.Start.ManifestId != ""
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+// newLegacyDb creates the database with the statements before the schema was
+// versioned
+func newLegacyDb(t *testing.T, statements string) string {
+	path := filepath.Join(t.TempDir(), "db.sqlite")
+	legacy, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer legacy.Close()
+	if _, err := legacy.Exec(statements); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMigrateScreenCoordinates(t *testing.T) {
+	// the table of annotations with screen coordinates only
+	path := newLegacyDb(t, `
+		CREATE TABLE book (id INTEGER PRIMARY KEY, type TEXT, text TEXT,
+			startx Integer, starty Integer, endx Integer, endy Integer,
+			color TEXT, author TEXT, date TEXT, comment TEXT);
+		INSERT INTO book (type, text, startx, starty, endx, endy)
+		VALUES ('Highlight', 'the real way', 1, 2, 3, 2);
+	`)
+	db, err := NewDb(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Run(Book{Title: "book"}); err != nil {
+		t.Fatal(err)
+	}
+	annos, err := db.UnanchoredAnnotations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(annos) != 1 || annos[0].Text != "the real way" || annos[0].Anchored() {
+		t.Fatalf("unexpected annotations: %v", annos)
+	}
+	anno := annos[0]
+	anno.Start = Position{"c1", 0, 25}
+	anno.End = Position{"c1", 0, 37}
+	if err := db.UpdateAnchor(anno); err != nil {
+		t.Fatal(err)
+	}
+	if annos, err := db.UnanchoredAnnotations(); err != nil || len(annos) != 0 {
+		t.Errorf("expect all annotations anchored, got %v, %v", annos, err)
+	}
+}
+
+func TestUpdateAnnotation(t *testing.T) {
+	db, err := NewDb(filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Run(Book{Title: "book"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{"the real way", "the real name"} {
+		anno := NewAnnotation(AnnotationHighlight, text, WithRange(Position{"c1", 0, 25}, Position{"c1", 0, 37}))
+		if err := db.Insert(anno); err != nil {
+			t.Fatal(err)
+		}
+	}
+	anno, found, err := db.Annotation(1)
+	if err != nil || !found || anno.Text != "the real way" {
+		t.Fatalf("got %v, %v, %v", anno, found, err)
+	}
+	anno.Type, anno.Color, anno.Comment = AnnotationComment, "2", "a note"
+	if err := db.UpdateAnnotation(anno); err != nil {
+		t.Fatal(err)
+	}
+	if got, _, err := db.Annotation(1); err != nil || got != anno {
+		t.Errorf("got %v, %v, expect %v", got, err, anno)
+	}
+	if err := db.DeleteAnnotation(2); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, err := db.Annotation(2); err != nil || found {
+		t.Errorf("expect annotation 2 deleted, got %v, %v", found, err)
+	}
+	if annos, err := db.Annotations(); err != nil || len(annos) != 1 {
+		t.Errorf("expect one annotation, got %v, %v", annos, err)
+	}
+}
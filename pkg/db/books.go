@@ -0,0 +1,95 @@
+package db
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// Book identifies a book in the database, Identifier is the unique identifier
+// of the epub(usually the ISBN) and FileHash the hash of the epub file. The
+// book is found by its identifier first and by the hash if it has none.
+type Book struct {
+	Identifier string
+	FileHash   string
+	Title      string
+}
+
+// FileHash returns the sha256 of the file in hex
+func FileHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// nullIfEmpty stores the empty strings as NULL so the unique columns allow
+// many books without a value
+func nullIfEmpty(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// findBook returns the id of the book, false if it isn't in the table. The
+// book imported from a legacy table with the same title is found too.
+func (db *DB) findBook(book Book) (int64, bool, error) {
+	lookups := []struct {
+		query string
+		arg   string
+	}{
+		{`SELECT id FROM books WHERE identifier = ?;`, book.Identifier},
+		{`SELECT id FROM books WHERE file_hash = ?;`, book.FileHash},
+		{`SELECT id FROM books WHERE identifier IS NULL AND file_hash IS NULL AND title = ?;`, book.Title},
+	}
+	for _, lookup := range lookups {
+		if lookup.arg == "" {
+			continue
+		}
+		var id int64
+		err := db.db.QueryRow(lookup.query, lookup.arg).Scan(&id)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return 0, false, errors.Wrap(err, "failed to find book")
+		}
+		return id, true, nil
+	}
+	return 0, false, nil
+}
+
+// openBook returns the id of the book, the book is claimed or added if it
+// isn't in the table yet
+func (db *DB) openBook(book Book) (int64, error) {
+	id, found, err := db.findBook(book)
+	if err != nil {
+		return 0, err
+	}
+	if found {
+		// the hash changes when the file is replaced, the identifier is
+		// only filled in if there was none
+		_, err = db.db.Exec(`
+			UPDATE books SET identifier = COALESCE(identifier, ?), file_hash = ?, title = ?
+			WHERE id = ?;
+		`, nullIfEmpty(book.Identifier), nullIfEmpty(book.FileHash), book.Title, id)
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to update book")
+		}
+		return id, nil
+	}
+	result, err := db.db.Exec(`
+		INSERT INTO books (identifier, file_hash, title) VALUES (?, ?, ?);
+	`, nullIfEmpty(book.Identifier), nullIfEmpty(book.FileHash), book.Title)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to insert book")
+	}
+	return result.LastInsertId()
+}
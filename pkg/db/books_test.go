@@ -0,0 +1,30 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestFindBook(t *testing.T) {
+	db, err := NewDb(filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	book := Book{Identifier: "isbn", FileHash: "abc", Title: "Tao Te Ching"}
+	if found, err := db.Find(book); err != nil || found {
+		t.Fatalf("expect no book, got found=%v, err=%v", found, err)
+	}
+	var books int
+	if err := db.db.QueryRow(`SELECT COUNT(*) FROM books;`).Scan(&books); err != nil || books != 0 {
+		t.Fatalf("expect no book added, got %d(err=%v)", books, err)
+	}
+
+	if err := db.Run(book); err != nil {
+		t.Fatal(err)
+	}
+	id := db.bookID
+	if found, err := db.Find(Book{FileHash: "abc"}); err != nil || !found || db.bookID != id {
+		t.Errorf("got book %d(found=%v, err=%v), expect %d", db.bookID, found, err, id)
+	}
+}
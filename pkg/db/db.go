@@ -1,84 +1,211 @@
 package db
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/pkg/errors"
-	log "github.com/sirupsen/logrus"
 )
 
 type DB struct {
-	path    string
-	db      *sql.DB
-	queue   chan Annotation
-	tblName string
+	path string
+	db   *sql.DB
+
+	// bookID is the row of the book opened by Run in the books table
+	bookID int64
+
+	writer *writer
 }
 
+// NewDb opens the database and migrates its schema to the latest version
 func NewDb(dbPath string) (*DB, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+	dsn := dbPath + "?_foreign_keys=on"
+	if strings.Contains(dbPath, "?") {
+		dsn = dbPath + "&_foreign_keys=on"
+	}
+	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to open database")
 	}
-	return &DB{path: dbPath, db: db, queue: make(chan Annotation)}, nil
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	store := &DB{path: dbPath, db: db}
+	store.writer = newWriter(store)
+	return store, nil
 }
 
+// Close flushes the committed annotations and closes the database, it waits
+// closeTimeout at most for the writes
 func (db *DB) Close() error {
-	return db.db.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	return db.CloseContext(ctx)
 }
 
-func (db *DB) Commit(annotation Annotation) {
-	db.queue <- annotation
+// CloseContext flushes the committed annotations until the context is done
+// and closes the database, the annotations not written yet are lost if the
+// context is done first
+func (db *DB) CloseContext(ctx context.Context) error {
+	err := db.writer.stop(ctx)
+	if closeErr := db.db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
 }
 
-func (db *DB) Run(title string) error {
-	db.tblName = title
-	if err := db.createTables(title); err != nil {
-		return errors.Wrap(err, "failed to create tables")
+// Commit queues the annotation to be stored in the background, the failures
+// are reported by Errors. ErrNotRunning is returned before Run.
+func (db *DB) Commit(annotation Annotation) error {
+	return db.writer.commit(annotation)
+}
+
+// Errors reports the failures of the annotations written in the background,
+// it's closed once the database is closed
+func (db *DB) Errors() <-chan error {
+	return db.writer.errs
+}
+
+// Run opens the book, it is added to the books table the first time, and
+// starts storing the committed annotations
+func (db *DB) Run(book Book) error {
+	id, err := db.openBook(book)
+	if err != nil {
+		return errors.Wrap(err, "failed to open book")
 	}
-	go db.processQueue()
+	db.bookID = id
+	db.writer.start()
 	return nil
 }
 
-func (db *DB) createTables(tblName string) error {
-	_, err := db.db.Exec(fmt.Sprintf(`
-		CREATE TABLE IF NOT EXISTS %s (
-			id INTEGER PRIMARY KEY,
-			type TEXT,
-			text TEXT,
-			startx Integer,
-			starty Integer,
-			endx Integer,
-			endy Integer,
-			color TEXT,
-			author TEXT,
-			date TEXT,
-			comment TEXT
-		);
-	`, tblName))
+// Find opens the book to read its annotations, nothing is written and the
+// committed annotations aren't stored. False is returned if the book was
+// never opened.
+func (db *DB) Find(book Book) (bool, error) {
+	id, found, err := db.findBook(book)
 	if err != nil {
-		return errors.Wrap(err, "failed to create table")
+		return false, errors.Wrap(err, "failed to open book")
 	}
-	return nil
+	db.bookID = id
+	return found, nil
 }
 
-func (db *DB) processQueue() {
-	for anno := range db.queue {
-		if err := db.insertAnnotation(anno); err != nil {
-			log.Error(err)
+// Insert stores the annotations right away in one transaction, it is used to
+// import many annotations while Commit is for the ones made while reading
+func (db *DB) Insert(annos ...Annotation) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	for _, anno := range annos {
+		if err := insertAnnotation(tx, db.bookID, anno); err != nil {
+			tx.Rollback()
+			return err
 		}
 	}
+	return errors.Wrap(tx.Commit(), "failed to commit annotations")
+}
+
+// execer is implemented by both sql.DB and sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
 }
 
-func (db *DB) insertAnnotation(anno Annotation) error {
-	_, err := db.db.Exec(fmt.Sprintf(`
-		INSERT INTO %s (type, text, startx, starty, endx, endy, color, author, date, comment)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
-	`, db.tblName), anno.Type, anno.Text, anno.StartX, anno.StartY, anno.EndX, anno.EndY,
-		anno.Color, anno.Author, anno.Date, anno.Comment)
+func insertAnnotation(e execer, bookID int64, anno Annotation) error {
+	_, err := e.Exec(`
+		INSERT INTO annotations (book_id, type, text, start_manifest_id, start_line, start_rune,
+			end_manifest_id, end_line, end_rune, prefix, suffix, color, author, date, comment)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+	`, bookID, anno.Type, anno.Text,
+		anno.Start.ManifestId, anno.Start.Line, anno.Start.Rune,
+		anno.End.ManifestId, anno.End.Line, anno.End.Rune,
+		anno.Prefix, anno.Suffix, anno.Color, anno.Author, anno.Date, anno.Comment)
 	if err != nil {
 		return errors.Wrap(err, "failed to insert annotation")
 	}
 	return nil
 }
+
+// Annotations returns all the annotations of the book in the order they
+// were made
+func (db *DB) Annotations() ([]Annotation, error) {
+	return db.queryAnnotations("1 = 1")
+}
+
+// UnanchoredAnnotations returns the annotations made with screen coordinates,
+// they have to be anchored by their text with UpdateAnchor
+func (db *DB) UnanchoredAnnotations() ([]Annotation, error) {
+	return db.queryAnnotations("start_manifest_id = ''")
+}
+
+// Annotation returns the annotation with the id, false if there is none
+func (db *DB) Annotation(id int64) (Annotation, bool, error) {
+	annos, err := db.queryAnnotations("id = ?", id)
+	if err != nil || len(annos) == 0 {
+		return Annotation{}, false, err
+	}
+	return annos[0], true, nil
+}
+
+// queryAnnotations returns the annotations of the book matching the where
+// clause, the values are passed as args
+func (db *DB) queryAnnotations(where string, args ...interface{}) ([]Annotation, error) {
+	rows, err := db.db.Query(`
+		SELECT id, type, text, start_manifest_id, start_line, start_rune,
+			end_manifest_id, end_line, end_rune, prefix, suffix, color, author, date, comment
+		FROM annotations WHERE book_id = ? AND (`+where+`) ORDER BY id;
+	`, append([]interface{}{db.bookID}, args...)...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query annotations")
+	}
+	defer rows.Close()
+	annos := []Annotation{}
+	for rows.Next() {
+		var anno Annotation
+		if err := rows.Scan(&anno.ID, &anno.Type, &anno.Text,
+			&anno.Start.ManifestId, &anno.Start.Line, &anno.Start.Rune,
+			&anno.End.ManifestId, &anno.End.Line, &anno.End.Rune,
+			&anno.Prefix, &anno.Suffix, &anno.Color, &anno.Author, &anno.Date, &anno.Comment); err != nil {
+			return nil, errors.Wrap(err, "failed to scan annotation")
+		}
+		annos = append(annos, anno)
+	}
+	return annos, rows.Err()
+}
+
+// UpdateAnchor stores the range and the context of the annotation
+func (db *DB) UpdateAnchor(anno Annotation) error {
+	_, err := db.db.Exec(`
+		UPDATE annotations SET start_manifest_id = ?, start_line = ?, start_rune = ?,
+			end_manifest_id = ?, end_line = ?, end_rune = ?, prefix = ?, suffix = ?
+		WHERE id = ? AND book_id = ?;
+	`, anno.Start.ManifestId, anno.Start.Line, anno.Start.Rune,
+		anno.End.ManifestId, anno.End.Line, anno.End.Rune, anno.Prefix, anno.Suffix, anno.ID, db.bookID)
+	if err != nil {
+		return errors.Wrap(err, "failed to update anchor")
+	}
+	return nil
+}
+
+// UpdateAnnotation stores the type, color and comment of the annotation
+func (db *DB) UpdateAnnotation(anno Annotation) error {
+	_, err := db.db.Exec(`
+		UPDATE annotations SET type = ?, color = ?, comment = ? WHERE id = ? AND book_id = ?;
+	`, anno.Type, anno.Color, anno.Comment, anno.ID, db.bookID)
+	if err != nil {
+		return errors.Wrap(err, "failed to update annotation")
+	}
+	return nil
+}
+
+// DeleteAnnotation removes the annotation with the id
+func (db *DB) DeleteAnnotation(id int64) error {
+	_, err := db.db.Exec(`DELETE FROM annotations WHERE id = ? AND book_id = ?;`, id, db.bookID)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete annotation")
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+// LibraryBook is a book of the library with the metadata of its epub file.
+// Cover is the path of the cover image in the epub, FileSize and FileTime
+// tell whether the file changed since it was scanned.
+type LibraryBook struct {
+	Book
+	Path      string
+	Creator   string
+	Language  string
+	Publisher string
+	Cover     string
+	FileSize  int64
+	FileTime  string
+
+	// Progress is the part of the book read, from 0 to 1
+	Progress float64
+	// ReadAt is when the reading position was saved last, empty if the book
+	// has never been read
+	ReadAt string
+}
+
+// AddBook adds the book to the library, the book already in the database is
+// updated with the metadata of its file
+func (db *DB) AddBook(book LibraryBook) error {
+	id, err := db.openBook(book.Book)
+	if err != nil {
+		return errors.Wrap(err, "failed to add book")
+	}
+	_, err = db.db.Exec(`
+		UPDATE books SET path = ?, creator = ?, language = ?, publisher = ?, cover = ?,
+		file_size = ?, file_time = ? WHERE id = ?;
+	`, book.Path, book.Creator, book.Language, book.Publisher, book.Cover, book.FileSize, book.FileTime, id)
+	return errors.Wrap(err, "failed to add book")
+}
+
+// libraryColumns are selected into scanLibraryBook
+const libraryColumns = `
+	COALESCE(b.identifier, ''), COALESCE(b.file_hash, ''), b.title, b.path, b.creator,
+	b.language, b.publisher, b.cover, b.file_size, b.file_time,
+	COALESCE(p.progress, 0), COALESCE(p.date, '')
+	FROM books AS b LEFT JOIN reading_positions AS p ON p.book_id = b.id`
+
+func scanLibraryBook(row interface{ Scan(...interface{}) error }) (LibraryBook, error) {
+	b := LibraryBook{}
+	err := row.Scan(&b.Identifier, &b.FileHash, &b.Title, &b.Path, &b.Creator,
+		&b.Language, &b.Publisher, &b.Cover, &b.FileSize, &b.FileTime,
+		&b.Progress, &b.ReadAt)
+	return b, err
+}
+
+// LibraryBook returns the book of the file, false if the file isn't in the
+// library
+func (db *DB) LibraryBook(path string) (LibraryBook, bool, error) {
+	book, err := scanLibraryBook(db.db.QueryRow(`SELECT `+libraryColumns+` WHERE b.path = ?;`, path))
+	if err == sql.ErrNoRows {
+		return book, false, nil
+	} else if err != nil {
+		return book, false, errors.Wrap(err, "failed to find book")
+	}
+	return book, true, nil
+}
+
+// Library returns the books with a file, the books only known by their
+// annotations are left out
+func (db *DB) Library() ([]LibraryBook, error) {
+	rows, err := db.db.Query(`SELECT ` + libraryColumns + ` WHERE b.path != '' ORDER BY b.id;`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list books")
+	}
+	defer rows.Close()
+	books := []LibraryBook{}
+	for rows.Next() {
+		book, err := scanLibraryBook(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to scan book")
+		}
+		books = append(books, book)
+	}
+	return books, rows.Err()
+}
+
+// SaveProgress records the part of the book read along with the reading
+// position, it's saved after the position
+func (db *DB) SaveProgress(progress float64) error {
+	_, err := db.db.Exec(`UPDATE reading_positions SET progress = ? WHERE book_id = ?;`, progress, db.bookID)
+	return errors.Wrap(err, "failed to save progress")
+}
@@ -0,0 +1,80 @@
+package db
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLibrary(t *testing.T) {
+	db, err := NewDb(filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	tao := LibraryBook{
+		Book:     Book{Identifier: "isbn", FileHash: "abc", Title: "Tao Te Ching"},
+		Path:     "/books/tao.epub",
+		Creator:  "Lao Tzu",
+		Language: "en",
+		Cover:    "OEBPS/cover.jpg",
+		FileSize: 42,
+		FileTime: "2022-01-01T00:00:00Z",
+	}
+	other := LibraryBook{Book: Book{FileHash: "def", Title: "Other"}, Path: "/books/other.epub"}
+	for _, book := range []LibraryBook{tao, other} {
+		if err := db.AddBook(book); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a book without file isn't in the library
+	if err := db.Run(Book{Title: "annotations only"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the book is read after it's added again from a new path
+	tao.Path = "/books/moved.epub"
+	if err := db.AddBook(tao); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Run(tao.Book); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SavePosition(Position{"c1", 1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveProgress(0.5); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SavePosition(Position{"c1", 3, 4}); err != nil {
+		t.Fatal(err)
+	}
+
+	books, err := db.Library()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(books) != 2 || books[0].ReadAt == "" {
+		t.Fatalf("got %v, expect tao read and other", books)
+	}
+	tao.Progress, tao.ReadAt = 0.5, books[0].ReadAt
+	if expect := []LibraryBook{tao, other}; !reflect.DeepEqual(books, expect) {
+		t.Errorf("got %v, expect %v", books, expect)
+	}
+
+	testcases := []struct {
+		name   string
+		path   string
+		found  bool
+		expect string
+	}{
+		{"moved", "/books/moved.epub", true, "Tao Te Ching"},
+		{"old path", "/books/tao.epub", false, ""},
+	}
+	for _, c := range testcases {
+		book, found, err := db.LibraryBook(c.path)
+		if err != nil || found != c.found || book.Title != c.expect {
+			t.Errorf("case %s: got %v, %v, %v, expect %v", c.name, book.Title, found, err, c.expect)
+		}
+	}
+}
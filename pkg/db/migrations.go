@@ -0,0 +1,278 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// migration upgrades the schema to its version, it runs in a transaction
+// with the update of schema_version
+type migration struct {
+	version int
+	name    string
+	apply   func(tx *sql.Tx) error
+}
+
+// migrations are applied in order, a released migration must never change,
+// add a new one instead
+var migrations = []migration{
+	{1, "create books, annotations and reading positions", createSchema},
+	{2, "import the legacy tables of each title", importLegacyTables},
+	{3, "add the library metadata of the books", addLibraryColumns},
+}
+
+// SchemaVersion is the version of the schema after all the migrations
+var SchemaVersion = migrations[len(migrations)-1].version
+
+// migrate applies the migrations newer than the version of the database
+func migrate(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL);`)
+	if err != nil {
+		return errors.Wrap(err, "failed to create schema_version")
+	}
+	version, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+		log.Infof("migrate database to version %d: %s", m.version, m.name)
+		tx, err := db.Begin()
+		if err != nil {
+			return errors.Wrap(err, "failed to begin migration")
+		}
+		if err := m.apply(tx); err != nil {
+			tx.Rollback()
+			return errors.Wrapf(err, "failed to migrate to version %d", m.version)
+		}
+		if err := setSchemaVersion(tx, m.version); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return errors.Wrapf(err, "failed to commit migration %d", m.version)
+		}
+	}
+	return nil
+}
+
+func schemaVersion(db *sql.DB) (int, error) {
+	var version sql.NullInt64
+	if err := db.QueryRow(`SELECT MAX(version) FROM schema_version;`).Scan(&version); err != nil {
+		return 0, errors.Wrap(err, "failed to read schema_version")
+	}
+	return int(version.Int64), nil
+}
+
+func setSchemaVersion(tx *sql.Tx, version int) error {
+	if _, err := tx.Exec(`DELETE FROM schema_version;`); err != nil {
+		return errors.Wrap(err, "failed to update schema_version")
+	}
+	_, err := tx.Exec(`INSERT INTO schema_version (version) VALUES (?);`, version)
+	return errors.Wrap(err, "failed to update schema_version")
+}
+
+func createSchema(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE books (
+			id INTEGER PRIMARY KEY,
+			identifier TEXT UNIQUE,
+			file_hash TEXT UNIQUE,
+			title TEXT NOT NULL DEFAULT ''
+		);
+		CREATE TABLE annotations (
+			id INTEGER PRIMARY KEY,
+			book_id INTEGER NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+			type TEXT NOT NULL DEFAULT '',
+			text TEXT NOT NULL DEFAULT '',
+			start_manifest_id TEXT NOT NULL DEFAULT '',
+			start_line INTEGER NOT NULL DEFAULT 0,
+			start_rune INTEGER NOT NULL DEFAULT 0,
+			end_manifest_id TEXT NOT NULL DEFAULT '',
+			end_line INTEGER NOT NULL DEFAULT 0,
+			end_rune INTEGER NOT NULL DEFAULT 0,
+			prefix TEXT NOT NULL DEFAULT '',
+			suffix TEXT NOT NULL DEFAULT '',
+			color TEXT NOT NULL DEFAULT '',
+			author TEXT NOT NULL DEFAULT '',
+			date TEXT NOT NULL DEFAULT '',
+			comment TEXT NOT NULL DEFAULT ''
+		);
+		CREATE INDEX annotations_book ON annotations (book_id);
+		CREATE TABLE reading_positions (
+			book_id INTEGER PRIMARY KEY REFERENCES books(id) ON DELETE CASCADE,
+			manifest_id TEXT NOT NULL DEFAULT '',
+			line INTEGER NOT NULL DEFAULT 0,
+			rune INTEGER NOT NULL DEFAULT 0,
+			date TEXT NOT NULL DEFAULT ''
+		);
+	`)
+	return err
+}
+
+// addLibraryColumns adds the metadata shown in the library, the file size and
+// time tell the scanned files which haven't changed
+func addLibraryColumns(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		ALTER TABLE books ADD COLUMN path TEXT NOT NULL DEFAULT '';
+		ALTER TABLE books ADD COLUMN creator TEXT NOT NULL DEFAULT '';
+		ALTER TABLE books ADD COLUMN language TEXT NOT NULL DEFAULT '';
+		ALTER TABLE books ADD COLUMN publisher TEXT NOT NULL DEFAULT '';
+		ALTER TABLE books ADD COLUMN cover TEXT NOT NULL DEFAULT '';
+		ALTER TABLE books ADD COLUMN file_size INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE books ADD COLUMN file_time TEXT NOT NULL DEFAULT '';
+		CREATE INDEX books_path ON books (path);
+		ALTER TABLE reading_positions ADD COLUMN progress REAL NOT NULL DEFAULT 0;
+	`)
+	return err
+}
+
+// legacyPositionsTable kept the reading positions keyed by the title before
+// the books table
+const legacyPositionsTable = "positions"
+
+// importLegacyTables moves the annotations of the tables named after the book
+// titles and the legacy positions into the new tables, the legacy tables are
+// dropped afterwards. The imported books have neither identifier nor hash,
+// they are claimed by the title when the book is opened.
+func importLegacyTables(tx *sql.Tx) error {
+	tables, err := legacyTables(tx)
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		columns, err := tableColumns(tx, table)
+		if err != nil {
+			return err
+		}
+		if !columns["type"] || !columns["text"] {
+			log.Warnf("skip unknown table %s", table)
+			continue
+		}
+		result, err := tx.Exec(`INSERT INTO books (title) VALUES (?);`, table)
+		if err != nil {
+			return errors.Wrapf(err, "failed to add book %s", table)
+		}
+		bookID, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		selected := []string{}
+		for _, column := range annotationColumns {
+			if columns[column.name] {
+				selected = append(selected, fmt.Sprintf("COALESCE(%s, %s)", column.name, column.zero))
+			} else {
+				selected = append(selected, column.zero)
+			}
+		}
+		names := []string{}
+		for _, column := range annotationColumns {
+			names = append(names, column.name)
+		}
+		_, err = tx.Exec(fmt.Sprintf(`
+			INSERT INTO annotations (book_id, %s) SELECT ?, %s FROM %s ORDER BY id;
+		`, strings.Join(names, ", "), strings.Join(selected, ", "), quoteIdentifier(table)), bookID)
+		if err != nil {
+			return errors.Wrapf(err, "failed to import annotations of %s", table)
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`DROP TABLE %s;`, quoteIdentifier(table))); err != nil {
+			return errors.Wrapf(err, "failed to drop %s", table)
+		}
+	}
+	return importLegacyPositions(tx)
+}
+
+// annotationColumns are copied from the legacy tables, the anchor columns
+// are missing in the tables made before the annotations were anchored
+var annotationColumns = []struct {
+	name, zero string
+}{
+	{"type", "''"},
+	{"text", "''"},
+	{"start_manifest_id", "''"},
+	{"start_line", "0"},
+	{"start_rune", "0"},
+	{"end_manifest_id", "''"},
+	{"end_line", "0"},
+	{"end_rune", "0"},
+	{"prefix", "''"},
+	{"suffix", "''"},
+	{"color", "''"},
+	{"author", "''"},
+	{"date", "''"},
+	{"comment", "''"},
+}
+
+func importLegacyPositions(tx *sql.Tx) error {
+	columns, err := tableColumns(tx, legacyPositionsTable)
+	if err != nil {
+		return err
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+	_, err = tx.Exec(`
+		INSERT OR REPLACE INTO reading_positions (book_id, manifest_id, line, rune, date)
+		SELECT books.id, COALESCE(p.manifest_id, ''), COALESCE(p.line, 0), COALESCE(p.rune, 0), COALESCE(p.date, '')
+		FROM positions AS p JOIN books ON books.title = p.book;
+	`)
+	if err != nil {
+		return errors.Wrap(err, "failed to import positions")
+	}
+	_, err = tx.Exec(`DROP TABLE positions;`)
+	return errors.Wrap(err, "failed to drop positions")
+}
+
+// legacyTables returns the tables created for each book title
+func legacyTables(tx *sql.Tx) ([]string, error) {
+	rows, err := tx.Query(`
+		SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'
+		AND name NOT IN ('schema_version', 'books', 'annotations', 'reading_positions', ?);
+	`, legacyPositionsTable)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list tables")
+	}
+	defer rows.Close()
+	tables := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tables = append(tables, name)
+	}
+	return tables, rows.Err()
+}
+
+// tableColumns returns the columns of the table, empty if the table doesn't
+// exist
+func tableColumns(tx *sql.Tx, table string) (map[string]bool, error) {
+	rows, err := tx.Query(fmt.Sprintf(`PRAGMA table_info(%s);`, quoteIdentifier(table)))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get table info")
+	}
+	defer rows.Close()
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var cid, notnull, pk int
+		var name, ctype string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &ctype, &notnull, &dflt, &pk); err != nil {
+			return nil, errors.Wrap(err, "failed to scan table info")
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
+
+// quoteIdentifier quotes the table name, the legacy tables are named after
+// the book titles
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"testing"
+)
+
+func TestImportLegacyTables(t *testing.T) {
+	path := newLegacyDb(t, `
+		CREATE TABLE "Tao Te Ching" (id INTEGER PRIMARY KEY, type TEXT, text TEXT,
+			startx Integer, starty Integer, endx Integer, endy Integer,
+			color TEXT, author TEXT, date TEXT, comment TEXT,
+			start_manifest_id TEXT DEFAULT '', start_line Integer DEFAULT 0, start_rune Integer DEFAULT 0,
+			end_manifest_id TEXT DEFAULT '', end_line Integer DEFAULT 0, end_rune Integer DEFAULT 0,
+			prefix TEXT DEFAULT '', suffix TEXT DEFAULT '');
+		INSERT INTO "Tao Te Ching" (type, text, comment, start_manifest_id, start_line, start_rune,
+			end_manifest_id, end_line, end_rune)
+		VALUES ('Highlight', 'the real way', 'a note', 'c1', 0, 25, 'c1', 0, 37);
+		INSERT INTO "Tao Te Ching" (type, text) VALUES ('Underscore', 'the real name');
+		CREATE TABLE other (id INTEGER PRIMARY KEY, type TEXT, text TEXT);
+		INSERT INTO other (type, text) VALUES ('Highlight', 'other book');
+		CREATE TABLE positions (book TEXT PRIMARY KEY, manifest_id TEXT, line Integer, rune Integer, date TEXT);
+		INSERT INTO positions VALUES ('Tao Te Ching', 'c2', 3, 10, '2022-01-01T00:00:00Z');
+	`)
+	db, err := NewDb(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if version, err := schemaVersion(db.db); err != nil || version != SchemaVersion {
+		t.Errorf("got version %d, %v, expect %d", version, err, SchemaVersion)
+	}
+	tx, err := db.db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"Tao Te Ching", "other", "positions"} {
+		if columns, err := tableColumns(tx, table); err != nil || len(columns) != 0 {
+			t.Errorf("expect legacy table %s dropped, got %v, %v", table, columns, err)
+		}
+	}
+	tx.Rollback()
+
+	// the legacy book is claimed by its title
+	book := Book{Identifier: "urn:isbn:123", FileHash: "abc", Title: "Tao Te Ching"}
+	if err := db.Run(book); err != nil {
+		t.Fatal(err)
+	}
+	annos, err := db.Annotations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(annos) != 2 || annos[0].Comment != "a note" || annos[0].Start != (Position{"c1", 0, 25}) || annos[1].Anchored() {
+		t.Errorf("unexpected annotations %v", annos)
+	}
+	if pos, found, err := db.LoadPosition(); err != nil || !found || pos != (Position{"c2", 3, 10}) {
+		t.Errorf("got position %v, %v, %v", pos, found, err)
+	}
+
+	// the book is found by its identifier once claimed, even if it's renamed
+	// and the file changed
+	id := db.bookID
+	for _, b := range []Book{
+		{Identifier: "urn:isbn:123", FileHash: "def", Title: "Tao"},
+		{FileHash: "def", Title: "Another title"},
+	} {
+		if err := db.Run(b); err != nil {
+			t.Fatal(err)
+		}
+		if db.bookID != id {
+			t.Errorf("book %v: got id %d, expect %d", b, db.bookID, id)
+		}
+	}
+	if err := db.Run(Book{Identifier: "urn:isbn:456", Title: "Tao Te Ching"}); err != nil {
+		t.Fatal(err)
+	}
+	if db.bookID == id {
+		t.Error("expect another book for another identifier")
+	}
+
+	// migrations are not applied again
+	db.Close()
+	db, err = NewDb(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var books int
+	if err := db.db.QueryRow(`SELECT COUNT(*) FROM books;`).Scan(&books); err != nil || books != 3 {
+		t.Errorf("got %d books, %v, expect 3", books, err)
+	}
+}
@@ -0,0 +1,47 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Position is the reading position in a book, it doesn't depend on the width
+// of the terminal: Line is the index of the paragraph in the spine item
+// ManifestId and Rune is the index of the first visible rune in that paragraph.
+type Position struct {
+	ManifestId string `json:"manifest_id"`
+	Line       int    `json:"line"`
+	Rune       int    `json:"rune"`
+}
+
+// SavePosition replaces the last reading position of the book, the progress
+// saved with the last one is kept
+func (db *DB) SavePosition(pos Position) error {
+	_, err := db.db.Exec(`
+		INSERT INTO reading_positions (book_id, manifest_id, line, rune, date)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (book_id) DO UPDATE SET manifest_id = excluded.manifest_id,
+		line = excluded.line, rune = excluded.rune, date = excluded.date;
+	`, db.bookID, pos.ManifestId, pos.Line, pos.Rune, time.Now().Format(time.RFC3339))
+	if err != nil {
+		return errors.Wrap(err, "failed to save position")
+	}
+	return nil
+}
+
+// LoadPosition returns the last reading position of the book, false if the
+// book has never been read
+func (db *DB) LoadPosition() (Position, bool, error) {
+	pos := Position{}
+	err := db.db.QueryRow(`
+		SELECT manifest_id, line, rune FROM reading_positions WHERE book_id = ?;
+	`, db.bookID).Scan(&pos.ManifestId, &pos.Line, &pos.Rune)
+	if err == sql.ErrNoRows {
+		return pos, false, nil
+	} else if err != nil {
+		return pos, false, errors.Wrap(err, "failed to load position")
+	}
+	return pos, true, nil
+}
@@ -0,0 +1,30 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestPosition(t *testing.T) {
+	db, err := NewDb(filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Run(Book{Title: "book"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, found, err := db.LoadPosition(); err != nil || found {
+		t.Fatalf("expect no position, got found=%v, err=%v", found, err)
+	}
+	for _, pos := range []Position{{"chapter1", 3, 10}, {"chapter2", 0, 42}} {
+		if err := db.SavePosition(pos); err != nil {
+			t.Fatal(err)
+		}
+		got, found, err := db.LoadPosition()
+		if err != nil || !found || got != pos {
+			t.Errorf("got %v(found=%v, err=%v), expect %v", got, found, err, pos)
+		}
+	}
+}
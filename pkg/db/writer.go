@@ -0,0 +1,165 @@
+package db
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// queueSize is the number of annotations Commit can queue without waiting
+	// for the writes
+	queueSize = 64
+	// maxBatchSize is the max annotations written in one transaction
+	maxBatchSize = 32
+	// errorsSize is the number of failures kept until they are read
+	errorsSize = 16
+)
+
+// closeTimeout is how long Close waits for the queued annotations
+var closeTimeout = 5 * time.Second
+
+// ErrClosed is returned by Commit after the database is closed
+var ErrClosed = errors.New("database is closed")
+
+// ErrNotRunning is returned by Commit before a book is opened by Run
+var ErrNotRunning = errors.New("no book is opened")
+
+// pendingWrite is an annotation of the book opened when it was committed
+type pendingWrite struct {
+	bookID int64
+	anno   Annotation
+}
+
+// writer stores the committed annotations in the background, the annotations
+// queued together are written in one transaction
+type writer struct {
+	db    *DB
+	queue chan pendingWrite
+	errs  chan error
+	done  chan struct{}
+	// stopping is closed by stop to release the commits waiting on a full
+	// queue
+	stopping chan struct{}
+
+	// mu guards running and closed so no annotation is sent before the
+	// writer runs or after the queue is closed
+	mu       sync.RWMutex
+	running  bool
+	closed   bool
+	started  sync.Once
+	stopOnce sync.Once
+}
+
+func newWriter(db *DB) *writer {
+	return &writer{
+		db:       db,
+		queue:    make(chan pendingWrite, queueSize),
+		errs:     make(chan error, errorsSize),
+		done:     make(chan struct{}),
+		stopping: make(chan struct{}),
+	}
+}
+
+// start runs the writer once, the later calls do nothing
+func (w *writer) start() {
+	w.started.Do(func() {
+		w.mu.Lock()
+		w.running = true
+		w.mu.Unlock()
+		go w.run()
+	})
+}
+
+// commit queues the annotation, it waits while the queue is full until the
+// writer is stopped
+func (w *writer) commit(anno Annotation) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return ErrClosed
+	}
+	if !w.running {
+		return ErrNotRunning
+	}
+	select {
+	case w.queue <- pendingWrite{w.db.bookID, anno}:
+		return nil
+	case <-w.stopping:
+		return ErrClosed
+	}
+}
+
+// run writes the queued annotations until the queue is closed, Errors is
+// closed when it returns
+func (w *writer) run() {
+	defer close(w.done)
+	defer close(w.errs)
+	for write := range w.queue {
+		batch := []pendingWrite{write}
+	drain:
+		for len(batch) < maxBatchSize {
+			select {
+			case write, ok := <-w.queue:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, write)
+			default:
+				break drain
+			}
+		}
+		if err := w.write(batch); err != nil {
+			w.report(err)
+		}
+	}
+}
+
+func (w *writer) write(batch []pendingWrite) error {
+	tx, err := w.db.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	for _, write := range batch {
+		if err := insertAnnotation(tx, write.bookID, write.anno); err != nil {
+			tx.Rollback()
+			return errors.Wrapf(err, "failed to write %d annotations", len(batch))
+		}
+	}
+	return errors.Wrap(tx.Commit(), "failed to commit annotations")
+}
+
+// report sends the failure to Errors, it's only logged if nobody reads them
+func (w *writer) report(err error) {
+	log.Error(err)
+	select {
+	case w.errs <- err:
+	default:
+	}
+}
+
+// stop closes the queue and waits until the queued annotations are written
+// or the context is done
+func (w *writer) stop(ctx context.Context) error {
+	w.stopOnce.Do(func() { close(w.stopping) })
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.queue)
+	w.mu.Unlock()
+
+	// the queued annotations are flushed even if the writer wasn't started
+	w.started.Do(func() { go w.run() })
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "failed to flush annotations")
+	}
+}
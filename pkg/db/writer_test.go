@@ -0,0 +1,155 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentCommits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.sqlite")
+	db, err := NewDb(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Run(Book{Title: "book"}); err != nil {
+		t.Fatal(err)
+	}
+	const writers, commits = 8, 100
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < commits; j++ {
+				if err := db.Commit(NewAnnotation(AnnotationHighlight, fmt.Sprintf("%d-%d", i, j))); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Commit(NewAnnotation(AnnotationHighlight, "late")); err != ErrClosed {
+		t.Errorf("got %v, expect ErrClosed", err)
+	}
+
+	db, err = NewDb(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Run(Book{Title: "book"}); err != nil {
+		t.Fatal(err)
+	}
+	annos, err := db.Annotations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(annos) != writers*commits {
+		t.Errorf("got %d annotations, expect %d", len(annos), writers*commits)
+	}
+}
+
+func TestCommitError(t *testing.T) {
+	db, err := NewDb(filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Run(Book{Title: "book"}); err != nil {
+		t.Fatal(err)
+	}
+	// the foreign key fails without the book
+	db.bookID = 42
+	if err := db.Commit(NewAnnotation(AnnotationHighlight, "orphan")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-db.Errors():
+		if err == nil {
+			t.Error("expect an error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("expect the failure to be reported")
+	}
+}
+
+func TestCloseContext(t *testing.T) {
+	db, err := NewDb(filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Commit(NewAnnotation(AnnotationHighlight, "early")); err != ErrNotRunning {
+		t.Errorf("got %v, expect ErrNotRunning", err)
+	}
+	// the writer isn't started without Run, closing must not wait for it
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := db.CloseContext(ctx); err != nil {
+		t.Error(err)
+	}
+	if _, ok := <-db.Errors(); ok {
+		t.Error("expect Errors closed")
+	}
+}
+
+func TestCloseFullQueue(t *testing.T) {
+	db, err := NewDb(filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Run(Book{Title: "book"}); err != nil {
+		t.Fatal(err)
+	}
+	// a stalled writer: the only connection is taken so the first batch
+	// never begins
+	db.db.SetMaxOpenConns(1)
+	conn, err := db.db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// the writer takes a batch at most, the last commit waits on the full
+	// queue
+	blocked := make(chan error)
+	go func() {
+		for i := 0; i <= queueSize+maxBatchSize; i++ {
+			if err := db.Commit(NewAnnotation(AnnotationHighlight, "queued")); err != nil {
+				blocked <- err
+				return
+			}
+		}
+		blocked <- nil
+	}()
+	time.Sleep(100 * time.Millisecond)
+	closed := make(chan error)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		closed <- db.CloseContext(ctx)
+	}()
+	for _, c := range []struct {
+		name   string
+		result chan error
+		expect error
+	}{
+		{"blocked commit", blocked, ErrClosed},
+		{"close", closed, context.DeadlineExceeded},
+	} {
+		select {
+		case err := <-c.result:
+			if !errors.Is(err, c.expect) {
+				t.Errorf("case %s: got %v, expect %v", c.name, err, c.expect)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("case %s: deadlocked", c.name)
+		}
+	}
+}
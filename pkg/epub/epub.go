@@ -42,12 +42,17 @@ type Rootfile struct {
 		Publisher   string `xml:"publisher"`
 		Language    string `xml:"language"`
 		Format      string `xml:"format"`
+		Metas       []struct {
+			Name    string `xml:"name,attr"`
+			Content string `xml:"content,attr"`
+		} `xml:"meta"`
 	} `xml:"metadata"`
 	Manifest struct {
 		Items []struct {
-			ID        ManifestId `xml:"id,attr"`
-			Href      HRef       `xml:"href,attr"`
-			MediaType string     `xml:"media-type,attr"`
+			ID         ManifestId `xml:"id,attr"`
+			Href       HRef       `xml:"href,attr"`
+			MediaType  string     `xml:"media-type,attr"`
+			Properties string     `xml:"properties,attr"`
 		} `xml:"item"`
 	} `xml:"manifest"`
 	Spine struct {
@@ -61,7 +66,7 @@ type Rootfile struct {
 		Items []struct {
 			Type  string `xml:"type,attr"`
 			Href  string `xml:"href,attr"`
-			Title string `xml:"title"`
+			Title string `xml:"title,attr"`
 		} `xml:"reference"`
 	} `xml:"guide"`
 }
@@ -75,6 +80,7 @@ type navPoint struct {
 	Content struct {
 		Src HRef `xml:"src,attr"`
 	} `xml:"content"`
+	NavPoints []navPoint `xml:"navPoint"`
 }
 
 type Toc struct {
@@ -88,19 +94,14 @@ type Toc struct {
 		Text string `xml:",chardata"`
 	} `xml:"docTitle"`
 	NavMap struct {
-		//TODO(elinx): better way to express nested navPoint
-		NavPoints []struct {
-			ID        string `xml:"id,attr"`
-			PlayOrder string `xml:"playOrder,attr"`
-			NavLable  struct {
-				Text string `xml:",chardata"`
-			} `xml:"navLabel>text"`
-			Content struct {
-				Src HRef `xml:"src,attr"`
-			} `xml:"content"`
-			NavPoints []navPoint `xml:"navPoint"`
-		} `xml:"navPoint"`
+		NavPoints []navPoint `xml:"navPoint"`
 	} `xml:"navMap"`
+	PageList struct {
+		PageTargets []navPoint `xml:"pageTarget"`
+	} `xml:"pageList"`
+
+	// Landmarks come from the nav document of EPUB 3 or the guide of EPUB 2
+	Landmarks []Landmark `xml:"-"`
 }
 
 type Epub struct {
@@ -165,14 +166,44 @@ func (epub *Epub) Open() error {
 	return nil
 }
 
+// Close closes the epub file, it does nothing if the file failed to open
 func (epub *Epub) Close() {
-	epub.readercloser.Close()
+	if epub.readercloser != nil {
+		epub.readercloser.Close()
+	}
 }
 
 func (epub *Epub) Title() string {
 	return epub.Rootfile.Metadata.Title
 }
 
+// Identifier returns the unique identifier of the book, it's usually the ISBN
+func (epub *Epub) Identifier() string {
+	return strings.TrimSpace(epub.Rootfile.Metadata.ISBN)
+}
+
+// Cover returns the full path of the cover image, it's the manifest item with
+// the cover-image property in EPUB 3 and the item of the cover meta in EPUB 2.
+// Empty is returned if the book has no cover.
+func (epub *Epub) Cover() string {
+	for _, item := range epub.Rootfile.Manifest.Items {
+		for _, property := range strings.Fields(item.Properties) {
+			if property == "cover-image" {
+				return epub.GetFullPath(item.Href)
+			}
+		}
+	}
+	for _, meta := range epub.Rootfile.Metadata.Metas {
+		if meta.Name != "cover" {
+			continue
+		}
+		if href := epub.ManifestIdToHref(ManifestId(meta.Content)); href != "" {
+			return epub.GetFullPath(href)
+		}
+	}
+	return ""
+}
+
 // getContentByFilePath return file content by full filepath(relative to rootfile)
 func (epub *Epub) getContentByFilePath(filepath string) (string, error) {
 	if f, found := epub.Files[filepath]; !found {
@@ -199,10 +230,21 @@ func (epub *Epub) GetContentByHref(href HRef) (string, error) {
 // GetContentByManifestId return file content by manifest id
 func (epub *Epub) GetContentByManifestId(id ManifestId) (string, error) {
 	filepath := epub.getManifestFilePathById(id)
-	log.Infof("filepath: %s", filepath)
+	log.Debugf("filepath: %s", filepath)
 	return epub.getContentByFilePath(filepath)
 }
 
+// GetContentSizeByManifestId returns the size of the file of the manifest id
+// without reading it
+func (epub *Epub) GetContentSizeByManifestId(id ManifestId) (int64, error) {
+	filepath := epub.getManifestFilePathById(id)
+	f, found := epub.Files[filepath]
+	if !found {
+		return 0, fmt.Errorf("%s not found", filepath)
+	}
+	return int64(f.UncompressedSize64), nil
+}
+
 // GetFullPath return full filepath(relative to rootfile) by href(setted in spine)
 func (epub *Epub) GetFullPath(href HRef) string {
 	namespace := epub.Container.Rootfiles[0].FullPath[:len(epub.Container.Rootfiles[0].FullPath)-len(path.Base(epub.Container.Rootfiles[0].FullPath))]
@@ -210,7 +252,7 @@ func (epub *Epub) GetFullPath(href HRef) string {
 }
 
 func (epub *Epub) getManifestFilePathById(id ManifestId) string {
-	log.Printf("id: %s\n", id)
+	log.Debugf("id: %s", id)
 	for i, v := range epub.Rootfile.Manifest.Items {
 		if v.ID == id {
 			return epub.GetFullPath(epub.Rootfile.Manifest.Items[i].Href)
@@ -219,7 +261,10 @@ func (epub *Epub) getManifestFilePathById(id ManifestId) string {
 	return ""
 }
 
+// HrefToManifestId return the manifest id of the file of href, the fragment
+// of href is ignored
 func (epub *Epub) HrefToManifestId(href HRef) ManifestId {
+	href, _ = SplitHref(href)
 	for _, v := range epub.Rootfile.Manifest.Items {
 		if v.Href == href {
 			return v.ID
@@ -312,7 +357,21 @@ func (epub *Epub) GetPrevSection(id ManifestId) (string, ManifestId, error) {
 // 	return epub.GetChapterByIndex(index - 1)
 // }
 
+// parseTableOfContent prefers the EPUB 3 navigation document and falls back
+// to the NCX file referenced by the spine when there is no nav document
 func (epub *Epub) parseTableOfContent() error {
+	if href, found := epub.getNavDocumentHref(); found {
+		content, err := epub.GetContentByHref(href)
+		if err != nil {
+			return err
+		}
+		doc, err := parseNavDocument(content, href)
+		if err != nil {
+			return err
+		}
+		epub.Toc = doc.toToc()
+		return nil
+	}
 	filepath := epub.getManifestFilePathById(epub.Rootfile.Spine.TocID)
 	if f, found := epub.Files[filepath]; !found {
 		return fmt.Errorf("%s not found", filepath)
@@ -325,40 +384,14 @@ func (epub *Epub) parseTableOfContent() error {
 			}
 		}
 	}
-	return nil
-}
-
-type TableOfContent struct {
-	Orders  []string // Titles in order
-	Content map[string]struct {
-		Level int
-		ID    ManifestId
-	}
-}
-
-func (epub *Epub) GetTableOfContent() *TableOfContent {
-	content := &TableOfContent{
-		Orders: make([]string, 0),
-		Content: make(map[string]struct {
-			Level int
-			ID    ManifestId
-		}),
-	}
-	for _, v := range epub.Toc.NavMap.NavPoints {
-		content.Orders = append(content.Orders, v.NavLable.Text)
-		content.Content[v.NavLable.Text] = struct {
-			Level int
-			ID    ManifestId
-		}{0, epub.HrefToManifestId(v.Content.Src)}
-		for _, v := range v.NavPoints {
-			content.Orders = append(content.Orders, v.NavLable.Text)
-			content.Content[v.NavLable.Text] = struct {
-				Level int
-				ID    ManifestId
-			}{1, epub.HrefToManifestId(v.Content.Src)}
-		}
+	for _, v := range epub.Rootfile.Guide.Items {
+		epub.Toc.Landmarks = append(epub.Toc.Landmarks, Landmark{
+			Type:  v.Type,
+			Title: v.Title,
+			Href:  HRef(v.Href),
+		})
 	}
-	return content
+	return nil
 }
 
 func (epub *Epub) getCssFiles() []string {
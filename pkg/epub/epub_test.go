@@ -0,0 +1,39 @@
+package epub
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+const testContainer = `<container><rootfiles>
+	<rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+</rootfiles></container>`
+
+func TestCover(t *testing.T) {
+	testcases := []struct {
+		name   string
+		opf    string
+		expect string
+	}{
+		{"epub3", `<package><manifest>
+			<item id="c" href="images/cover.jpg" properties="svg cover-image"/>
+		</manifest></package>`, "OEBPS/images/cover.jpg"},
+		{"epub2", `<package><metadata><meta name="cover" content="img"/></metadata><manifest>
+			<item id="img" href="cover.png"/>
+		</manifest></package>`, "OEBPS/cover.png"},
+		{"unknown item", `<package><metadata><meta name="cover" content="img"/></metadata></package>`, ""},
+		{"none", `<package><manifest><item id="c" href="c.html"/></manifest></package>`, ""},
+	}
+	for _, c := range testcases {
+		book := NewEpub("book.epub")
+		if err := xml.Unmarshal([]byte(testContainer), &book.Container); err != nil {
+			t.Fatal(err)
+		}
+		if err := xml.Unmarshal([]byte(c.opf), &book.Rootfile); err != nil {
+			t.Fatal(err)
+		}
+		if got := book.Cover(); got != c.expect {
+			t.Errorf("case %s: got %v, expect %v", c.name, got, c.expect)
+		}
+	}
+}
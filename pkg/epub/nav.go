@@ -0,0 +1,203 @@
+package epub
+
+import (
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	navProperty = "nav" // manifest property of the EPUB 3 navigation document
+
+	navTypeToc       = "toc"
+	navTypeLandmarks = "landmarks"
+	navTypePageList  = "page-list"
+)
+
+// Landmark is a structural reference into the book, e.g. cover, bodymatter
+type Landmark struct {
+	Type  string
+	Title string
+	Href  HRef
+}
+
+// navItem is one `li` of a nav document, children are the items of the nested `ol`
+type navItem struct {
+	ID       string
+	Type     string
+	Label    string
+	Href     HRef
+	Children []navItem
+}
+
+// navDocument is the decoded EPUB 3 navigation document
+type navDocument struct {
+	Title     string
+	Toc       []navItem
+	Landmarks []navItem
+	PageList  []navItem
+}
+
+// getNavDocumentHref return the href of the manifest item with the `nav` property
+func (epub *Epub) getNavDocumentHref() (HRef, bool) {
+	for _, item := range epub.Rootfile.Manifest.Items {
+		for _, property := range strings.Fields(item.Properties) {
+			if property == navProperty {
+				return item.Href, true
+			}
+		}
+	}
+	return "", false
+}
+
+// parseNavDocument parses the XHTML navigation document, hrefs in the result
+// are relative to the rootfile like the hrefs in the manifest.
+func parseNavDocument(content string, base HRef) (*navDocument, error) {
+	node, err := html.Parse(strings.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	doc := &navDocument{}
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "title":
+				doc.Title = nodeText(n)
+			case "nav":
+				items := parseNavList(findChild(n, "ol"), base)
+				switch navType(n) {
+				case navTypeToc:
+					doc.Toc = items
+				case navTypeLandmarks:
+					doc.Landmarks = items
+				case navTypePageList:
+					doc.PageList = items
+				}
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(node)
+	return doc, nil
+}
+
+func parseNavList(ol *html.Node, base HRef) []navItem {
+	if ol == nil {
+		return nil
+	}
+	items := []navItem{}
+	for li := ol.FirstChild; li != nil; li = li.NextSibling {
+		if li.Type != html.ElementNode || li.Data != "li" {
+			continue
+		}
+		item := navItem{ID: attribute(li, "id")}
+		for c := li.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			switch c.Data {
+			case "a":
+				item.Type = attribute(c, "epub:type")
+				item.Label = nodeText(c)
+				item.Href = ResolveHref(base, attribute(c, "href"))
+			case "span":
+				item.Label = nodeText(c)
+			case "ol":
+				item.Children = parseNavList(c, base)
+			}
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// navType return the first epub:type of the nav element
+func navType(n *html.Node) string {
+	for _, t := range strings.Fields(attribute(n, "epub:type")) {
+		switch t {
+		case navTypeToc, navTypeLandmarks, navTypePageList:
+			return t
+		}
+	}
+	return ""
+}
+
+func findChild(n *html.Node, tag string) *html.Node {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.Data == tag {
+			return c
+		}
+		if found := findChild(c, tag); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func attribute(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// nodeText return the text content of the node with whitespaces collapsed
+func nodeText(n *html.Node) string {
+	var builder strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			builder.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(builder.String()), " ")
+}
+
+// toToc converts the nav document into the same model as the NCX file
+func (doc *navDocument) toToc() Toc {
+	toc := Toc{}
+	toc.DocTitle.Text = doc.Title
+	order := 0
+	var newNavPoint func(item navItem) navPoint
+	newNavPoint = func(item navItem) navPoint {
+		order++
+		point := navPoint{ID: item.ID, PlayOrder: strconv.Itoa(order)}
+		point.NavLable.Text = item.Label
+		point.Content.Src = item.Href
+		for _, child := range item.Children {
+			point.NavPoints = append(point.NavPoints, newNavPoint(child))
+		}
+		return point
+	}
+	for _, item := range doc.Toc {
+		toc.NavMap.NavPoints = append(toc.NavMap.NavPoints, newNavPoint(item))
+	}
+	order = 0
+	for _, item := range flattenNavItems(doc.PageList) {
+		toc.PageList.PageTargets = append(toc.PageList.PageTargets, newNavPoint(item))
+	}
+	for _, item := range flattenNavItems(doc.Landmarks) {
+		toc.Landmarks = append(toc.Landmarks, Landmark{Type: item.Type, Title: item.Label, Href: item.Href})
+	}
+	return toc
+}
+
+func flattenNavItems(items []navItem) []navItem {
+	flatten := []navItem{}
+	for _, item := range items {
+		flatten = append(flatten, item)
+		flatten = append(flatten, flattenNavItems(item.Children)...)
+	}
+	return flatten
+}
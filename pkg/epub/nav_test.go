@@ -0,0 +1,109 @@
+package epub
+
+import (
+	"reflect"
+	"testing"
+)
+
+const navDocumentContent = `<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>The Book</title></head>
+<body>
+<nav epub:type="toc" id="toc">
+	<h1>Contents</h1>
+	<ol>
+		<li id="c1"><a href="text/chapter1.xhtml">Chapter
+			1</a>
+			<ol>
+				<li><a href="text/chapter1.xhtml#sec1">Section 1</a>
+					<ol><li><a href="text/chapter1.xhtml#sec1-1">Section 1.1</a></li></ol>
+				</li>
+			</ol>
+		</li>
+		<li><span>Part Two</span>
+			<ol><li><a href="../chapter2.xhtml">Chapter 2</a></li></ol>
+		</li>
+	</ol>
+</nav>
+<nav epub:type="landmarks" hidden="">
+	<ol>
+		<li><a epub:type="bodymatter" href="text/chapter1.xhtml">Start</a></li>
+	</ol>
+</nav>
+<nav epub:type="page-list" hidden="">
+	<ol>
+		<li><a href="text/chapter1.xhtml#page1">1</a></li>
+		<li><a href="#page2">2</a></li>
+	</ol>
+</nav>
+</body>
+</html>`
+
+func TestParseNavDocument(t *testing.T) {
+	doc, err := parseNavDocument(navDocumentContent, "nav/nav.xhtml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := &navDocument{
+		Title: "The Book",
+		Toc: []navItem{
+			{
+				ID:    "c1",
+				Label: "Chapter 1",
+				Href:  "nav/text/chapter1.xhtml",
+				Children: []navItem{
+					{
+						Label: "Section 1",
+						Href:  "nav/text/chapter1.xhtml#sec1",
+						Children: []navItem{
+							{Label: "Section 1.1", Href: "nav/text/chapter1.xhtml#sec1-1"},
+						},
+					},
+				},
+			},
+			{
+				Label: "Part Two",
+				Children: []navItem{
+					{Label: "Chapter 2", Href: "chapter2.xhtml"},
+				},
+			},
+		},
+		Landmarks: []navItem{
+			{Type: "bodymatter", Label: "Start", Href: "nav/text/chapter1.xhtml"},
+		},
+		PageList: []navItem{
+			{Label: "1", Href: "nav/text/chapter1.xhtml#page1"},
+			{Label: "2", Href: "nav/nav.xhtml#page2"},
+		},
+	}
+	if !reflect.DeepEqual(expect, doc) {
+		t.Errorf("got: %+v, expect: %+v", doc, expect)
+	}
+}
+
+func TestNavDocumentToToc(t *testing.T) {
+	doc, err := parseNavDocument(navDocumentContent, "nav.xhtml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	toc := doc.toToc()
+	titles := []string{}
+	var walk func(points []navPoint, prefix string)
+	walk = func(points []navPoint, prefix string) {
+		for _, v := range points {
+			titles = append(titles, prefix+v.NavLable.Text)
+			walk(v.NavPoints, prefix+"-")
+		}
+	}
+	walk(toc.NavMap.NavPoints, "")
+	expect := []string{"Chapter 1", "-Section 1", "--Section 1.1", "Part Two", "-Chapter 2"}
+	if !reflect.DeepEqual(expect, titles) {
+		t.Errorf("got: %v, expect: %v", titles, expect)
+	}
+	if len(toc.PageList.PageTargets) != 2 {
+		t.Errorf("got %d page targets, expect 2", len(toc.PageList.PageTargets))
+	}
+	if len(toc.Landmarks) != 1 || toc.Landmarks[0].Type != "bodymatter" {
+		t.Errorf("unexpected landmarks: %v", toc.Landmarks)
+	}
+}
@@ -0,0 +1,118 @@
+package epub
+
+import (
+	"path"
+	"strconv"
+	"strings"
+)
+
+// TocNode is one entry of the table of content, entries nested in it are
+// kept as children so the tree can be arbitrarily deep.
+type TocNode struct {
+	// ID identifies the node by its position in the tree, e.g. "2.0.1", it is
+	// stable across opens of the same book and unique even if titles repeat
+	ID        string
+	Title     string
+	Level     int
+	PlayOrder int
+
+	// Href is the file of the node without fragment, ManifestId is the
+	// manifest item of that file and Fragment is the anchor inside it
+	Href       HRef
+	ManifestId ManifestId
+	Fragment   string
+
+	Parent   *TocNode
+	Children []*TocNode
+}
+
+// HasChildren return true if there are nodes nested in this node
+func (n *TocNode) HasChildren() bool {
+	return len(n.Children) > 0
+}
+
+type TableOfContent struct {
+	Title string
+	Nodes []*TocNode // the top level nodes
+}
+
+// Walk visits the nodes in reading order(depth first), children of a node are
+// skipped if fn returns false for it.
+func (toc *TableOfContent) Walk(fn func(*TocNode) bool) {
+	var walk func([]*TocNode)
+	walk = func(nodes []*TocNode) {
+		for _, node := range nodes {
+			if fn(node) {
+				walk(node.Children)
+			}
+		}
+	}
+	walk(toc.Nodes)
+}
+
+// Find return the node with the given ID, nil if not found
+func (toc *TableOfContent) Find(id string) *TocNode {
+	var found *TocNode
+	toc.Walk(func(node *TocNode) bool {
+		if node.ID == id {
+			found = node
+		}
+		return found == nil && strings.HasPrefix(id, node.ID+".")
+	})
+	return found
+}
+
+// GetTableOfContent builds the table of content tree of the book
+func (epub *Epub) GetTableOfContent() *TableOfContent {
+	toc := &TableOfContent{
+		Title: epub.Toc.DocTitle.Text,
+		Nodes: []*TocNode{},
+	}
+	var build func(points []navPoint, parent *TocNode, prefix string, level int) []*TocNode
+	build = func(points []navPoint, parent *TocNode, prefix string, level int) []*TocNode {
+		nodes := []*TocNode{}
+		for i, v := range points {
+			href, fragment := SplitHref(v.Content.Src)
+			playOrder, _ := strconv.Atoi(v.PlayOrder)
+			node := &TocNode{
+				ID:         prefix + strconv.Itoa(i),
+				Title:      strings.TrimSpace(v.NavLable.Text),
+				Level:      level,
+				PlayOrder:  playOrder,
+				Href:       href,
+				ManifestId: epub.HrefToManifestId(href),
+				Fragment:   fragment,
+				Parent:     parent,
+			}
+			node.Children = build(v.NavPoints, node, node.ID+".", level+1)
+			nodes = append(nodes, node)
+		}
+		return nodes
+	}
+	toc.Nodes = build(epub.Toc.NavMap.NavPoints, nil, "", 0)
+	return toc
+}
+
+// SplitHref splits the href into the file part and the fragment(without `#`)
+func SplitHref(href HRef) (HRef, string) {
+	if i := strings.Index(string(href), "#"); i >= 0 {
+		return href[:i], string(href[i+1:])
+	}
+	return href, ""
+}
+
+// ResolveHref converts the href relative to the document `base` into an href
+// relative to the rootfile, the fragment is kept untouched.
+func ResolveHref(base HRef, href string) HRef {
+	if href == "" {
+		return ""
+	}
+	file, fragment := SplitHref(HRef(href))
+	if fragment != "" {
+		fragment = "#" + fragment
+	}
+	if file == "" {
+		return base + HRef(fragment)
+	}
+	return HRef(path.Join(path.Dir(string(base)), string(file)) + fragment)
+}
@@ -0,0 +1,51 @@
+package epub
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetTableOfContent(t *testing.T) {
+	book := &Epub{}
+	doc, err := parseNavDocument(navDocumentContent, "nav.xhtml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	book.Toc = doc.toToc()
+	toc := book.GetTableOfContent()
+
+	type record struct {
+		ID       string
+		Level    int
+		Href     HRef
+		Fragment string
+	}
+	records := []record{}
+	toc.Walk(func(node *TocNode) bool {
+		records = append(records, record{node.ID, node.Level, node.Href, node.Fragment})
+		return true
+	})
+	expect := []record{
+		{"0", 0, "text/chapter1.xhtml", ""},
+		{"0.0", 1, "text/chapter1.xhtml", "sec1"},
+		{"0.0.0", 2, "text/chapter1.xhtml", "sec1-1"},
+		{"1", 0, "", ""},
+		{"1.0", 1, "../chapter2.xhtml", ""},
+	}
+	if !reflect.DeepEqual(expect, records) {
+		t.Errorf("got: %v, expect: %v", records, expect)
+	}
+
+	if node := toc.Find("0.0.0"); node == nil || node.Title != "Section 1.1" || node.Parent.ID != "0.0" {
+		t.Errorf("unexpected node: %v", node)
+	}
+
+	skipped := 0
+	toc.Walk(func(node *TocNode) bool {
+		skipped++
+		return false
+	})
+	if skipped != 2 {
+		t.Errorf("walk without children visited %d nodes, expect 2", skipped)
+	}
+}
@@ -166,7 +166,7 @@ func parse(node *html.Node, formater RuneFormater) Content {
 }
 
 func Parse(content string, formater RuneFormater) (Content, error) {
-	log.Infoln("Enter into parsing of HTML")
+	log.Debugln("Enter into parsing of HTML")
 	htmlNode, err := html.Parse(strings.NewReader(content))
 	if err != nil {
 		return nil, err
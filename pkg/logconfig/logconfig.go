@@ -2,16 +2,25 @@ package logconfig
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path"
 	"strings"
 
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
-const (
-	logFilename = "log.txt"
-)
+// maxFileSize is the size of the log file to start a new one, the old one is
+// kept with the ".1" suffix
+const maxFileSize = 10 << 20
+
+// Options of the logger, the log is dropped if File is empty and written to
+// stderr if File is "-"
+type Options struct {
+	Level string
+	File  string
+}
 
 type simpleFormatter struct {
 	log.TextFormatter
@@ -37,17 +46,61 @@ func (f *simpleFormatter) Format(entry *log.Entry) ([]byte, error) {
 		entry.Message)), nil
 }
 
-func init() {
-	logFile, err := os.OpenFile(logFilename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
-	if err != nil {
-		log.Fatalf("error opening file: %v", err)
+// Init sets up the standard logger, the returned closer closes the log file.
+// The log file is appended so the log of the last runs is kept, it's moved
+// aside once it's over maxFileSize. Only the warnings and the errors are
+// logged by default.
+func Init(opts Options) (io.Closer, error) {
+	level := log.WarnLevel
+	if opts.Level != "" {
+		var err error
+		if level, err = log.ParseLevel(opts.Level); err != nil {
+			return nil, errors.Wrap(err, "invalid log level")
+		}
 	}
-	log.SetOutput(logFile)
-	log.SetLevel(log.DebugLevel)
+	var output io.WriteCloser = nopCloser{io.Discard}
+	switch opts.File {
+	case "":
+	case "-":
+		output = nopCloser{os.Stderr}
+	default:
+		if err := rotate(opts.File); err != nil {
+			return nil, err
+		}
+		file, err := os.OpenFile(opts.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open log file")
+		}
+		output = file
+	}
+	log.SetOutput(output)
+	log.SetLevel(level)
 	log.SetReportCaller(true)
 	log.SetFormatter(&simpleFormatter{
 		log.TextFormatter{
 			TimestampFormat: "2006-01-02 15:04:05",
 		},
 	})
+	return output, nil
+}
+
+// rotate moves the log file to the ".1" file if it's over maxFileSize, the
+// older one is replaced
+func rotate(filename string) error {
+	info, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "failed to stat log file")
+	}
+	if info.Size() < maxFileSize {
+		return nil
+	}
+	return errors.Wrap(os.Rename(filename, filename+".1"), "failed to rotate log file")
 }
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
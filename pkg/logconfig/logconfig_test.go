@@ -0,0 +1,34 @@
+package logconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotate(t *testing.T) {
+	dir := t.TempDir()
+	testcases := []struct {
+		name    string
+		size    int
+		rotated bool
+	}{
+		{"small", 10, false},
+		{"full", maxFileSize, true},
+	}
+	for _, c := range testcases {
+		filename := filepath.Join(dir, c.name+".log")
+		if err := os.WriteFile(filename, make([]byte, c.size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := rotate(filename); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filename + ".1"); (err == nil) != c.rotated {
+			t.Errorf("case %s: got rotated %v, expect %v", c.name, err == nil, c.rotated)
+		}
+	}
+	if err := rotate(filepath.Join(dir, "missing.log")); err != nil {
+		t.Errorf("case missing: got %v, expect nil", err)
+	}
+}
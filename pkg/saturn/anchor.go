@@ -0,0 +1,165 @@
+package saturn
+
+import (
+	"strings"
+
+	"github.com/elinx/saturn/pkg/db"
+	"github.com/elinx/saturn/pkg/epub"
+	log "github.com/sirupsen/logrus"
+)
+
+// quoteContextLen is the max runes of the text kept around an annotation quote
+const quoteContextLen = 32
+
+// PositionOf converts the buffer position into a position relative to the
+// spine item which doesn't depend on the terminal width
+func PositionOf(buffer *Buffer, linum BufferLineIndex, x RuneIndex) db.Position {
+	id, start := buffer.GetBlockByLine(linum)
+	return db.Position{
+		ManifestId: string(id),
+		Line:       int(linum - start),
+		Rune:       int(x),
+	}
+}
+
+// BufferPosOf converts the position back to the buffer, false if the spine
+// item is not in the buffer. The start of the item is returned if it isn't
+// parsed yet.
+func BufferPosOf(buffer *Buffer, pos db.Position) (BufferLineIndex, RuneIndex, bool) {
+	id := epub.ManifestId(pos.ManifestId)
+	start, ok := buffer.BlockPos[id]
+	if !ok || buffer.LinesNum() == 0 {
+		return 0, 0, false
+	}
+	if buffer.pending[id] {
+		return start, 0, true
+	}
+	linum := int(start) + pos.Line
+	if linum >= buffer.LinesNum() {
+		linum = buffer.LinesNum() - 1
+	}
+	return BufferLineIndex(linum), RuneIndex(pos.Rune), true
+}
+
+// Text returns the text from (startLinum, startX) to (endLinum, endX), the
+// end is exclusive and paragraphs are joined by newlines
+func (b *Buffer) Text(startLinum BufferLineIndex, startX RuneIndex, endLinum BufferLineIndex, endX RuneIndex) string {
+	parts := []string{}
+	for linum := startLinum; linum <= endLinum && int(linum) < len(b.Lines); linum++ {
+		runes := []rune(b.Lines[linum].Content)
+		start, end := 0, len(runes)
+		if linum == startLinum {
+			start = clampRune(int(startX), len(runes))
+		}
+		if linum == endLinum {
+			end = clampRune(int(endX), len(runes))
+		}
+		if start > end {
+			start = end
+		}
+		parts = append(parts, string(runes[start:end]))
+	}
+	return strings.Join(parts, "\n")
+}
+
+func clampRune(x, length int) int {
+	if x < 0 {
+		return 0
+	}
+	if x > length {
+		return length
+	}
+	return x
+}
+
+// NewAnchoredAnnotation creates an annotation of the text in the buffer range,
+// the quote and its context are taken from the buffer
+func NewAnchoredAnnotation(t db.AnnotationType, buffer *Buffer,
+	startLinum BufferLineIndex, startX RuneIndex, endLinum BufferLineIndex, endX RuneIndex,
+	opts ...db.AnnotationOption) db.Annotation {
+	prefix, suffix := quoteContext(buffer, startLinum, startX, endLinum, endX)
+	opts = append([]db.AnnotationOption{
+		db.WithRange(PositionOf(buffer, startLinum, startX), PositionOf(buffer, endLinum, endX)),
+		db.WithContext(prefix, suffix),
+	}, opts...)
+	return db.NewAnnotation(t, buffer.Text(startLinum, startX, endLinum, endX), opts...)
+}
+
+func quoteContext(buffer *Buffer, startLinum BufferLineIndex, startX RuneIndex,
+	endLinum BufferLineIndex, endX RuneIndex) (string, string) {
+	before := []rune(buffer.Lines[startLinum].Content)
+	start := clampRune(int(startX), len(before))
+	prefix := string(before[clampRune(start-quoteContextLen, start):start])
+	after := []rune(buffer.Lines[endLinum].Content)
+	end := clampRune(int(endX), len(after))
+	suffix := string(after[end:clampRune(end+quoteContextLen, len(after))])
+	return prefix, suffix
+}
+
+// FindQuote looks for the quote of a single paragraph in the buffer, the
+// occurrence with the most similar context wins if the quote isn't unique
+func FindQuote(buffer *Buffer, quote, prefix, suffix string) (Match, bool) {
+	quote = strings.TrimSpace(quote)
+	if quote == "" || strings.Contains(quote, "\n") {
+		return Match{}, false
+	}
+	matches, err := buffer.Search(quote, SearchOptions{})
+	if err != nil || len(matches) == 0 {
+		return Match{}, false
+	}
+	best, bestScore := matches[0], -1
+	for _, match := range matches {
+		runes := []rune(buffer.Lines[match.BufferLinum].Content)
+		score := commonSuffixLen(string(runes[:match.Start]), prefix) +
+			commonPrefixLen(string(runes[match.End:]), suffix)
+		if score > bestScore {
+			best, bestScore = match, score
+		}
+	}
+	return best, true
+}
+
+func commonPrefixLen(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	n := 0
+	for n < len(ra) && n < len(rb) && ra[n] == rb[n] {
+		n++
+	}
+	return n
+}
+
+func commonSuffixLen(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	n := 0
+	for n < len(ra) && n < len(rb) && ra[len(ra)-1-n] == rb[len(rb)-1-n] {
+		n++
+	}
+	return n
+}
+
+// MigrateAnnotations anchors the annotations made with screen coordinates by
+// looking for their text in the spine items parsed. The ones not found are
+// returned to be looked for again when more items are parsed, they are left
+// as they are once the whole book is parsed.
+func MigrateAnnotations(store *db.DB, buffer *Buffer, annos []db.Annotation) ([]db.Annotation, error) {
+	rest := []db.Annotation{}
+	for i, anno := range annos {
+		match, found := FindQuote(buffer, anno.Text, "", "")
+		if !found {
+			if len(buffer.pending) > 0 {
+				rest = append(rest, anno)
+			} else {
+				log.Warnf("failed to anchor annotation %d: %q", anno.ID, anno.Text)
+			}
+			continue
+		}
+		anchored := NewAnchoredAnnotation(anno.Type, buffer,
+			match.BufferLinum, match.Start, match.BufferLinum, match.End)
+		anno.Start, anno.End = anchored.Start, anchored.End
+		anno.Prefix, anno.Suffix = anchored.Prefix, anchored.Suffix
+		if err := store.UpdateAnchor(anno); err != nil {
+			return append(rest, annos[i:]...), err
+		}
+	}
+	return rest, nil
+}
@@ -0,0 +1,70 @@
+package saturn
+
+import (
+	"testing"
+
+	"github.com/elinx/saturn/pkg/db"
+)
+
+func newAnchorTestBuffer() *Buffer {
+	buffer := NewBuffer()
+	buffer.Lines = []Line{
+		{Content: "Title"},
+		{Content: "The way you can go isn't the real way."},
+		{Content: "The name you can say isn't the real name."},
+		{Content: "Heaven and earth begin in the unnamed: name's the mother of the ten thousand things."},
+	}
+	buffer.BlockPos["c0"] = 0
+	buffer.BlockPos["c1"] = 1
+	return buffer
+}
+
+func TestPositionOf(t *testing.T) {
+	buffer := newAnchorTestBuffer()
+	pos := PositionOf(buffer, 2, 9)
+	if expect := (db.Position{ManifestId: "c1", Line: 1, Rune: 9}); pos != expect {
+		t.Errorf("got %v, expect %v", pos, expect)
+	}
+	linum, x, ok := BufferPosOf(buffer, pos)
+	if !ok || linum != 2 || x != 9 {
+		t.Errorf("got (%d, %d, %v), expect (2, 9, true)", linum, x, ok)
+	}
+	if _, _, ok := BufferPosOf(buffer, db.Position{ManifestId: "unknown"}); ok {
+		t.Error("expect unknown spine item to fail")
+	}
+}
+
+func TestNewAnchoredAnnotation(t *testing.T) {
+	buffer := newAnchorTestBuffer()
+	anno := NewAnchoredAnnotation(db.AnnotationHighlight, buffer, 1, 30, 2, 8)
+	if anno.Text != "eal way.\nThe name" {
+		t.Errorf("unexpected text: %q", anno.Text)
+	}
+	if anno.Prefix != "The way you can go isn't the r" || anno.Suffix != " you can say isn't the real name" {
+		t.Errorf("unexpected context: %q, %q", anno.Prefix, anno.Suffix)
+	}
+	if anno.Start != (db.Position{ManifestId: "c1", Line: 0, Rune: 30}) || anno.End != (db.Position{ManifestId: "c1", Line: 1, Rune: 8}) {
+		t.Errorf("unexpected range: %v, %v", anno.Start, anno.End)
+	}
+}
+
+func TestFindQuote(t *testing.T) {
+	buffer := newAnchorTestBuffer()
+	testcases := []struct {
+		quote, prefix, suffix string
+		expect                Match
+		found                 bool
+	}{
+		{"the real", "", "", Match{1, 25, 33}, true},
+		{"the real", "say isn't ", " name", Match{2, 27, 35}, true},
+		{" the unnamed ", "", "", Match{3, 26, 37}, true},
+		{"not in the book", "", "", Match{}, false},
+		{"way.\nThe", "", "", Match{}, false},
+	}
+	for _, tc := range testcases {
+		match, found := FindQuote(buffer, tc.quote, tc.prefix, tc.suffix)
+		if found != tc.found || match != tc.expect {
+			t.Errorf("quote %q: got (%v, %v), expect (%v, %v)", tc.quote, match, found, tc.expect, tc.found)
+		}
+	}
+}
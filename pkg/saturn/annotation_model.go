@@ -0,0 +1,357 @@
+package saturn
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/elinx/saturn/pkg/db"
+	"github.com/elinx/saturn/pkg/epub"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// excerptLen is the max runes of the annotated text shown in the browser
+const excerptLen = 60
+
+type annotationItem struct {
+	anno    db.Annotation
+	section string
+}
+
+func (i annotationItem) FilterValue() string {
+	return strings.Join([]string{string(i.anno.Type), i.anno.Text, i.anno.Comment, i.section}, " ")
+}
+func (i annotationItem) Title() string {
+	swatch := lipgloss.NewStyle().Foreground(theme.color(annotationColor(i.anno))).Render("●")
+	return swatch + " " + excerpt(i.anno.Text, excerptLen)
+}
+func (i annotationItem) Description() string {
+	parts := []string{string(i.anno.Type)}
+	if i.section != "" {
+		parts = append(parts, i.section)
+	}
+	if i.anno.Comment != "" {
+		parts = append(parts, excerpt(i.anno.Comment, excerptLen))
+	}
+	return strings.Join(parts, " · ")
+}
+
+// excerpt joins the lines of the text and cuts it to at most n runes
+func excerpt(text string, n int) string {
+	runes := []rune(strings.Join(strings.Fields(text), " "))
+	if len(runes) > n {
+		return string(runes[:n-1]) + "…"
+	}
+	return string(runes)
+}
+
+// AnnotationMessage asks the text model to jump to the annotation
+type AnnotationMessage struct {
+	Annotation db.Annotation
+}
+
+// annotationModel lists the annotations of the book, the selected one can be
+// jumped to, commented, recolored, retyped or deleted.
+type annotationModel struct {
+	book      *epub.Epub
+	db        *db.DB
+	renderer  *Renderer
+	textModel tea.Model
+	prevModel tea.Model
+	sections  *SectionIndex
+	list      list.Model
+	width     int
+	height    int
+
+	confirmDelete bool
+	// chooseFormat waits for the key of the export format
+	chooseFormat bool
+	statusMsg    string
+}
+
+// NewAnnotationModel creates the annotation browser, selecting an annotation
+// switches to the text model at its position and esc returns to prev.
+func NewAnnotationModel(book *epub.Epub, db *db.DB, renderer *Renderer,
+	text tea.Model, prev tea.Model, width, height int) tea.Model {
+	m := &annotationModel{
+		book:      book,
+		db:        db,
+		renderer:  renderer,
+		textModel: text,
+		prevModel: prev,
+		width:     width,
+		height:    height,
+	}
+	// the last line is left for the status
+	m.list = list.New(nil, list.DefaultDelegate{
+		ShowDescription: true,
+		Styles:          list.NewDefaultItemStyles(),
+	}, width, height-1)
+	m.list.Title = "Annotations of " + book.Title()
+	m.list.DisableQuitKeybindings()
+	m.reload()
+	return m
+}
+
+func (m *annotationModel) Init() tea.Cmd {
+	return nil
+}
+
+// reload reads the annotations from the database again, the selection stays
+// at the same index. The annotations in the spine items not parsed yet are
+// put in the section at the start of the item until they are parsed.
+func (m *annotationModel) reload() tea.Cmd {
+	m.sections = NewSectionIndex(m.book.GetTableOfContent(), m.renderer.buffer)
+	annos, err := m.db.Annotations()
+	if err != nil {
+		log.Error(err)
+		m.statusMsg = err.Error()
+		return nil
+	}
+	items := []list.Item{}
+	for _, anno := range annos {
+		items = append(items, annotationItem{anno, m.sectionOf(anno)})
+	}
+	index := m.list.Index()
+	cmd := m.list.SetItems(items)
+	if visible := len(m.list.VisibleItems()); index >= visible && visible > 0 {
+		index = visible - 1
+	}
+	m.list.Select(index)
+	return cmd
+}
+
+func (m *annotationModel) sectionOf(anno db.Annotation) string {
+	linum, _, ok := BufferPosOf(m.renderer.buffer, anno.Start)
+	if !ok {
+		return ""
+	}
+	if node := m.sections.Find(linum); node != nil {
+		return node.Title
+	}
+	return ""
+}
+
+func (m *annotationModel) Update(message tea.Msg) (tea.Model, tea.Cmd) {
+	if msg, ok := message.(tea.KeyMsg); ok && m.confirmDelete {
+		m.confirmDelete = false
+		m.statusMsg = ""
+		if msg.String() == "y" {
+			return m, m.change(func(selected *annotationItem) error {
+				return m.db.DeleteAnnotation(selected.anno.ID)
+			}, true)
+		}
+		return m, nil
+	}
+	if msg, ok := message.(tea.KeyMsg); ok && m.chooseFormat {
+		m.chooseFormat = false
+		m.statusMsg = ""
+		if format, ok := exportFormatOfKey(msg.String()); ok {
+			m.exportNotes(format)
+		}
+		return m, nil
+	}
+	switch msg := message.(type) {
+	case tea.KeyMsg:
+		if m.list.FilterState() == list.Filtering {
+			break
+		}
+		m.statusMsg = ""
+		switch msg.String() {
+		case "ctrl+c":
+			// the text model saves the position before quitting
+			_, cmd := m.textModel.Update(msg)
+			return m, cmd
+		case "q":
+			return m.prevModel, nil
+		case "esc":
+			if m.list.FilterState() == list.Unfiltered {
+				return m.prevModel, nil
+			}
+		case "enter":
+			if selected, ok := m.list.SelectedItem().(annotationItem); ok {
+				return m.textModel, func() tea.Msg {
+					return AnnotationMessage{selected.anno}
+				}
+			}
+		case "e":
+			if selected, ok := m.list.SelectedItem().(annotationItem); ok {
+				return NewNoteEditorModel(selected.anno, m, m.width, m.height), nil
+			}
+		case "c":
+			return m, m.change(func(selected *annotationItem) error {
+				selected.anno.Color = nextColor(selected.anno.Color)
+				return m.db.UpdateAnnotation(selected.anno)
+			}, false)
+		case "t":
+			return m, m.change(func(selected *annotationItem) error {
+				selected.anno.Type = nextType(selected.anno.Type)
+				return m.db.UpdateAnnotation(selected.anno)
+			}, false)
+		case "x":
+			m.chooseFormat = true
+			keys := []string{}
+			for _, format := range ExportFormats {
+				keys = append(keys, fmt.Sprintf("%s: %s", format[:1], format))
+			}
+			m.statusMsg = "export as " + strings.Join(keys, "  ") + "  other: cancel"
+			return m, nil
+		case "d":
+			if _, ok := m.list.SelectedItem().(annotationItem); ok {
+				m.confirmDelete = true
+				m.statusMsg = "delete the annotation? (y/n)"
+			}
+			return m, nil
+		}
+	case WriteErrorMessage:
+		m.statusMsg = msg.Err.Error()
+		return m, msg.Cmd
+	case CommentMessage:
+		return m, m.change(func(selected *annotationItem) error {
+			selected.anno.Comment = msg.Annotation.Comment
+			return m.db.UpdateAnnotation(selected.anno)
+		}, false)
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.list.SetSize(msg.Width, msg.Height-1)
+		// the text behind is wrapped again too
+		_, cmd := m.prevModel.Update(msg)
+		return m, cmd
+	case layoutMessage, savePositionMessage:
+		_, cmd := m.prevModel.Update(msg)
+		return m, cmd
+	case parsedMessage:
+		// the sections of the annotations parsed are found again
+		_, cmd := m.prevModel.Update(msg)
+		return m, tea.Batch(cmd, m.reload())
+	}
+	var cmd tea.Cmd
+	m.list, cmd = m.list.Update(message)
+	return m, cmd
+}
+
+// change applies fn on the selected annotation and repaints the text with
+// the stored annotations, deleted tells the annotation is gone
+func (m *annotationModel) change(fn func(selected *annotationItem) error, deleted bool) tea.Cmd {
+	selected, ok := m.list.SelectedItem().(annotationItem)
+	if !ok {
+		return nil
+	}
+	old := selected.anno
+	if err := fn(&selected); err != nil {
+		log.Error(err)
+		m.statusMsg = err.Error()
+		return nil
+	}
+	cmd := m.reload()
+	annos := []db.Annotation{}
+	for _, item := range m.list.Items() {
+		annos = append(annos, item.(annotationItem).anno)
+	}
+	m.renderer.RefreshAnnotations(annos, old)
+	if deleted {
+		m.statusMsg = fmt.Sprintf("deleted: %s", excerpt(old.Text, excerptLen))
+	}
+	return cmd
+}
+
+// exportFormatOfKey returns the format chosen by its first letter
+func exportFormatOfKey(key string) (ExportFormat, bool) {
+	for _, format := range ExportFormats {
+		if key == string(format[:1]) {
+			return format, true
+		}
+	}
+	return "", false
+}
+
+// exportNotes writes the annotations of the book into a file of the export
+// directory named after the book
+func (m *annotationModel) exportNotes(format ExportFormat) {
+	annos := []db.Annotation{}
+	for _, item := range m.list.Items() {
+		annos = append(annos, item.(annotationItem).anno)
+	}
+	filename, err := notesPath(exportDir, m.book.Title(), format)
+	if err != nil {
+		log.Error(err)
+		m.statusMsg = err.Error()
+		return
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		log.Error(err)
+		m.statusMsg = err.Error()
+		return
+	}
+	defer file.Close()
+	chapters := GroupAnnotations(m.book.GetTableOfContent(), m.renderer.buffer, annos)
+	if err := ExportAnnotations(file, m.book.Title(), chapters, format); err != nil {
+		log.Error(err)
+		m.statusMsg = err.Error()
+		return
+	}
+	m.statusMsg = fmt.Sprintf("%d annotations exported to %s", len(annos), filename)
+}
+
+// notesPath returns the absolute path of the notes of the book in dir, dir is
+// created if it doesn't exist
+func notesPath(dir, title string, format ExportFormat) (string, error) {
+	dir, err := expandHome(dir)
+	if err != nil {
+		return "", err
+	}
+	if dir, err = filepath.Abs(dir); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", errors.Wrapf(err, "failed to create %s", dir)
+	}
+	return filepath.Join(dir, notesFileName(title, format)), nil
+}
+
+// notesFileName replaces the characters not allowed in file names
+func notesFileName(title string, format ExportFormat) string {
+	name := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || unicode.IsControl(r) {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(title))
+	if name == "" {
+		name = "book"
+	}
+	return name + "-notes" + format.Ext()
+}
+
+func nextColor(color string) string {
+	for i, c := range annotationPalette {
+		if c == color {
+			return annotationPalette[(i+1)%len(annotationPalette)]
+		}
+	}
+	return annotationPalette[0]
+}
+
+func nextType(t db.AnnotationType) db.AnnotationType {
+	for i, v := range annotationTypes {
+		if v == t {
+			return annotationTypes[(i+1)%len(annotationTypes)]
+		}
+	}
+	return annotationTypes[0]
+}
+
+func (m *annotationModel) View() string {
+	status := m.statusMsg
+	if status == "" {
+		status = "enter: jump  e: comment  c: color  t: type  d: delete  x: export  esc: back"
+	}
+	return m.list.View() + "\n" + status
+}
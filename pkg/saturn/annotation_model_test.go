@@ -0,0 +1,74 @@
+package saturn
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/elinx/saturn/pkg/db"
+)
+
+func TestExcerpt(t *testing.T) {
+	testcases := []struct {
+		text   string
+		n      int
+		expect string
+	}{
+		{"the real way", 20, "the real way"},
+		{"the real\n  way", 20, "the real way"},
+		{"the real way", 8, "the rea…"},
+		{"道可道非常道", 4, "道可道…"},
+	}
+	for _, tc := range testcases {
+		if got := excerpt(tc.text, tc.n); got != tc.expect {
+			t.Errorf("excerpt(%q, %d): got %q, expect %q", tc.text, tc.n, got, tc.expect)
+		}
+	}
+}
+
+func TestNextColorAndType(t *testing.T) {
+	if got := nextColor(""); got != annotationPalette[1] {
+		t.Errorf("got %q, expect %q", got, annotationPalette[1])
+	}
+	if got := nextColor(annotationPalette[len(annotationPalette)-1]); got != "" {
+		t.Errorf("expect the palette to wrap, got %q", got)
+	}
+	if got := nextColor("unknown"); got != "" {
+		t.Errorf("expect the default color, got %q", got)
+	}
+	if got := nextType(db.AnnotationComment); got != db.AnnotationHighlight {
+		t.Errorf("expect the types to wrap, got %q", got)
+	}
+}
+
+func TestExportFormatOfKey(t *testing.T) {
+	testcases := []struct {
+		key    string
+		expect ExportFormat
+		ok     bool
+	}{
+		{"m", ExportMarkdown, true},
+		{"j", ExportJSON, true},
+		{"c", ExportCSV, true},
+		{"esc", "", false},
+	}
+	for _, tc := range testcases {
+		if got, ok := exportFormatOfKey(tc.key); got != tc.expect || ok != tc.ok {
+			t.Errorf("case %s: got %q %v, expect %q %v", tc.key, got, ok, tc.expect, tc.ok)
+		}
+	}
+}
+
+func TestNotesPath(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "notes")
+	got, err := notesPath(dir, "Tao Te Ching", ExportJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := filepath.Join(dir, "Tao Te Ching-notes.json"); got != expect {
+		t.Errorf("got %s, expect %s", got, expect)
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		t.Errorf("expect %s to be created, got %v", dir, err)
+	}
+}
@@ -0,0 +1,135 @@
+package saturn
+
+import (
+	"github.com/charmbracelet/lipgloss"
+	"github.com/elinx/saturn/pkg/db"
+	"github.com/elinx/saturn/pkg/epub"
+	log "github.com/sirupsen/logrus"
+)
+
+// annotationSpan is the part of an annotation on one buffer line, it covers
+// the runes [start, end)
+type annotationSpan struct {
+	anno  db.Annotation
+	linum BufferLineIndex
+	start RuneIndex
+	end   RuneIndex
+}
+
+// annotationPainter paints the annotations onto the rendered runes of their
+// lines, the spans are in buffer coordinates so the painting can be applied
+// again after the buffer is wrapped with another width.
+type annotationPainter struct {
+	buffer *Buffer
+	// annos are located again when spine items are parsed, the ones in the
+	// items not parsed yet have no spans
+	annos []db.Annotation
+	spans map[BufferLineIndex][]annotationSpan
+}
+
+func newAnnotationPainter(buffer *Buffer) *annotationPainter {
+	return &annotationPainter{
+		buffer: buffer,
+		spans:  make(map[BufferLineIndex][]annotationSpan),
+	}
+}
+
+// Add records the annotation to be painted on every render, false is returned
+// if the annotation can't be found in the buffer
+func (p *annotationPainter) Add(anno db.Annotation) bool {
+	p.annos = append(p.annos, anno)
+	spans, ok := p.spansOf(anno)
+	for _, span := range spans {
+		p.spans[span.linum] = append(p.spans[span.linum], span)
+	}
+	return ok
+}
+
+// locate finds the spans of the annotations again after the lines moved
+func (p *annotationPainter) locate() {
+	p.spans = make(map[BufferLineIndex][]annotationSpan)
+	for _, anno := range p.annos {
+		spans, _ := p.spansOf(anno)
+		for _, span := range spans {
+			p.spans[span.linum] = append(p.spans[span.linum], span)
+		}
+	}
+}
+
+// Paint applies the annotation on the rendered runes of its lines only
+func (p *annotationPainter) Paint(anno db.Annotation) {
+	spans, _ := p.spansOf(anno)
+	for _, span := range spans {
+		p.paintSpan(span)
+		p.markLine(span.linum)
+	}
+}
+
+// PaintLine applies all the annotations of the line on its rendered runes
+func (p *annotationPainter) PaintLine(linum BufferLineIndex) {
+	for _, span := range p.spans[linum] {
+		p.paintSpan(span)
+	}
+	p.markLine(linum)
+}
+
+// hasNote tells whether any annotation on the line has a comment
+func (p *annotationPainter) hasNote(linum BufferLineIndex) bool {
+	for _, span := range p.spans[linum] {
+		if span.anno.Type == db.AnnotationComment || span.anno.Comment != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// markLine shows the note mark in the gutter of the first visual line
+func (p *annotationPainter) markLine(linum BufferLineIndex) {
+	lines, ok := p.buffer.renderedLines(linum)
+	if p.buffer.renderer == nil || !ok || len(lines) == 0 {
+		return
+	}
+	lines[0].Mark = p.buffer.renderer.RenderMark(p.hasNote(linum))
+}
+
+func (p *annotationPainter) paintSpan(span annotationSpan) {
+	p.buffer.StyleRunes(span.linum, span.start, span.end, func(s lipgloss.Style) lipgloss.Style {
+		return styleAnnotation(s, span.anno)
+	})
+}
+
+// spansOf splits the annotation into the spans of each line. The range of the
+// annotation is used if the text there is still the quote, otherwise the
+// quote is looked for with its context in the lines parsed. The annotation
+// has no spans until the spine items it may be in are parsed.
+func (p *annotationPainter) spansOf(anno db.Annotation) ([]annotationSpan, bool) {
+	pending := p.buffer.pending
+	if pending[epub.ManifestId(anno.Start.ManifestId)] || pending[epub.ManifestId(anno.End.ManifestId)] {
+		return nil, true
+	}
+	startLinum, startX, startOk := BufferPosOf(p.buffer, anno.Start)
+	endLinum, endX, endOk := BufferPosOf(p.buffer, anno.End)
+	if !startOk || !endOk || p.buffer.Text(startLinum, startX, endLinum, endX) != anno.Text {
+		match, found := FindQuote(p.buffer, anno.Text, anno.Prefix, anno.Suffix)
+		if !found && len(pending) > 0 {
+			return nil, true
+		}
+		if !found {
+			log.Warnf("annotation %d not found in the book: %q", anno.ID, anno.Text)
+			return nil, false
+		}
+		startLinum, startX, endLinum, endX = match.BufferLinum, match.Start, match.BufferLinum, match.End
+	}
+	spans := []annotationSpan{}
+	for linum := startLinum; linum <= endLinum; linum++ {
+		span := annotationSpan{anno, linum, 0, RuneIndex(len([]rune(p.buffer.Lines[linum].Content)))}
+		if linum == startLinum {
+			span.start = startX
+		}
+		if linum == endLinum {
+			span.end = endX
+		}
+		spans = append(spans, span)
+	}
+	return spans, true
+}
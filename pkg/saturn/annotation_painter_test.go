@@ -0,0 +1,103 @@
+package saturn
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/elinx/saturn/pkg/db"
+)
+
+// highlightedRunes returns the buffer positions of the highlighted runes of a
+// line
+func highlightedRunes(buffer *Buffer, linum BufferLineIndex) []RuneIndex {
+	highlighted := []RuneIndex{}
+	x := RuneIndex(0)
+	for vy := buffer.GetVisualPos(linum, 0); int(vy) < buffer.VisualLinesNum(); vy++ {
+		line := buffer.visualLine(vy)
+		if line.BufferLinum != linum {
+			break
+		}
+		for _, r := range line.Runes {
+			if r.Style.GetBackground() == theme.color(theme.Annotations[db.AnnotationHighlight]) {
+				highlighted = append(highlighted, x)
+			}
+			x++
+		}
+	}
+	return highlighted
+}
+
+func TestAnnotationPainter(t *testing.T) {
+	anno := NewAnchoredAnnotation(db.AnnotationHighlight, newAnchorTestBuffer(), 1, 34, 1, 38)
+	stale := anno
+	stale.Start.Line, stale.End.Line = 1, 1
+	testcases := []struct {
+		name  string
+		anno  db.Annotation
+		width int
+	}{
+		{"anchored", anno, 80},
+		{"wrapped", anno, 10},
+		{"stale range", stale, 80},
+	}
+	expect := []RuneIndex{34, 35, 36, 37}
+	for _, tc := range testcases {
+		buffer := newAnchorTestBuffer()
+		renderer := NewRender(nil, buffer)
+		renderer.ShowLinum = false
+		renderer.SetAnnotations([]db.Annotation{tc.anno})
+		renderer.Render(tc.width)
+		if got := highlightedRunes(buffer, 1); !reflect.DeepEqual(got, expect) {
+			t.Errorf("case %s: got %v, expect %v", tc.name, got, expect)
+		}
+		if got := highlightedRunes(buffer, 2); len(got) != 0 {
+			t.Errorf("case %s: unexpected highlighted runes %v", tc.name, got)
+		}
+	}
+}
+
+func TestAddAnnotation(t *testing.T) {
+	buffer := newAnchorTestBuffer()
+	renderer := NewRender(nil, buffer)
+	renderer.ShowLinum = false
+	renderer.Render(10)
+	renderer.AddAnnotation(NewAnchoredAnnotation(db.AnnotationHighlight, buffer, 2, 0, 2, 3))
+	if got, expect := highlightedRunes(buffer, 2), []RuneIndex{0, 1, 2}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("got %v, expect %v", got, expect)
+	}
+}
+
+func TestRefreshAnnotations(t *testing.T) {
+	buffer := newAnchorTestBuffer()
+	renderer := NewRender(nil, buffer)
+	renderer.ShowLinum = false
+	kept := NewAnchoredAnnotation(db.AnnotationHighlight, buffer, 1, 0, 1, 3)
+	deleted := NewAnchoredAnnotation(db.AnnotationHighlight, buffer, 2, 0, 2, 3)
+	renderer.SetAnnotations([]db.Annotation{kept, deleted})
+	renderer.Render(10)
+	renderer.RefreshAnnotations([]db.Annotation{kept}, deleted)
+	if got := highlightedRunes(buffer, 2); len(got) != 0 {
+		t.Errorf("unexpected highlighted runes %v", got)
+	}
+	if got, expect := highlightedRunes(buffer, 1), []RuneIndex{0, 1, 2}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("got %v, expect %v", got, expect)
+	}
+}
+
+func TestNoteMark(t *testing.T) {
+	buffer := newAnchorTestBuffer()
+	renderer := NewRender(nil, buffer)
+	highlight := NewAnchoredAnnotation(db.AnnotationHighlight, buffer, 1, 0, 1, 3)
+	comment := NewAnchoredAnnotation(db.AnnotationHighlight, buffer, 2, 0, 3, 3, db.WithComment("a note"))
+	renderer.SetAnnotations([]db.Annotation{highlight, comment})
+	renderer.Render(20)
+	for linum, expect := range []bool{false, false, true, true} {
+		vy := buffer.GetVisualPos(BufferLineIndex(linum), 0)
+		if got := buffer.visualLine(vy).Mark == renderer.RenderMark(true); got != expect {
+			t.Errorf("line %d: got mark %v, expect %v", linum, got, expect)
+		}
+		if next := buffer.visualLine(vy + 1); next.Mark != renderer.RenderMark(false) {
+			t.Errorf("line %d: unexpected mark on the wrapped line %q", linum, next.Mark)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package saturn
+
+import (
+	"regexp"
+
+	"github.com/elinx/saturn/pkg/epub"
+)
+
+// BookMatch is a search hit in a book file
+type BookMatch struct {
+	File    string          `json:"file"`
+	Title   string          `json:"title"`
+	Section string          `json:"section"`
+	Line    BufferLineIndex `json:"line"`
+	Column  RuneIndex       `json:"column"`
+	Text    string          `json:"text"`
+	Context string          `json:"context"`
+}
+
+// SearchBook opens the epub file, parses it and searches the pattern in its
+// text. Context is the text of the paragraph around the match, at most
+// `contextWidth` runes on each side. The book is parsed on one goroutine, the
+// books are searched concurrently by the callers.
+func SearchBook(filename string, re *regexp.Regexp, contextWidth int) ([]BookMatch, error) {
+	book := epub.NewEpub(filename)
+	if err := book.Open(); err != nil {
+		return nil, err
+	}
+	defer book.Close()
+
+	parser := NewParser(book)
+	parser.Workers = 1
+	if err := parser.Parse(); err != nil {
+		return nil, err
+	}
+	buffer := parser.GetBuffer()
+	matches := buffer.SearchRegexp(re)
+	sections := NewSectionIndex(book.GetTableOfContent(), buffer)
+	results := []BookMatch{}
+	for _, match := range matches {
+		runes := []rune(buffer.Lines[match.BufferLinum].Content)
+		start := RuneIndex(0)
+		if match.Start > RuneIndex(contextWidth) {
+			start = match.Start - RuneIndex(contextWidth)
+		}
+		end := match.End + RuneIndex(contextWidth)
+		if end > RuneIndex(len(runes)) {
+			end = RuneIndex(len(runes))
+		}
+		section := ""
+		if node := sections.Find(match.BufferLinum); node != nil {
+			section = node.Title
+		}
+		results = append(results, BookMatch{
+			File:    filename,
+			Title:   book.Title(),
+			Section: section,
+			Line:    match.BufferLinum,
+			Column:  match.Start,
+			Text:    string(runes[match.Start:match.End]),
+			Context: string(runes[start:end]),
+		})
+	}
+	return results, nil
+}
@@ -1,21 +1,15 @@
 package saturn
 
 import (
+	"sort"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/charmbracelet/lipgloss"
 	"github.com/elinx/saturn/pkg/epub"
 	"github.com/zyedidia/go-runewidth"
 )
 
-type IVisualVisiter interface {
-	VisitBufferPrev(*Buffer) bool
-	VisitBufferPost(*Buffer) bool
-	VisitLinePrev(*VisualLine) bool
-	VisitLinePost(*VisualLine) bool
-	VisitRune(*VisualRune) bool
-}
-
 // RuneIndex returns the index of the rune in the given string
 type RuneIndex int
 
@@ -36,6 +30,12 @@ type Segment struct {
 	Content string
 	Style   string
 	Pos     ByteIndex
+
+	// Link is the target of the hyperlink relative to the rootfile, empty if
+	// the segment is not a link. LinkType is the epub:type of the link, e.g.
+	// noteref
+	Link     epub.HRef
+	LinkType string
 }
 
 // Line contains the text parsed from the ebooks together with
@@ -52,10 +52,8 @@ type VisualRune struct {
 	Style lipgloss.Style
 	VC    string
 	Dirty bool
-}
-
-func (r *VisualRune) Accept(visitor IVisualVisiter) {
-	visitor.VisitRune(r)
+	// Selected draws the selection of the theme over the style
+	Selected bool
 }
 
 type VisualLine struct {
@@ -71,28 +69,19 @@ type VisualLine struct {
 	// rendered line number
 	LineNum    string
 	LinumStyle lipgloss.Style
+	// rendered mark after the line number, it tells the line has a comment
+	Mark string
 
 	Dirty bool
 }
 
-func (v *VisualLine) Accept(visitor IVisualVisiter) {
-	dirty := false
-	dirty = dirty || visitor.VisitLinePrev(v)
-	for _, vr := range v.Runes {
-		dirty = dirty || visitor.VisitRune(&vr)
-	}
-	dirty = dirty || visitor.VisitLinePost(v)
-	v.Dirty = dirty
-}
-
 func (v *VisualLine) MarkPosition(vx VisualIndex) string {
 	v.Dirty = true
 	pos := 0
 	for i, vr := range v.Runes {
 		width := runewidth.RuneWidth(vr.C)
 		if pos+width > int(vx) {
-			v.Runes[i].Dirty = true
-			v.Runes[i].Style.Reverse(true)
+			v.Runes[i].Selected = true
 			return string(v.Runes[i].C)
 		}
 		pos += width
@@ -137,7 +126,7 @@ func (v *VisualLine) MarkLine() string {
 	content := ""
 	for i := range v.Runes {
 		content += string(v.Runes[i].C)
-		v.Runes[i].Style.Reverse(true)
+		v.Runes[i].Selected = true
 	}
 	return content
 }
@@ -145,7 +134,7 @@ func (v *VisualLine) MarkLine() string {
 func (v *VisualLine) ClearLine() {
 	v.Dirty = true
 	for i := range v.Runes {
-		v.Runes[i].Style.Reverse(false)
+		v.Runes[i].Selected = false
 	}
 }
 
@@ -158,7 +147,9 @@ func (v *VisualLine) Render() string {
 	var content strings.Builder
 	// content.WriteString(v.LineNum)
 	for _, vr := range v.Runes {
-		if vr.Dirty {
+		if vr.Selected {
+			content.WriteString(theme.selected(vr.Style).String())
+		} else if vr.Dirty {
 			content.WriteString(vr.Style.String())
 		} else {
 			content.WriteString(vr.VC)
@@ -175,26 +166,49 @@ type Buffer struct {
 	// The position of each block of the spine in the Lines
 	BlockPos map[epub.ManifestId]BufferLineIndex
 
-	// lineYOffsets is the offset of each line in the buffer after
-	// being rendered to the screen. It is used to calculate the
-	// position of each rune in the line.
-	visualLineOffset []VisualLineIndex
+	// The position of the elements with an id attribute in each block of the
+	// spine, it is used to resolve the fragment of `href#anchor`
+	Anchors map[epub.ManifestId]map[string]BufferLineIndex
+
+	// sections are where the parts of the buffer are shown on the screen,
+	// their visual lines are estimated until they are shown
+	sections []sectionLayout
+
+	// rendered keeps the sections rendered to the screen lately
+	rendered *sectionCache
 
-	// visualLines are lines that have been rendered.
-	visualLines []VisualLine
+	// pending are the spine items from ParseLazy not parsed yet, their lines
+	// are blank until loader parses them
+	pending map[epub.ManifestId]bool
+	loader  *itemLoader
+	// loads counts the times spine items were parsed into the buffer
+	loads int
+
+	// onReflow is told where the estimated lines were replaced, the positions
+	// kept on the screen are moved with them
+	onReflow func(reflow)
+
+	// highlights are the search matches painted on the lines, true for the
+	// current one
+	highlights map[Match]bool
 }
 
 func NewBuffer() *Buffer {
 	return &Buffer{
-		Lines:       []Line{},
-		BlockPos:    make(map[epub.ManifestId]BufferLineIndex),
-		visualLines: make([]VisualLine, 0),
+		Lines:    []Line{},
+		BlockPos: make(map[epub.ManifestId]BufferLineIndex),
+		Anchors:  make(map[epub.ManifestId]map[string]BufferLineIndex),
+		pending:  make(map[epub.ManifestId]bool),
 	}
 }
 
 // VisualLinesNum returns total lines number after rendition
 func (b *Buffer) VisualLinesNum() int {
-	return len(b.visualLines)
+	if len(b.sections) == 0 {
+		return 0
+	}
+	last := b.sections[len(b.sections)-1]
+	return int(last.vstart) + last.lines
 }
 
 func (b *Buffer) LinesNum() int {
@@ -207,13 +221,12 @@ func (b *Buffer) VisualLines(start, end int) []string {
 }
 
 func (b *Buffer) getVisualLines(start, end VisualLineIndex) (res []string) {
-	for i, line := range b.visualLines[start:end] {
-		linum := VisualLineIndex(i) + start
+	for vy := start; vy < end; vy++ {
+		line := b.visualLine(vy)
 		if line.Dirty {
-			content := line.Render()
-			b.visualLines[linum].Replace(content)
+			line.Replace(line.Render())
 		}
-		res = append(res, b.visualLines[linum].LineNum+b.visualLines[linum].Content)
+		res = append(res, line.LineNum+line.Mark+line.Content)
 	}
 	return
 }
@@ -223,13 +236,56 @@ func (b *Buffer) GetBufferLineNumById(id epub.ManifestId) BufferLineIndex {
 }
 
 func (b *Buffer) GetVisualLineNumById(id epub.ManifestId) VisualLineIndex {
-	return b.visualLineOffset[b.BlockPos[id]]
+	return b.GetVisualLineNumByAnchor(id, "")
+}
+
+// GetBlockByLine returns the spine item containing the buffer line and the
+// line where the item starts
+func (b *Buffer) GetBlockByLine(linum BufferLineIndex) (epub.ManifestId, BufferLineIndex) {
+	id, start := epub.ManifestId(""), BufferLineIndex(-1)
+	for k, v := range b.BlockPos {
+		// empty spine items share the start with the next one, any of them
+		// maps back to the same line, the tie is broken by id to be stable
+		if v <= linum && (v > start || (v == start && k > id)) {
+			id, start = k, v
+		}
+	}
+	return id, start
+}
+
+// AddAnchor records the line of the element `anchor` in the block `id`, the
+// first one wins if the id is duplicated.
+func (b *Buffer) AddAnchor(id epub.ManifestId, anchor string, linum BufferLineIndex) {
+	anchors, ok := b.Anchors[id]
+	if !ok {
+		anchors = make(map[string]BufferLineIndex)
+		b.Anchors[id] = anchors
+	}
+	if _, ok := anchors[anchor]; !ok {
+		anchors[anchor] = linum
+	}
+}
+
+// GetBufferLineNumByAnchor returns the line of the element `anchor` in the
+// block `id`, the start of the block if the anchor is empty or unknown.
+func (b *Buffer) GetBufferLineNumByAnchor(id epub.ManifestId, anchor string) BufferLineIndex {
+	if linum, ok := b.Anchors[id][anchor]; ok {
+		return linum
+	}
+	return b.BlockPos[id]
+}
+
+// GetVisualLineNumByAnchor returns the visual line of the element `anchor` in
+// the block `id`, the block is parsed first if it's pending
+func (b *Buffer) GetVisualLineNumByAnchor(id epub.ManifestId, anchor string) VisualLineIndex {
+	b.load(id)
+	return b.GetVisualPos(b.GetBufferLineNumByAnchor(id, anchor), 0)
 }
 
 // GetBaseVisualLine returns the y position of the first line of the given
 // visual index(one buffer line maybe rendered to multiple screen lines)
 func (b *Buffer) GetBaseVisualLine(vy VisualLineIndex) VisualLineIndex {
-	return b.visualLineOffset[b.GetBufferLineNumByVisual(vy)]
+	return b.visualPos(b.GetBufferLineNumByVisual(vy), 0)
 }
 
 func (b *Buffer) GetBufferX(bufferLineNum BufferLineIndex, vy VisualLineIndex, vx VisualIndex) RuneIndex {
@@ -238,53 +294,123 @@ func (b *Buffer) GetBufferX(bufferLineNum BufferLineIndex, vy VisualLineIndex, v
 	return b.renderer.GetBufferX(line, vy-vyBase, vx)
 }
 
+// GetBufferLineNumByVisual returns the buffer line the visual line belongs
+// to, the offsets are sorted so it's a binary search. The line is estimated
+// if its section isn't wrapped.
 func (b *Buffer) GetBufferLineNumByVisual(visualLineNum VisualLineIndex) BufferLineIndex {
-	for i, v := range b.visualLineOffset {
-		if v == visualLineNum {
-			return BufferLineIndex(i)
-		} else if v > visualLineNum {
-			return BufferLineIndex(i - 1)
-		}
+	i := b.sectionOfVisual(visualLineNum)
+	if i < 0 {
+		return -1
 	}
-	return BufferLineIndex(len(b.visualLineOffset) - 1)
-}
-
-type Visitor struct {
-	Style   lipgloss.Style
-	Content string
-	Start   int
-	End     int
-}
-
-func (v *Visitor) VisitBufferPrev(b *Buffer) bool {
-	return false
+	s := &b.sections[i]
+	if !s.wrapped() {
+		return s.start + s.estimateLine(visualLineNum, b.sectionLinesNum(i))
+	}
+	j := sort.Search(len(s.offsets), func(j int) bool {
+		return s.vstart+s.offsets[j] > visualLineNum
+	})
+	return s.start + BufferLineIndex(j-1)
 }
 
-func (v *Visitor) VisitBufferPost(b *Buffer) bool {
-	return false
+// Link is a hyperlink in a line of the buffer, it covers the runes [Start, End)
+type Link struct {
+	BufferLinum BufferLineIndex
+	Start       RuneIndex
+	End         RuneIndex
+	Target      epub.HRef
+	Type        string
 }
 
-func (v *Visitor) VisitLinePrev(l *VisualLine) bool {
+// IsNoteRef returns true if the link refers to a footnote or an endnote
+func (l Link) IsNoteRef() bool {
+	for _, t := range strings.Fields(l.Type) {
+		if t == "noteref" {
+			return true
+		}
+	}
 	return false
 }
 
-func (v *Visitor) VisitLinePost(l *VisualLine) bool {
-	return false
+// GetLinks returns the links in the buffer lines [start, end)
+func (b *Buffer) GetLinks(start, end BufferLineIndex) []Link {
+	links := []Link{}
+	for linum := start; linum < end && int(linum) < len(b.Lines); linum++ {
+		line := b.Lines[linum]
+		for _, s := range line.Segments {
+			if s.Link == "" {
+				continue
+			}
+			x := RuneIndex(utf8.RuneCountInString(line.Content[:s.Pos]))
+			links = append(links, Link{
+				BufferLinum: linum,
+				Start:       x,
+				End:         x + RuneIndex(utf8.RuneCountInString(s.Content)),
+				Target:      s.Link,
+				Type:        s.LinkType,
+			})
+		}
+	}
+	return links
 }
 
-func (v *Visitor) VisitRune(r *VisualRune) bool {
-	v.Content += string(r.C)
-	r.Style = r.Style.Reverse(true)
-	return true
+// GetLinkAt returns the link covering the rune x of the buffer line
+func (b *Buffer) GetLinkAt(linum BufferLineIndex, x RuneIndex) (Link, bool) {
+	for _, link := range b.GetLinks(linum, linum+1) {
+		if x >= link.Start && x < link.End {
+			return link, true
+		}
+	}
+	return Link{}, false
 }
 
-func (b *Buffer) Accept(visitor IVisualVisiter) {
-	for _, line := range b.visualLines {
-		line.Accept(visitor)
+// GetBufferPos returns the buffer line and the rune index in it of the rune
+// displayed at the visual position, the start of the line is estimated if
+// its section isn't wrapped
+func (b *Buffer) GetBufferPos(vy VisualLineIndex, vx VisualIndex) (BufferLineIndex, RuneIndex) {
+	linum := b.GetBufferLineNumByVisual(vy)
+	i := b.sectionOf(linum)
+	if i < 0 || !b.sections[i].wrapped() {
+		return linum, 0
+	}
+	s := &b.sections[i]
+	first := s.offsets[linum-s.start]
+	x := 0
+	for j := first; j < vy-s.vstart; j++ {
+		x += s.lens[j]
 	}
+	// the visual line holds the runes of the content and the newline closing
+	// the paragraph, see RenderLine
+	runes := append([]rune(b.Lines[linum].Content), '\n')
+	pos := 0
+	for _, c := range runes[x : x+s.lens[vy-s.vstart]] {
+		width := runewidth.RuneWidth(c)
+		if pos+width > int(vx) {
+			break
+		}
+		pos += width
+		x++
+	}
+	return linum, RuneIndex(x)
 }
 
-func visit(b *Buffer) {
-	visitor := &Visitor{}
-	b.Accept(visitor)
+// StyleRunes applies the style function to the runes [start, end) of the
+// buffer line and marks them dirty so they are rendered again, nothing is
+// done if the line isn't rendered
+func (b *Buffer) StyleRunes(linum BufferLineIndex, start, end RuneIndex, style func(lipgloss.Style) lipgloss.Style) {
+	lines, ok := b.renderedLines(linum)
+	if !ok {
+		return
+	}
+	x := RuneIndex(0)
+	for vy := range lines {
+		line := &lines[vy]
+		for i := range line.Runes {
+			if x >= start && x < end {
+				line.Runes[i].Style = style(line.Runes[i].Style)
+				line.Runes[i].Dirty = true
+				line.Dirty = true
+			}
+			x++
+		}
+	}
 }
@@ -0,0 +1,255 @@
+package saturn
+
+import (
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/elinx/saturn/pkg/epub"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Version is the version of saturn, the Makefile sets it at build time with
+// -ldflags "-X github.com/elinx/saturn/pkg/saturn.Version=v1.2.3". The build
+// info is used if it isn't set.
+var Version = "dev"
+
+func init() {
+	if Version == "" || Version == "dev" {
+		Version = buildVersion()
+	}
+}
+
+// buildVersion returns the version of the module saturn is built from, the
+// vcs revision of the checkout if the module isn't versioned
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "dev"
+	}
+	if version := info.Main.Version; version != "" && version != "(devel)" {
+		return version
+	}
+	revision, modified := "", false
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if revision == "" {
+		return "dev"
+	}
+	if modified {
+		return revision + "-dirty"
+	}
+	return revision
+}
+
+// cacheFormat is bumped when the parser or the wrapping changes what is
+// cached, the books cached before are parsed again
+const cacheFormat = 1
+
+// cacheVersion tells the cached files of this build apart
+func cacheVersion() string {
+	return fmt.Sprintf("%s-%d", Version, cacheFormat)
+}
+
+// BookCache keeps the parsed buffer of a book and its layouts at each wrap
+// width in files named after the hash of the book file, a changed book is
+// parsed again. The styles don't take any room so the layouts are shared by
+// the themes. The files written by the other versions of saturn are removed
+// and the least recently used files are removed once the directory is over
+// maxSize.
+type BookCache struct {
+	dir     string
+	hash    string
+	maxSize int64
+}
+
+// NewBookCache returns the cache of the book with the hash in dir, maxSize is
+// in bytes
+func NewBookCache(dir, hash string, maxSize int64) *BookCache {
+	return &BookCache{dir: dir, hash: hash, maxSize: maxSize}
+}
+
+// cachedBuffer is the parsed book as it's stored
+type cachedBuffer struct {
+	Lines    []Line
+	BlockPos map[epub.ManifestId]BufferLineIndex
+	Anchors  map[epub.ManifestId]map[string]BufferLineIndex
+}
+
+// cachedLayout is the wrapped book as it's stored
+type cachedLayout struct {
+	VisualLineOffset []VisualLineIndex
+	VisualLineLens   []int
+}
+
+// path returns the file of the kind of data of the book
+func (c *BookCache) path(kind string) string {
+	return filepath.Join(c.dir, fmt.Sprintf("%s.%s.%s", c.hash, cacheVersion(), kind))
+}
+
+func layoutKind(wrapWidth int) string {
+	return fmt.Sprintf("w%d.layout", wrapWidth)
+}
+
+// LoadBuffer returns the parsed book, false if it isn't cached
+func (c *BookCache) LoadBuffer() (*Buffer, bool) {
+	var cached cachedBuffer
+	if !c.load(c.path("buffer"), &cached) {
+		return nil, false
+	}
+	buffer := NewBuffer()
+	buffer.Lines = append(buffer.Lines, cached.Lines...)
+	for id, linum := range cached.BlockPos {
+		buffer.BlockPos[id] = linum
+	}
+	for id, anchors := range cached.Anchors {
+		buffer.Anchors[id] = anchors
+	}
+	return buffer, true
+}
+
+// SaveBuffer stores the parsed book
+func (c *BookCache) SaveBuffer(b *Buffer) error {
+	return c.save(c.path("buffer"), cachedBuffer{b.Lines, b.BlockPos, b.Anchors})
+}
+
+// loadLayout returns the offsets and the lengths of the visual lines at the
+// wrap width, false if they aren't cached
+func (c *BookCache) loadLayout(wrapWidth int) (cachedLayout, bool) {
+	var cached cachedLayout
+	return cached, c.load(c.path(layoutKind(wrapWidth)), &cached)
+}
+
+// saveLayout stores the visual lines of the buffer wrapped at the width
+func (c *BookCache) saveLayout(wrapWidth int, cached cachedLayout) error {
+	return c.save(c.path(layoutKind(wrapWidth)), cached)
+}
+
+// split fills the estimated sections with their visual lines, the cached
+// layout is of the whole buffer
+func (l cachedLayout) split(sections []sectionLayout) {
+	vy := VisualLineIndex(0)
+	for i := range sections {
+		s := &sections[i]
+		end := len(l.VisualLineOffset)
+		if i+1 < len(sections) {
+			end = int(sections[i+1].start)
+		}
+		base := l.VisualLineOffset[s.start]
+		vend := VisualLineIndex(len(l.VisualLineLens))
+		if end < len(l.VisualLineOffset) {
+			vend = l.VisualLineOffset[end]
+		}
+		s.offsets = make([]VisualLineIndex, 0, end-int(s.start))
+		for _, offset := range l.VisualLineOffset[s.start:end] {
+			s.offsets = append(s.offsets, offset-base)
+		}
+		s.lens = l.VisualLineLens[base:vend]
+		s.vstart, s.lines = vy, len(s.lens)
+		vy += VisualLineIndex(s.lines)
+	}
+}
+
+// load decodes the file into v, a broken file is removed. The time of the
+// file is updated to keep it from being pruned.
+func (c *BookCache) load(path string, v interface{}) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warn(err)
+		}
+		return false
+	}
+	defer file.Close()
+	if err := gob.NewDecoder(file).Decode(v); err != nil {
+		log.Warnf("removing the broken cache %s: %v", path, err)
+		os.Remove(path)
+		return false
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return true
+}
+
+// save encodes v into the file, it's written aside and renamed so a broken
+// file is never read. The cache is pruned after.
+func (c *BookCache) save(path string, v interface{}) error {
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return errors.Wrap(err, "failed to create the cache")
+	}
+	file, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		return errors.Wrap(err, "failed to create the cache")
+	}
+	if err := gob.NewEncoder(file).Encode(v); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return errors.Wrapf(err, "failed to write %s", path)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return errors.Wrapf(err, "failed to write %s", path)
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		os.Remove(file.Name())
+		return errors.Wrapf(err, "failed to write %s", path)
+	}
+	return c.prune()
+}
+
+// prune removes the files of the book written by the other versions and the
+// least recently used files past the size of the cache
+func (c *BookCache) prune() error {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return errors.Wrap(err, "failed to prune the cache")
+	}
+	type cachedFile struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	files := []cachedFile{}
+	size := int64(0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || strings.HasPrefix(name, ".tmp-") {
+			continue
+		}
+		path := filepath.Join(c.dir, name)
+		if strings.HasPrefix(name, c.hash+".") && !strings.HasPrefix(name, c.hash+"."+cacheVersion()+".") {
+			os.Remove(path)
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// removed by another pruning
+			continue
+		}
+		files = append(files, cachedFile{path, info.Size(), info.ModTime()})
+		size += info.Size()
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	for _, f := range files {
+		if size <= c.maxSize {
+			break
+		}
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "failed to prune the cache")
+		}
+		size -= f.size
+	}
+	return nil
+}
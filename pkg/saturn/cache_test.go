@@ -0,0 +1,97 @@
+package saturn
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBookCacheBuffer(t *testing.T) {
+	expect := parseTestEpub(t, "../../test/data/TaoTeChing.epub", 0)
+	cache := NewBookCache(t.TempDir(), "book", 1<<30)
+	if _, ok := cache.LoadBuffer(); ok {
+		t.Fatal("case empty: got a buffer, expect none")
+	}
+	if err := cache.SaveBuffer(expect); err != nil {
+		t.Fatal(err)
+	}
+	got, ok := cache.LoadBuffer()
+	if !ok {
+		t.Fatal("case saved: got no buffer")
+	}
+	if !reflect.DeepEqual(got.Lines, expect.Lines) || !reflect.DeepEqual(got.BlockPos, expect.BlockPos) ||
+		!reflect.DeepEqual(got.Anchors, expect.Anchors) {
+		t.Error("case saved: got a different buffer")
+	}
+}
+
+func TestBookCacheLayout(t *testing.T) {
+	cache := NewBookCache(t.TempDir(), "book", 1<<30)
+	expect := newResizeTestBuffer()
+	r := NewRender(nil, expect)
+	r.SetCache(cache)
+	r.Render(40)
+	cacheWrites.Wait()
+	if _, err := os.Stat(cache.path(layoutKind(r.wrapWidth))); err != nil {
+		t.Fatalf("case saved: %v", err)
+	}
+	wrapSections(expect)
+
+	// the layout is read from the cache if the lines are the same
+	cache.saveLayout(r.wrapWidth, cachedLayout{[]VisualLineIndex{0}, []int{1}})
+	got := newResizeTestBuffer()
+	r = NewRender(nil, got)
+	r.SetCache(cache)
+	r.Render(40)
+	cacheWrites.Wait()
+	if got.sections[0].wrapped() {
+		t.Error("case mismatch: got the layout of another book")
+	}
+	r.Render(40)
+	if !reflect.DeepEqual(got.sections, expect.sections) {
+		t.Errorf("case loaded: got %+v, expect %+v", got.sections, expect.sections)
+	}
+}
+
+func TestBookCachePrune(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, size int, age time.Duration) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Now().Add(-age)
+		os.Chtimes(path, mtime, mtime)
+		return path
+	}
+	oldVersion := write("book.v0-1.buffer", 10, time.Minute)
+	oldest := write("other.v0-1.buffer", 4000, 2*time.Hour)
+	older := write("other."+cacheVersion()+".buffer", 4000, time.Hour)
+	broken := write("book."+cacheVersion()+".w40.layout", 10, 0)
+
+	cache := NewBookCache(dir, "book", 10000)
+	if _, ok := cache.loadLayout(40); ok {
+		t.Error("case broken: got a layout, expect none")
+	}
+	if err := cache.SaveBuffer(newResizeTestBuffer()); err != nil {
+		t.Fatal(err)
+	}
+	testcases := []struct {
+		name   string
+		path   string
+		exists bool
+	}{
+		{"other version", oldVersion, false},
+		{"broken", broken, false},
+		{"least recently used", oldest, false},
+		{"recently used", older, true},
+		{"saved", cache.path("buffer"), true},
+	}
+	for _, c := range testcases {
+		if _, err := os.Stat(c.path); (err == nil) != c.exists {
+			t.Errorf("case %s: got exists %v, expect %v", c.name, err == nil, c.exists)
+		}
+	}
+}
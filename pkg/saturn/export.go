@@ -0,0 +1,177 @@
+package saturn
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/elinx/saturn/pkg/db"
+	"github.com/elinx/saturn/pkg/epub"
+)
+
+type ExportFormat string
+
+const (
+	ExportMarkdown ExportFormat = "markdown"
+	ExportJSON     ExportFormat = "json"
+	ExportCSV      ExportFormat = "csv"
+)
+
+// ExportFormats are the supported formats, the first one is the default
+var ExportFormats = []ExportFormat{ExportMarkdown, ExportJSON, ExportCSV}
+
+// Ext returns the file extension of the format
+func (f ExportFormat) Ext() string {
+	switch f {
+	case ExportJSON:
+		return ".json"
+	case ExportCSV:
+		return ".csv"
+	default:
+		return ".md"
+	}
+}
+
+// ParseExportFormat accepts the format names and their common aliases
+func ParseExportFormat(name string) (ExportFormat, error) {
+	switch strings.ToLower(name) {
+	case "markdown", "md":
+		return ExportMarkdown, nil
+	case "json":
+		return ExportJSON, nil
+	case "csv":
+		return ExportCSV, nil
+	}
+	return "", fmt.Errorf("unknown export format: %s", name)
+}
+
+// ChapterNotes are the annotations of one toc entry in reading order
+type ChapterNotes struct {
+	Chapter     string          `json:"chapter"`
+	Annotations []db.Annotation `json:"annotations"`
+}
+
+// GroupAnnotations sorts the annotations in reading order and groups them by
+// the toc entry they are in. The ones which can't be found in the buffer are
+// grouped at the end with an empty chapter.
+func GroupAnnotations(toc *epub.TableOfContent, buffer *Buffer, annos []db.Annotation) []ChapterNotes {
+	type located struct {
+		anno  db.Annotation
+		linum BufferLineIndex
+		x     RuneIndex
+		ok    bool
+	}
+	list := []located{}
+	for _, anno := range annos {
+		linum, x, ok := BufferPosOf(buffer, anno.Start)
+		list = append(list, located{anno, linum, x, ok})
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.ok != b.ok {
+			return a.ok
+		}
+		if a.linum != b.linum {
+			return a.linum < b.linum
+		}
+		return a.x < b.x
+	})
+	sections := NewSectionIndex(toc, buffer)
+	chapters := []ChapterNotes{}
+	var last *epub.TocNode
+	for i, l := range list {
+		var node *epub.TocNode
+		if l.ok {
+			node = sections.Find(l.linum)
+		}
+		if i == 0 || node != last || !l.ok && list[i-1].ok {
+			title := ""
+			if node != nil {
+				title = node.Title
+			}
+			chapters = append(chapters, ChapterNotes{Chapter: title})
+			last = node
+		}
+		current := &chapters[len(chapters)-1]
+		current.Annotations = append(current.Annotations, l.anno)
+	}
+	return chapters
+}
+
+// ExportAnnotations writes the grouped annotations of the book in the format
+func ExportAnnotations(w io.Writer, title string, chapters []ChapterNotes, format ExportFormat) error {
+	switch format {
+	case ExportMarkdown:
+		return exportMarkdown(w, title, chapters)
+	case ExportJSON:
+		return exportJSON(w, title, chapters)
+	case ExportCSV:
+		return exportCSV(w, chapters)
+	}
+	return fmt.Errorf("unknown export format: %s", format)
+}
+
+func exportMarkdown(w io.Writer, title string, chapters []ChapterNotes) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n", title)
+	for _, chapter := range chapters {
+		name := chapter.Chapter
+		if name == "" {
+			name = "Other notes"
+		}
+		fmt.Fprintf(&b, "\n## %s\n", name)
+		for _, anno := range chapter.Annotations {
+			b.WriteString("\n")
+			for _, line := range strings.Split(anno.Text, "\n") {
+				fmt.Fprintf(&b, "> %s\n", line)
+			}
+			if anno.Comment != "" {
+				fmt.Fprintf(&b, "\n%s\n", anno.Comment)
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func exportJSON(w io.Writer, title string, chapters []ChapterNotes) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(struct {
+		Title    string         `json:"title"`
+		Chapters []ChapterNotes `json:"chapters"`
+	}{title, chapters})
+}
+
+var csvHeader = []string{
+	"chapter", "id", "type", "color", "text", "comment", "author", "date",
+	"start_manifest_id", "start_line", "start_rune",
+	"end_manifest_id", "end_line", "end_rune", "prefix", "suffix",
+}
+
+func exportCSV(w io.Writer, chapters []ChapterNotes) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, chapter := range chapters {
+		for _, anno := range chapter.Annotations {
+			err := writer.Write([]string{
+				chapter.Chapter, strconv.FormatInt(anno.ID, 10), string(anno.Type),
+				anno.Color, anno.Text, anno.Comment, anno.Author, anno.Date,
+				anno.Start.ManifestId, strconv.Itoa(anno.Start.Line), strconv.Itoa(anno.Start.Rune),
+				anno.End.ManifestId, strconv.Itoa(anno.End.Line), strconv.Itoa(anno.End.Rune),
+				anno.Prefix, anno.Suffix,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,87 @@
+package saturn
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"testing"
+
+	"github.com/elinx/saturn/pkg/db"
+	"github.com/elinx/saturn/pkg/epub"
+)
+
+func newExportTestNotes() []ChapterNotes {
+	buffer := newAnchorTestBuffer()
+	toc := &epub.TableOfContent{Nodes: []*epub.TocNode{
+		{ID: "0", Title: "Title", ManifestId: "c0"},
+		{ID: "1", Title: "Chapter 1", ManifestId: "c1"},
+	}}
+	annos := []db.Annotation{
+		NewAnchoredAnnotation(db.AnnotationHighlight, buffer, 2, 4, 2, 8, db.WithComment("a note")),
+		NewAnchoredAnnotation(db.AnnotationHighlight, buffer, 1, 29, 2, 3),
+		db.NewAnnotation(db.AnnotationHighlight, "lost"),
+		NewAnchoredAnnotation(db.AnnotationUnderscore, buffer, 0, 0, 0, 5),
+	}
+	return GroupAnnotations(toc, buffer, annos)
+}
+
+func TestGroupAnnotations(t *testing.T) {
+	chapters := newExportTestNotes()
+	got := [][]string{}
+	for _, chapter := range chapters {
+		texts := []string{chapter.Chapter}
+		for _, anno := range chapter.Annotations {
+			texts = append(texts, anno.Text)
+		}
+		got = append(got, texts)
+	}
+	expect := [][]string{
+		{"Title", "Title"},
+		{"Chapter 1", "real way.\nThe", "name"},
+		{"", "lost"},
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("got %q, expect %q", got, expect)
+	}
+}
+
+func TestExportAnnotations(t *testing.T) {
+	chapters := newExportTestNotes()
+	var b bytes.Buffer
+	if err := ExportAnnotations(&b, "Tao", chapters, ExportMarkdown); err != nil {
+		t.Fatal(err)
+	}
+	expect := "# Tao\n\n## Title\n\n> Title\n\n## Chapter 1\n\n> real way.\n> The\n\n> name\n\na note\n\n## Other notes\n\n> lost\n"
+	if b.String() != expect {
+		t.Errorf("got %q, expect %q", b.String(), expect)
+	}
+
+	b.Reset()
+	if err := ExportAnnotations(&b, "Tao", chapters, ExportCSV); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&b).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 5 || !reflect.DeepEqual(records[0], csvHeader) {
+		t.Fatalf("unexpected records: %q", records)
+	}
+	if records[3][0] != "Chapter 1" || records[3][5] != "a note" || records[3][8] != "c1" {
+		t.Errorf("unexpected record: %q", records[3])
+	}
+}
+
+func TestParseExportFormat(t *testing.T) {
+	for name, expect := range map[string]ExportFormat{"md": ExportMarkdown, "JSON": ExportJSON, "csv": ExportCSV} {
+		if format, err := ParseExportFormat(name); err != nil || format != expect {
+			t.Errorf("%s: got %v, %v, expect %v", name, format, err, expect)
+		}
+	}
+	if _, err := ParseExportFormat("pdf"); err == nil {
+		t.Error("expect error for unknown format")
+	}
+	if name := notesFileName(" a/b: c ", ExportCSV); name != "a_b_ c-notes.csv" {
+		t.Errorf("unexpected file name %q", name)
+	}
+}
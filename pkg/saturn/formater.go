@@ -4,6 +4,7 @@ import (
 	"strings"
 
 	"github.com/charmbracelet/lipgloss"
+	"github.com/elinx/saturn/pkg/config"
 	cssparser "github.com/elinx/saturn/pkg/css_parser"
 	"github.com/muesli/termenv"
 	log "github.com/sirupsen/logrus"
@@ -28,9 +29,7 @@ type HtmlFormater struct {
 }
 
 func (f HtmlFormater) Title(c string) string {
-	return termenv.String(c).Bold().
-		Foreground(f.ColorProfile.Color("#ffffff")).
-		Background(f.ColorProfile.Color("#0000ff")).String()
+	return f.render(c, theme.Styles["title"])
 }
 
 func (f HtmlFormater) I(c string) string {
@@ -67,9 +66,32 @@ func (f HtmlFormater) P(c string, attributes []html.Attribute) string {
 }
 
 func (f HtmlFormater) Header(c string) string {
-	return termenv.String(c).Bold().
-		Foreground(f.ColorProfile.Color("#ffffff")).
-		Background(f.ColorProfile.Color("#0000ff")).String()
+	return f.render(c, theme.Styles["h1"])
+}
+
+// render styles the text with the style of the theme for the color profile
+// of the formater
+func (f HtmlFormater) render(c string, s config.Style) string {
+	style := termenv.String(c)
+	if color := resolveColor(s.Foreground, f.ColorProfile); color != "" {
+		style = style.Foreground(f.ColorProfile.Color(color))
+	}
+	if color := resolveColor(s.Background, f.ColorProfile); color != "" {
+		style = style.Background(f.ColorProfile.Color(color))
+	}
+	if s.Bold != nil && *s.Bold {
+		style = style.Bold()
+	}
+	if s.Italic != nil && *s.Italic {
+		style = style.Italic()
+	}
+	if s.Underline != nil && *s.Underline {
+		style = style.Underline()
+	}
+	if s.Reverse != nil && *s.Reverse {
+		style = style.Reverse()
+	}
+	return style.String()
 }
 
 func (f HtmlFormater) PostProcess(c string) string {
@@ -0,0 +1,121 @@
+package saturn
+
+import (
+	"strings"
+
+	"github.com/elinx/saturn/pkg/clippings"
+	"github.com/elinx/saturn/pkg/db"
+)
+
+// importColors maps the highlight colors of the other readers to terminal
+// colors, the unknown ones use the default of the type
+var importColors = map[string]string{
+	"red":    "1",
+	"green":  "2",
+	"yellow": "3",
+	"blue":   "4",
+	"purple": "5",
+	"cyan":   "6",
+	"gray":   "8",
+	"orange": "208",
+}
+
+// ImportClippings anchors the clippings on the buffer and stores them as
+// annotations. The clippings already imported are ignored and the ones whose
+// text can't be found in the book are returned.
+func ImportClippings(store *db.DB, buffer *Buffer, clips []clippings.Clipping) (int, []clippings.Clipping, error) {
+	existing, err := store.Annotations()
+	if err != nil {
+		return 0, nil, err
+	}
+	seen := make(map[db.Position]map[string]bool)
+	for _, anno := range existing {
+		if seen[anno.Start] == nil {
+			seen[anno.Start] = make(map[string]bool)
+		}
+		seen[anno.Start][anno.Text] = true
+	}
+	annos := []db.Annotation{}
+	missing := []clippings.Clipping{}
+	for _, clip := range clips {
+		if clip.Kind != clippings.KindHighlight {
+			missing = append(missing, clip)
+			continue
+		}
+		startLinum, startX, endLinum, endX, found := FindQuoteRange(buffer, clip.Text)
+		if !found {
+			missing = append(missing, clip)
+			continue
+		}
+		anno := NewAnchoredAnnotation(importType(clip), buffer, startLinum, startX, endLinum, endX,
+			db.WithComment(clip.Note), db.WithColor(importColors[strings.ToLower(clip.Color)]), db.WithDate(clip.Date))
+		if seen[anno.Start][anno.Text] {
+			continue
+		}
+		if seen[anno.Start] == nil {
+			seen[anno.Start] = make(map[string]bool)
+		}
+		seen[anno.Start][anno.Text] = true
+		annos = append(annos, anno)
+	}
+	if len(annos) > 0 {
+		if err := store.Insert(annos...); err != nil {
+			return 0, nil, err
+		}
+	}
+	return len(annos), missing, nil
+}
+
+func importType(clip clippings.Clipping) db.AnnotationType {
+	switch strings.ToLower(clip.Style) {
+	case "underscore", "strikeout":
+		return db.AnnotationUnderscore
+	}
+	return db.AnnotationHighlight
+}
+
+// FindQuoteRange looks for the quote in the buffer, a quote of many
+// paragraphs has to match the consecutive lines of the buffer
+func FindQuoteRange(buffer *Buffer, quote string) (BufferLineIndex, RuneIndex, BufferLineIndex, RuneIndex, bool) {
+	parts := []string{}
+	for _, part := range strings.Split(quote, "\n") {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 {
+		return 0, 0, 0, 0, false
+	}
+	if len(parts) == 1 {
+		match, found := FindQuote(buffer, parts[0], "", "")
+		return match.BufferLinum, match.Start, match.BufferLinum, match.End, found
+	}
+	matches, err := buffer.Search(parts[0], SearchOptions{})
+	if err != nil {
+		return 0, 0, 0, 0, false
+	}
+	for _, match := range matches {
+		first := []rune(buffer.Lines[match.BufferLinum].Content)
+		if strings.TrimSpace(string(first[match.End:])) != "" {
+			continue
+		}
+		endLinum := match.BufferLinum + BufferLineIndex(len(parts)-1)
+		if int(endLinum) >= len(buffer.Lines) {
+			continue
+		}
+		matched := true
+		for i, part := range parts[1 : len(parts)-1] {
+			if strings.TrimSpace(buffer.Lines[match.BufferLinum+BufferLineIndex(i+1)].Content) != part {
+				matched = false
+				break
+			}
+		}
+		last := strings.TrimLeft(buffer.Lines[endLinum].Content, " \t")
+		if !matched || !strings.HasPrefix(last, parts[len(parts)-1]) {
+			continue
+		}
+		indent := len([]rune(buffer.Lines[endLinum].Content)) - len([]rune(last))
+		return match.BufferLinum, match.Start, endLinum, RuneIndex(indent + len([]rune(parts[len(parts)-1]))), true
+	}
+	return 0, 0, 0, 0, false
+}
@@ -0,0 +1,74 @@
+package saturn
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/elinx/saturn/pkg/clippings"
+	"github.com/elinx/saturn/pkg/db"
+)
+
+func TestFindQuoteRange(t *testing.T) {
+	buffer := newAnchorTestBuffer()
+	testcases := []struct {
+		quote  string
+		expect [4]int
+		found  bool
+	}{
+		{"the real way", [4]int{1, 25, 1, 37}, true},
+		{"the real way.\nThe name", [4]int{1, 25, 2, 8}, true},
+		{"real way.\n\nThe name you can say isn't the real name.\nHeaven", [4]int{1, 29, 3, 6}, true},
+		{"the real\nThe name", [4]int{}, false},
+		{"not in the book", [4]int{}, false},
+	}
+	for _, tc := range testcases {
+		sl, sx, el, ex, found := FindQuoteRange(buffer, tc.quote)
+		got := [4]int{int(sl), int(sx), int(el), int(ex)}
+		if found != tc.found || (found && got != tc.expect) {
+			t.Errorf("quote %q: got %v, %v, expect %v, %v", tc.quote, got, found, tc.expect, tc.found)
+		}
+	}
+}
+
+func TestImportClippings(t *testing.T) {
+	store, err := db.NewDb(filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.Run(db.Book{Title: "book"}); err != nil {
+		t.Fatal(err)
+	}
+	buffer := newAnchorTestBuffer()
+	clips := []clippings.Clipping{
+		{Kind: clippings.KindHighlight, Text: "the real way", Note: "a note", Color: "Green"},
+		{Kind: clippings.KindHighlight, Text: "real name", Style: "underscore"},
+		{Kind: clippings.KindHighlight, Text: "not in the book"},
+		{Kind: clippings.KindNote, Text: "a lonely note"},
+	}
+	imported, missing, err := ImportClippings(store, buffer, clips)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported != 2 || len(missing) != 2 {
+		t.Errorf("got %d imported, %d missing, expect 2, 2", imported, len(missing))
+	}
+	// the clippings imported already are ignored
+	if imported, _, err := ImportClippings(store, buffer, clips); err != nil || imported != 0 {
+		t.Errorf("got %d imported, %v, expect none", imported, err)
+	}
+	annos, err := store.Annotations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(annos) != 2 {
+		t.Fatalf("got %d annotations, expect 2", len(annos))
+	}
+	first := annos[0]
+	if first.Start != (db.Position{ManifestId: "c1", Line: 0, Rune: 25}) || first.Comment != "a note" || first.Color != "2" {
+		t.Errorf("unexpected annotation %+v", first)
+	}
+	if annos[1].Type != db.AnnotationUnderscore {
+		t.Errorf("got type %s, expect %s", annos[1].Type, db.AnnotationUnderscore)
+	}
+}
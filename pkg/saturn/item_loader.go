@@ -0,0 +1,320 @@
+package saturn
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/elinx/saturn/pkg/epub"
+	"github.com/elinx/saturn/pkg/util"
+	log "github.com/sirupsen/logrus"
+)
+
+// parses are the spine items parsed in the background for what reads the
+// whole book, unlike prefetches the theme doesn't wait for them
+var parses sync.WaitGroup
+
+// itemLoader parses the spine items of a buffer laid out by ParseLazy, the
+// items next to the ones read are parsed in the background
+type itemLoader struct {
+	parser *Parser
+	// ready gets a value when an item is parsed in the background
+	ready chan struct{}
+
+	mu sync.Mutex
+	// parsing are the items being parsed in the background, the channel is
+	// closed when the item is put into parsed
+	parsing map[epub.ManifestId]chan struct{}
+	parsed  map[epub.ManifestId]parsedItem
+	// taken are the items put into the buffer, they aren't parsed again
+	taken      map[epub.ManifestId]bool
+	parsingAll bool
+}
+
+func newItemLoader(p *Parser) *itemLoader {
+	return &itemLoader{
+		parser:  p,
+		ready:   make(chan struct{}, 1),
+		parsing: make(map[epub.ManifestId]chan struct{}),
+		parsed:  make(map[epub.ManifestId]parsedItem),
+		taken:   make(map[epub.ManifestId]bool),
+	}
+}
+
+// prefetch parses the spine item in the background if it isn't parsed
+func (l *itemLoader) prefetch(id epub.ManifestId) {
+	if done, ok := l.start(id); ok {
+		prefetches.Add(1)
+		go func() {
+			defer prefetches.Done()
+			l.finish(id, done)
+		}()
+	}
+}
+
+// parseAll parses the spine items in the background in the order of ids,
+// ready is told about each of them. It's done only once.
+func (l *itemLoader) parseAll(ids []epub.ManifestId) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.parsingAll {
+		return
+	}
+	l.parsingAll = true
+	workers := l.parser.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	jobs := make(chan epub.ManifestId)
+	parses.Add(1)
+	go func() {
+		defer parses.Done()
+		defer close(jobs)
+		for _, id := range ids {
+			jobs <- id
+		}
+	}()
+	for w := 0; w < workers; w++ {
+		parses.Add(1)
+		go func() {
+			defer parses.Done()
+			for id := range jobs {
+				if done, ok := l.start(id); ok {
+					l.finish(id, done)
+				}
+			}
+		}()
+	}
+}
+
+// start marks the item being parsed, false if it's parsed or taken already
+func (l *itemLoader) start(id epub.ManifestId) (chan struct{}, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.parsing[id]; ok {
+		return nil, false
+	}
+	if _, ok := l.parsed[id]; ok || l.taken[id] {
+		return nil, false
+	}
+	done := make(chan struct{})
+	l.parsing[id] = done
+	return done, true
+}
+
+// finish parses the item started and tells ready about it
+func (l *itemLoader) finish(id epub.ManifestId, done chan struct{}) {
+	buffer, err := l.parser.parseItem(id)
+	l.mu.Lock()
+	l.parsed[id] = parsedItem{id, buffer, err}
+	delete(l.parsing, id)
+	l.mu.Unlock()
+	close(done)
+	select {
+	case l.ready <- struct{}{}:
+	default:
+	}
+}
+
+// parsedIds returns the items parsed in the background and not taken yet
+func (l *itemLoader) parsedIds() []epub.ManifestId {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ids := []epub.ManifestId{}
+	for id := range l.parsed {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// parse returns the parsed spine items, the ones being prefetched are waited
+// for and the others are parsed on Workers goroutines
+func (l *itemLoader) parse(ids []epub.ManifestId) map[epub.ManifestId]parsedItem {
+	items := make(map[epub.ManifestId]parsedItem)
+	missing := []epub.ManifestId{}
+	for _, id := range ids {
+		l.mu.Lock()
+		done, ok := l.parsing[id]
+		l.mu.Unlock()
+		if ok {
+			<-done
+		}
+		l.mu.Lock()
+		item, ok := l.parsed[id]
+		delete(l.parsed, id)
+		l.taken[id] = true
+		l.mu.Unlock()
+		if ok {
+			items[id] = item
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	l.parser.parseItems(missing, func(item parsedItem) bool {
+		items[item.id] = item
+		return true
+	})
+	return items
+}
+
+// lines returns the lines of the parsed item, a line telling the error if it
+// failed
+func (item parsedItem) lines() []Line {
+	if item.err != nil {
+		log.Error(item.err)
+		return []Line{{Content: item.err.Error(), Style: "p"}}
+	}
+	return item.buffer.Lines
+}
+
+// loadAll parses every spine item not parsed yet, it's needed by what reads
+// the whole book
+func (b *Buffer) loadAll() {
+	ids := []epub.ManifestId{}
+	for id := range b.pending {
+		ids = append(ids, id)
+	}
+	b.load(ids...)
+}
+
+// parseAll parses the pending spine items in the background from the first
+// one, loadParsed puts them into the buffer. It's false if none is pending.
+func (b *Buffer) parseAll() bool {
+	if len(b.pending) == 0 {
+		return false
+	}
+	ids := []epub.ManifestId{}
+	for id := range b.pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return b.BlockPos[ids[i]] < b.BlockPos[ids[j]] })
+	b.loader.parseAll(ids)
+	return true
+}
+
+// loadParsed puts the spine items parsed in the background into the buffer,
+// it doesn't wait for the ones still being parsed
+func (b *Buffer) loadParsed() {
+	if b.loader != nil {
+		b.load(b.loader.parsedIds()...)
+	}
+}
+
+// load parses the pending spine items and puts their lines in place of the
+// blank ones. The lines and the anchors after them are moved, the sections
+// of the items are estimated again and onReflow is told about each item in
+// the buffer order.
+func (b *Buffer) load(ids ...epub.ManifestId) {
+	todo := []epub.ManifestId{}
+	for _, id := range ids {
+		if b.pending[id] {
+			todo = append(todo, id)
+			delete(b.pending, id)
+		}
+	}
+	if len(todo) == 0 {
+		return
+	}
+	sort.Slice(todo, func(i, j int) bool { return b.BlockPos[todo[i]] < b.BlockPos[todo[j]] })
+	items := b.loader.parse(todo)
+
+	// shifts are how far the lines from the end of each item move
+	type shift struct{ end, delta BufferLineIndex }
+	shifts := []shift{}
+	spliced := make(map[epub.ManifestId]reflow)
+	lines := make([]Line, 0, len(b.Lines))
+	prev := BufferLineIndex(0)
+	for _, id := range todo {
+		start, end := b.BlockPos[id], b.blockEnd(b.BlockPos[id])
+		lines = append(lines, b.Lines[prev:start]...)
+		r := reflow{linum: BufferLineIndex(len(lines)), oldLines: int(end - start)}
+		lines = append(lines, items[id].lines()...)
+		r.newLines = len(lines) - int(r.linum)
+		spliced[id] = r
+		shifts = append(shifts, shift{end, BufferLineIndex(len(lines)) - end})
+		prev = end
+	}
+	lines = append(lines, b.Lines[prev:]...)
+	move := func(linum BufferLineIndex) BufferLineIndex {
+		i := sort.Search(len(shifts), func(i int) bool { return shifts[i].end > linum })
+		if i == 0 {
+			return linum
+		}
+		return linum + shifts[i-1].delta
+	}
+
+	for id, linum := range b.BlockPos {
+		if r, ok := spliced[id]; ok {
+			b.BlockPos[id] = r.linum
+		} else {
+			b.BlockPos[id] = move(linum)
+		}
+	}
+	for _, anchors := range b.Anchors {
+		for anchor, linum := range anchors {
+			anchors[anchor] = move(linum)
+		}
+	}
+	highlights := make(map[Match]bool, len(b.highlights))
+	for match, current := range b.highlights {
+		match.BufferLinum = move(match.BufferLinum)
+		highlights[match] = current
+	}
+	b.highlights = highlights
+	for id, r := range spliced {
+		if item := items[id]; item.err == nil {
+			for anchor, linum := range item.buffer.Anchors[id] {
+				b.AddAnchor(id, anchor, r.linum+linum)
+			}
+		}
+	}
+	b.Lines = lines
+	b.loads++
+
+	reflows := []reflow{}
+	if b.sections != nil {
+		sections := make([]sectionLayout, 0, len(b.sections))
+		vy := VisualLineIndex(0)
+		for _, s := range b.sections {
+			r, ok := spliced[s.pending]
+			if s.pending == "" || !ok {
+				s.start, s.vstart = move(s.start), vy
+				sections = append(sections, s)
+				vy += VisualLineIndex(s.lines)
+				continue
+			}
+			r.vy, r.oldVisual = vy, s.lines
+			end := r.linum + BufferLineIndex(r.newLines)
+			for linum := r.linum; linum < end; linum += maxSectionLines {
+				n := estimateVisualLines(lines[linum:util.MinInt(int(linum)+maxSectionLines, int(end))], b.renderer.wrapWidth, false)
+				sections = append(sections, sectionLayout{start: linum, vstart: vy, lines: n})
+				vy += VisualLineIndex(n)
+			}
+			r.newVisual = int(vy - r.vy)
+			reflows = append(reflows, r)
+		}
+		if len(sections) == 0 {
+			sections = append(sections, sectionLayout{})
+		}
+		b.sections = sections
+		b.rendered = newSectionCache()
+	}
+	if b.renderer != nil {
+		b.renderer.loaded()
+	}
+	for _, r := range reflows {
+		b.reflowed(r)
+	}
+}
+
+// blockEnd returns the line after the spine item starting at the line, the
+// empty items at the same line don't end it
+func (b *Buffer) blockEnd(start BufferLineIndex) BufferLineIndex {
+	end := BufferLineIndex(len(b.Lines))
+	for _, linum := range b.BlockPos {
+		if linum > start && linum < end {
+			end = linum
+		}
+	}
+	return end
+}
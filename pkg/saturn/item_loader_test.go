@@ -0,0 +1,153 @@
+package saturn
+
+import (
+	"testing"
+
+	"github.com/elinx/saturn/pkg/db"
+)
+
+// checkSections fails if the sections don't follow each other or a wrapped
+// one doesn't match its lines
+func checkSections(t *testing.T, name string, b *Buffer) {
+	t.Helper()
+	vy := VisualLineIndex(0)
+	for i, s := range b.sections {
+		if s.vstart != vy {
+			t.Fatalf("case %s: got section %d at %d, expect %d", name, i, s.vstart, vy)
+		}
+		if s.wrapped() && (len(s.lens) != s.lines || len(s.offsets) != b.sectionLinesNum(i)) {
+			t.Fatalf("case %s: got section %d of %d lines, expect %d", name, i, s.lines, len(s.lens))
+		}
+		vy += VisualLineIndex(s.lines)
+	}
+	if got := b.VisualLinesNum(); got != int(vy) {
+		t.Fatalf("case %s: got %d lines, expect %d", name, got, vy)
+	}
+}
+
+func TestLazyLayout(t *testing.T) {
+	filename := writeTestEpub(t, 12, 40)
+	buffer := openLazyTestBook(t, filename)
+	m := NewTextModel(nil, nil, NewRender(nil, buffer), "", nil, 60, 20).(*textModel)
+	m.Init()
+	for i, s := range buffer.sections {
+		if s.wrapped() || s.pending == "" {
+			t.Fatalf("case open: got section %d wrapped or parsed, expect it estimated", i)
+		}
+	}
+	checkSections(t, "open", buffer)
+
+	// the middle of a chapter is shown, only the sections around it are parsed
+	m.viewport.SetYOffset(int(buffer.sections[6].vstart) + 30)
+	m.View()
+	checkSections(t, "middle", buffer)
+	linum, _ := buffer.GetBufferPos(VisualLineIndex(m.viewport.YOffset), 0)
+	if id, _ := buffer.GetBlockByLine(linum); id != "ch6" {
+		t.Errorf("case middle: got %s at the top, expect ch6", id)
+	}
+	if !buffer.pending["ch0"] || !buffer.pending["ch11"] {
+		t.Errorf("case middle: got the far chapters parsed, expect them pending")
+	}
+	for vy := m.viewport.YOffset; vy < m.viewport.YOffset+m.viewport.Height; vy++ {
+		if s := buffer.sections[buffer.sectionOfVisual(VisualLineIndex(vy))]; !s.wrapped() {
+			t.Fatalf("case middle: got line %d estimated, expect it wrapped", vy)
+		}
+	}
+
+	// the jump is exact, the position jumped from stays in its chapter
+	m.jumpTo(buffer.GetVisualLineNumById("ch9"))
+	m.View()
+	checkSections(t, "jump", buffer)
+	if linum, _ := buffer.GetBufferPos(VisualLineIndex(m.viewport.YOffset), 0); buffer.Lines[linum].Content != "Chapter 9" {
+		t.Errorf("case jump: got %q at the top, expect %q", buffer.Lines[linum].Content, "Chapter 9")
+	}
+	back, _ := m.jumps.Back(m.viewport.YOffset)
+	linum, _ = buffer.GetBufferPos(VisualLineIndex(back), 0)
+	if id, _ := buffer.GetBlockByLine(linum); id != "ch6" {
+		t.Errorf("case jump: got the jump back in %s, expect ch6", id)
+	}
+
+	// every section wrapped is the same as wrapping the parsed book
+	wrapSections(buffer)
+	checkSections(t, "all", buffer)
+	expect := parseTestEpub(t, filename, 0)
+	NewRender(nil, expect).Render(60)
+	wrapSections(expect)
+	if got, expect := buffer.VisualLinesNum(), expect.VisualLinesNum(); got != expect {
+		t.Errorf("case all: got %d lines, expect %d", got, expect)
+	}
+	for linum := range expect.Lines {
+		if got, expect := buffer.GetVisualPos(BufferLineIndex(linum), 0), expect.GetVisualPos(BufferLineIndex(linum), 0); got != expect {
+			t.Fatalf("case all: got line %d at %d, expect %d", linum, got, expect)
+		}
+	}
+}
+
+func TestLazySearch(t *testing.T) {
+	filename := writeTestEpub(t, 5, 10)
+	buffer := openLazyTestBook(t, filename)
+	m := NewTextModel(nil, nil, NewRender(nil, buffer), "", nil, 60, 20).(*textModel)
+	m.Init()
+	m.View()
+	expect, _ := parseTestEpub(t, filename, 0).Search("real name", SearchOptions{})
+
+	// only the spine items parsed are searched, the rest is parsed in the
+	// background
+	m.startSearch(false)
+	m.searchInput.SetValue("real name")
+	m.search()
+	if len(m.matches) == 0 || len(m.matches) >= len(expect) {
+		t.Fatalf("case parsed: got %d matches, expect some of %d", len(m.matches), len(expect))
+	}
+	for _, match := range m.matches {
+		if id, _ := buffer.GetBlockByLine(match.BufferLinum); buffer.pending[id] {
+			t.Fatalf("case parsed: got a match in %s, expect it parsed", id)
+		}
+	}
+	m.nextMatch(true)
+	current := PositionOf(buffer, m.matches[m.matchIndex].BufferLinum, m.matches[m.matchIndex].Start)
+
+	// the items parsed in the background are searched, the match selected
+	// stays selected
+	parses.Wait()
+	m.Update(parsedMessage{})
+	if len(buffer.pending) != 0 || len(m.matches) != len(expect) {
+		t.Errorf("case all: got %d matches with %d items pending, expect %d matches", len(m.matches), len(buffer.pending), len(expect))
+	}
+	match := m.matches[m.matchIndex]
+	if got := PositionOf(buffer, match.BufferLinum, match.Start); got != current {
+		t.Errorf("case all: got the match at %v selected, expect %v", got, current)
+	}
+	if current, ok := buffer.highlights[match]; !ok || !current {
+		t.Errorf("case all: got the match selected not highlighted, expect it highlighted")
+	}
+	checkSections(t, "all", buffer)
+}
+
+func TestLazyAnnotation(t *testing.T) {
+	filename := writeTestEpub(t, 6, 10)
+	buffer := openLazyTestBook(t, filename)
+	renderer := NewRender(nil, buffer)
+	// the text at the range moved to another chapter
+	anno := db.NewAnnotation(db.AnnotationHighlight, "Chapter 4",
+		db.WithRange(db.Position{ManifestId: "ch0"}, db.Position{ManifestId: "ch0", Rune: 9}))
+	renderer.SetAnnotations([]db.Annotation{anno})
+	renderer.Render(60)
+	reflows := 0
+	buffer.onReflow = func(reflow) { reflows++ }
+
+	// the quote isn't looked for in the items not parsed
+	buffer.load("ch0")
+	if len(buffer.pending) != 5 || reflows != 1 {
+		t.Errorf("case ch0: got %d items pending and %d reflows, expect 5 and 1", len(buffer.pending), reflows)
+	}
+	if spans, found := renderer.annotations.spansOf(anno); len(spans) != 0 || !found {
+		t.Errorf("case ch0: got %d spans found %v, expect none until ch4 is parsed", len(spans), found)
+	}
+
+	buffer.load("ch4")
+	spans, _ := renderer.annotations.spansOf(anno)
+	if len(spans) != 1 || spans[0].linum != buffer.BlockPos["ch4"] {
+		t.Errorf("case ch4: got spans %v, expect the first line of ch4", spans)
+	}
+}
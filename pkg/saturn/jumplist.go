@@ -0,0 +1,51 @@
+package saturn
+
+// jumpList remembers the positions before jumps like the jumplist of vim, the
+// positions are the YOffset of the viewport.
+type jumpList struct {
+	entries []int
+
+	// current is the index of the entry we are at, it equals to the length
+	// of entries if we are not in the list
+	current int
+}
+
+// Push records the position before a jump, positions after the current one
+// are discarded
+func (j *jumpList) Push(offset int) {
+	j.entries = append(j.entries[:j.current], offset)
+	j.current = len(j.entries)
+}
+
+// Back returns the previous position, offset is the position we are leaving
+// which can be restored by Forward
+func (j *jumpList) Back(offset int) (int, bool) {
+	if j.current == 0 {
+		return 0, false
+	}
+	if j.current == len(j.entries) {
+		j.entries = append(j.entries, offset)
+	} else {
+		j.entries[j.current] = offset
+	}
+	j.current--
+	return j.entries[j.current], true
+}
+
+// Forward returns the next position if we went back before
+func (j *jumpList) Forward(offset int) (int, bool) {
+	if j.current+1 >= len(j.entries) {
+		return 0, false
+	}
+	j.entries[j.current] = offset
+	j.current++
+	return j.entries[j.current], true
+}
+
+// Remap replaces every position with f of it, it keeps the positions when
+// the text is wrapped again
+func (j *jumpList) Remap(f func(offset int) int) {
+	for i, offset := range j.entries {
+		j.entries[i] = f(offset)
+	}
+}
@@ -0,0 +1,49 @@
+package saturn
+
+import "testing"
+
+func TestJumpList(t *testing.T) {
+	j := jumpList{}
+	if _, ok := j.Back(0); ok {
+		t.Error("back on empty jumplist should fail")
+	}
+	// jump from 10 to 100, then from 120 to 300
+	j.Push(10)
+	j.Push(120)
+
+	steps := []struct {
+		back   bool
+		offset int
+		expect int
+		ok     bool
+	}{
+		{true, 300, 120, true},
+		{true, 120, 10, true},
+		{true, 10, 0, false},
+		{false, 10, 120, true},
+		{false, 120, 300, true},
+		{false, 300, 0, false},
+	}
+	for i, step := range steps {
+		var got int
+		var ok bool
+		if step.back {
+			got, ok = j.Back(step.offset)
+		} else {
+			got, ok = j.Forward(step.offset)
+		}
+		if ok != step.ok || (ok && got != step.expect) {
+			t.Errorf("step %d: got (%d, %v), expect (%d, %v)", i, got, ok, step.expect, step.ok)
+		}
+	}
+
+	// a new jump discards the positions after the current one
+	j.Back(300)
+	j.Push(130)
+	if _, ok := j.Forward(0); ok {
+		t.Error("forward after a new jump should fail")
+	}
+	if got, _ := j.Back(500); got != 130 {
+		t.Errorf("got %d, expect 130", got)
+	}
+}
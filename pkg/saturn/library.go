@@ -0,0 +1,118 @@
+package saturn
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/elinx/saturn/pkg/db"
+	"github.com/elinx/saturn/pkg/epub"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// NewLibraryBook returns the book of the library for the opened epub, hash is
+// the hash of its file
+func NewLibraryBook(book *epub.Epub, hash string) (db.LibraryBook, error) {
+	path, err := filepath.Abs(book.Filename)
+	if err != nil {
+		return db.LibraryBook{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return db.LibraryBook{}, err
+	}
+	metadata := book.Rootfile.Metadata
+	return db.LibraryBook{
+		Book:      db.Book{Identifier: book.Identifier(), FileHash: hash, Title: book.Title()},
+		Path:      path,
+		Creator:   strings.TrimSpace(metadata.Creator),
+		Language:  strings.TrimSpace(metadata.Language),
+		Publisher: strings.TrimSpace(metadata.Publisher),
+		Cover:     book.Cover(),
+		FileSize:  info.Size(),
+		FileTime:  fileTime(info),
+	}, nil
+}
+
+// fileTime is the modification time of the file as it's stored
+func fileTime(info fs.FileInfo) string {
+	return info.ModTime().UTC().Format(time.RFC3339Nano)
+}
+
+// ScanLibrary adds the epub files under the directories to the library, the
+// files which haven't changed since they were added are skipped. The files
+// and the directories which can't be read are logged and skipped.
+func ScanLibrary(store *db.DB, dirs []string) error {
+	for _, dir := range dirs {
+		dir, err := expandHome(dir)
+		if err != nil {
+			return err
+		}
+		err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				log.Warnf("skip %s: %v", path, err)
+				return nil
+			}
+			if entry.IsDir() || !strings.EqualFold(filepath.Ext(path), ".epub") {
+				return nil
+			}
+			if err := scanBook(store, path); err != nil {
+				log.Warnf("skip %s: %v", path, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failed to scan %s", dir)
+		}
+	}
+	return nil
+}
+
+// expandHome replaces the leading ~ of the path with the home directory
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~"+string(filepath.Separator)) {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to find the home directory")
+	}
+	return filepath.Join(home, path[1:]), nil
+}
+
+// scanBook adds the epub file to the library if it's new or changed
+func scanBook(store *db.DB, path string) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	known, found, err := store.LibraryBook(path)
+	if err != nil {
+		return err
+	}
+	if found && known.FileSize == info.Size() && known.FileTime == fileTime(info) {
+		return nil
+	}
+	book := epub.NewEpub(path)
+	defer book.Close()
+	if err := book.Open(); err != nil {
+		return err
+	}
+	hash, err := db.FileHash(path)
+	if err != nil {
+		return err
+	}
+	entry, err := NewLibraryBook(book, hash)
+	if err != nil {
+		return err
+	}
+	log.Infof("add %s to the library", path)
+	return store.AddBook(entry)
+}
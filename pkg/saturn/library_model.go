@@ -0,0 +1,255 @@
+package saturn
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/elinx/saturn/pkg/db"
+	log "github.com/sirupsen/logrus"
+)
+
+// librarySort is the order of the books in the library
+type librarySort int
+
+const (
+	sortByRecent librarySort = iota
+	sortByTitle
+	sortByCreator
+	sortByProgress
+)
+
+var librarySortNames = []string{"recently read", "title", "author", "progress"}
+
+func (s librarySort) String() string {
+	return librarySortNames[s]
+}
+
+func (s librarySort) next() librarySort {
+	return (s + 1) % librarySort(len(librarySortNames))
+}
+
+type libraryItem struct {
+	book db.LibraryBook
+}
+
+func (i libraryItem) FilterValue() string {
+	return strings.Join([]string{i.Title(), i.book.Creator, i.book.Publisher}, " ")
+}
+func (i libraryItem) Title() string {
+	if title := strings.TrimSpace(i.book.Title); title != "" {
+		return title
+	}
+	return filepath.Base(i.book.Path)
+}
+func (i libraryItem) Description() string {
+	parts := []string{}
+	for _, part := range []string{i.book.Creator, i.book.Publisher, i.book.Language} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	if i.book.ReadAt != "" {
+		parts = append(parts, fmt.Sprintf("%.0f%%", i.book.Progress*100))
+	}
+	return strings.Join(parts, " · ")
+}
+
+// sortBooks orders the books in place, the ties are ordered by title
+func sortBooks(books []db.LibraryBook, by librarySort) {
+	title := func(b db.LibraryBook) string { return strings.ToLower(libraryItem{b}.Title()) }
+	sort.SliceStable(books, func(i, j int) bool {
+		a, b := books[i], books[j]
+		switch by {
+		case sortByRecent:
+			// the books never read go last
+			if a.ReadAt != b.ReadAt {
+				return a.ReadAt > b.ReadAt
+			}
+		case sortByCreator:
+			if ac, bc := strings.ToLower(a.Creator), strings.ToLower(b.Creator); ac != bc {
+				return ac < bc
+			}
+		case sortByProgress:
+			if a.Progress != b.Progress {
+				return a.Progress > b.Progress
+			}
+		}
+		return title(a) < title(b)
+	})
+}
+
+// OpenBookFunc opens the epub file for reading, the returned model shows the
+// book from its last reading position once it gets the window size
+type OpenBookFunc func(path string) (tea.Model, error)
+
+// libraryMessage carries the books read from the database
+type libraryMessage struct {
+	books []db.LibraryBook
+	err   error
+}
+
+// scannedMessage is the library read after the directories are scanned
+type scannedMessage libraryMessage
+
+// bookOpenedMessage carries the model of the book opened from the library
+type bookOpenedMessage struct {
+	model tea.Model
+	err   error
+}
+
+// libraryModel is the start screen without a book, it lists the books of the
+// library directories and of the database. The directories are scanned in the
+// background and the list is refreshed after.
+type libraryModel struct {
+	store  *db.DB
+	dirs   []string
+	open   OpenBookFunc
+	books  []db.LibraryBook
+	sortBy librarySort
+	list   list.Model
+	width  int
+	height int
+
+	scanned   bool
+	opening   bool
+	statusMsg string
+}
+
+// NewLibraryModel creates the library of the books in the store and in dirs,
+// open is called with the file of the selected book
+func NewLibraryModel(store *db.DB, dirs []string, open OpenBookFunc) tea.Model {
+	m := &libraryModel{
+		store: store,
+		dirs:  dirs,
+		open:  open,
+	}
+	m.list = list.New(nil, list.DefaultDelegate{
+		ShowDescription: true,
+		Styles:          list.NewDefaultItemStyles(),
+	}, 0, 0)
+	m.list.Title = "Library"
+	m.list.DisableQuitKeybindings()
+	return m
+}
+
+func (m *libraryModel) Init() tea.Cmd {
+	return tea.Batch(m.load, m.scan)
+}
+
+func (m *libraryModel) load() tea.Msg {
+	books, err := m.store.Library()
+	return libraryMessage{books, err}
+}
+
+// scan adds the new books of the directories and reads the library again
+func (m *libraryModel) scan() tea.Msg {
+	if err := ScanLibrary(m.store, m.dirs); err != nil {
+		log.Error(err)
+	}
+	return scannedMessage(m.load().(libraryMessage))
+}
+
+func (m *libraryModel) Update(message tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := message.(type) {
+	case tea.KeyMsg:
+		if m.list.FilterState() == list.Filtering {
+			break
+		}
+		m.statusMsg = ""
+		switch msg.String() {
+		case "q", "ctrl+c":
+			return m, tea.Quit
+		case "s":
+			m.sortBy = m.sortBy.next()
+			m.statusMsg = "sorted by " + m.sortBy.String()
+			return m, m.setBooks(m.books)
+		case "enter":
+			selected, ok := m.list.SelectedItem().(libraryItem)
+			if !ok || m.opening {
+				return m, nil
+			}
+			m.opening = true
+			m.statusMsg = "opening " + selected.Title() + "..."
+			return m, func() tea.Msg {
+				model, err := m.open(selected.book.Path)
+				return bookOpenedMessage{model, err}
+			}
+		}
+	case libraryMessage:
+		return m, m.loaded(msg)
+	case scannedMessage:
+		m.scanned = true
+		cmd := m.loaded(libraryMessage(msg))
+		if len(m.books) == 0 && m.statusMsg == "" {
+			m.statusMsg = "no books found, add their directories to library.dirs of the config"
+		}
+		return m, cmd
+	case bookOpenedMessage:
+		m.opening = false
+		if msg.err != nil {
+			log.Error(msg.err)
+			m.statusMsg = msg.err.Error()
+			return m, nil
+		}
+		// the book resumes at its last position once it has the size
+		width, height := m.width, m.height
+		return msg.model, tea.Batch(msg.model.Init(), func() tea.Msg {
+			return tea.WindowSizeMsg{Width: width, Height: height}
+		})
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		// the last line is left for the status
+		m.list.SetSize(msg.Width, msg.Height-1)
+		return m, nil
+	}
+	var cmd tea.Cmd
+	m.list, cmd = m.list.Update(message)
+	return m, cmd
+}
+
+// loaded shows the books read from the database
+func (m *libraryModel) loaded(msg libraryMessage) tea.Cmd {
+	if msg.err != nil {
+		log.Error(msg.err)
+		m.statusMsg = msg.err.Error()
+		return nil
+	}
+	return m.setBooks(msg.books)
+}
+
+// setBooks lists the books in the current order, the selected book stays
+// selected
+func (m *libraryModel) setBooks(books []db.LibraryBook) tea.Cmd {
+	selected, hasSelected := m.list.SelectedItem().(libraryItem)
+	m.books = books
+	sortBooks(m.books, m.sortBy)
+	items := []list.Item{}
+	for _, book := range m.books {
+		items = append(items, libraryItem{book})
+	}
+	cmd := m.list.SetItems(items)
+	if hasSelected {
+		for i, item := range m.list.VisibleItems() {
+			if item.(libraryItem).book.Path == selected.book.Path {
+				m.list.Select(i)
+				break
+			}
+		}
+	}
+	return cmd
+}
+
+func (m *libraryModel) View() string {
+	status := m.statusMsg
+	if status == "" {
+		status = "enter: open  /: filter  s: sort by " + m.sortBy.next().String() + "  q: quit"
+		if !m.scanned {
+			status = "scanning the library...  " + status
+		}
+	}
+	return m.list.View() + "\n" + status
+}
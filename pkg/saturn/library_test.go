@@ -0,0 +1,150 @@
+package saturn
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/elinx/saturn/pkg/db"
+)
+
+func TestScanLibrary(t *testing.T) {
+	store, err := db.NewDb(filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	dir := t.TempDir()
+	data, err := os.ReadFile("../../test/data/TaoTeChing.epub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tao := filepath.Join(dir, "nested", "Tao.EPUB")
+	os.MkdirAll(filepath.Dir(tao), 0o755)
+	files := map[string][]byte{
+		tao:                               data,
+		filepath.Join(dir, "broken.epub"): []byte("not a zip"),
+		filepath.Join(dir, "notes.txt"):   []byte("not a book"),
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	missing := filepath.Join(dir, "missing")
+	if err := ScanLibrary(store, []string{dir, missing}); err != nil {
+		t.Fatal(err)
+	}
+	books, err := store.Library()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(books) != 1 {
+		t.Fatalf("got %d books, expect 1", len(books))
+	}
+	expect := db.LibraryBook{
+		Book:      db.Book{Identifier: "9780834824638", FileHash: books[0].FileHash, Title: "Lao Tzu"},
+		Path:      tao,
+		Creator:   "Ursula K. Le Guin",
+		Language:  "en",
+		Publisher: "Shambhala Publications",
+		Cover:     "OEBPS/images/cover.jpg",
+		FileSize:  int64(len(data)),
+		FileTime:  books[0].FileTime,
+	}
+	if !reflect.DeepEqual(books[0], expect) {
+		t.Errorf("case scanned: got %+v, expect %+v", books[0], expect)
+	}
+
+	// the changed file is scanned again
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(tao, later, later)
+	if err := ScanLibrary(store, []string{dir}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _ := store.LibraryBook(tao); got.FileTime == expect.FileTime {
+		t.Errorf("case changed: got time %s, expect it updated", got.FileTime)
+	}
+}
+
+func TestSortBooks(t *testing.T) {
+	books := []db.LibraryBook{
+		{Book: db.Book{Title: "b"}, Creator: "Z", Progress: 0.2, ReadAt: "2022-01-02T00:00:00Z"},
+		{Book: db.Book{Title: "C"}, Creator: "y"},
+		{Book: db.Book{Title: "a"}, Creator: "z", Progress: 0.9, ReadAt: "2022-01-01T00:00:00Z"},
+		{Path: "/books/d.epub", Creator: "y"},
+	}
+	testcases := []struct {
+		by     librarySort
+		expect []string
+	}{
+		{sortByRecent, []string{"b", "a", "C", "d.epub"}},
+		{sortByTitle, []string{"a", "b", "C", "d.epub"}},
+		{sortByCreator, []string{"C", "d.epub", "a", "b"}},
+		{sortByProgress, []string{"a", "b", "C", "d.epub"}},
+	}
+	for _, c := range testcases {
+		sortBooks(books, c.by)
+		got := []string{}
+		for _, book := range books {
+			got = append(got, libraryItem{book}.Title())
+		}
+		if !reflect.DeepEqual(got, c.expect) {
+			t.Errorf("case %s: got %v, expect %v", c.by, got, c.expect)
+		}
+	}
+}
+
+// openedModel stands for the book opened from the library
+type openedModel struct {
+	path string
+}
+
+func (m *openedModel) Init() tea.Cmd                       { return nil }
+func (m *openedModel) Update(tea.Msg) (tea.Model, tea.Cmd) { return m, nil }
+func (m *openedModel) View() string                        { return m.path }
+
+func TestLibraryModel(t *testing.T) {
+	store, err := db.NewDb(filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for _, book := range []db.LibraryBook{
+		{Book: db.Book{FileHash: "1", Title: "b"}, Path: "/b.epub"},
+		{Book: db.Book{FileHash: "2", Title: "a"}, Path: "/a.epub"},
+	} {
+		if err := store.AddBook(book); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opened := &openedModel{}
+	m := NewLibraryModel(store, nil, func(path string) (tea.Model, error) {
+		opened.path = path
+		return opened, nil
+	}).(*libraryModel)
+	m.Update(tea.WindowSizeMsg{Width: 80, Height: 24})
+	m.Update(m.scan())
+
+	// the books never read are sorted by title
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	if model, _ := m.Update(cmd()); model != opened || opened.path != "/a.epub" {
+		t.Errorf("case title: got %v opened, expect /a.epub", opened.path)
+	}
+
+	m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("s")})
+	m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("s")})
+	if got := m.list.SelectedItem().(libraryItem).book.Path; got != "/a.epub" {
+		t.Errorf("case sorted: got %v selected, expect /a.epub", got)
+	}
+	m.open = func(path string) (tea.Model, error) { return nil, os.ErrNotExist }
+	_, cmd = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	if model, _ := m.Update(cmd()); model != m || m.statusMsg != os.ErrNotExist.Error() {
+		t.Errorf("case error: got %q, expect %q", m.statusMsg, os.ErrNotExist.Error())
+	}
+}
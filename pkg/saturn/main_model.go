@@ -1,6 +1,8 @@
 package saturn
 
 import (
+	"strings"
+
 	"github.com/charmbracelet/bubbles/list"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/elinx/saturn/pkg/db"
@@ -9,33 +11,47 @@ import (
 )
 
 type item struct {
-	title string
-	src   epub.ManifestId
+	node      *epub.TocNode
+	collapsed bool
 }
 
-func (i item) FilterValue() string  { return i.title }
-func (i item) Title() string        { return i.title }
+func (i item) FilterValue() string { return i.node.Title }
+func (i item) Title() string {
+	marker := "  "
+	if i.node.HasChildren() {
+		if i.collapsed {
+			marker = "▸ "
+		} else {
+			marker = "▾ "
+		}
+	}
+	return strings.Repeat("  ", i.node.Level) + marker + i.node.Title
+}
 func (i item) Description() string  { return "" }
-func (i item) Src() epub.ManifestId { return i.src }
+func (i item) Src() epub.ManifestId { return i.node.ManifestId }
+func (i item) Fragment() string     { return i.node.Fragment }
 
-func newItems(book *epub.Epub) []list.Item {
+// newItems lists the toc nodes in reading order, children of the collapsed
+// nodes are hidden
+func newItems(toc *epub.TableOfContent, collapsed map[string]bool) []list.Item {
 	content := []list.Item{}
-	toc := book.GetTableOfContent()
-	for _, v := range toc.Orders {
-		record := toc.Content[v]
+	toc.Walk(func(node *epub.TocNode) bool {
 		content = append(content, item{
-			title: v,
-			src:   record.ID,
+			node:      node,
+			collapsed: collapsed[node.ID],
 		})
-	}
+		return !collapsed[node.ID]
+	})
 	return content
 }
 
 func NewMainModel(book *epub.Epub, db *db.DB, renderer *Renderer) tea.Model {
 	return &mainModel{
-		book:     book,
-		db:       db,
-		renderer: renderer,
+		book:      book,
+		db:        db,
+		renderer:  renderer,
+		toc:       book.GetTableOfContent(),
+		collapsed: make(map[string]bool),
 	}
 }
 
@@ -43,19 +59,48 @@ type mainModel struct {
 	book      *epub.Epub
 	db        *db.DB
 	renderer  *Renderer
+	toc       *epub.TableOfContent
+	collapsed map[string]bool
 	tocModel  list.Model
 	textModel tea.Model
 	width     int
 	height    int
+
+	// resumed is true once the last reading position has been restored
+	resumed bool
 }
 
 func (m *mainModel) Init() tea.Cmd {
-	return nil
+	return listenWriteErrors(m.db)
 }
 
 type BlockMessage struct {
-	ID  epub.ManifestId
-	Msg string
+	ID       epub.ManifestId
+	Fragment string
+	Msg      string
+}
+
+// PositionMessage asks the text model to go to the reading position
+type PositionMessage struct {
+	Position db.Position
+}
+
+// WriteErrorMessage reports an annotation failed to be stored, the model
+// showing it has to listen for the next one with its Cmd
+type WriteErrorMessage struct {
+	Err error
+	Cmd tea.Cmd
+}
+
+// listenWriteErrors waits for the next failure of the database writes
+func listenWriteErrors(store *db.DB) tea.Cmd {
+	return func() tea.Msg {
+		err, ok := <-store.Errors()
+		if !ok {
+			return nil
+		}
+		return WriteErrorMessage{err, listenWriteErrors(store)}
+	}
 }
 
 func (m *mainModel) Update(message tea.Msg) (tea.Model, tea.Cmd) {
@@ -63,6 +108,11 @@ func (m *mainModel) Update(message tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.KeyMsg:
 		switch msg.String() {
 		case "q", "ctrl+c":
+			if m.textModel != nil {
+				// the text model saves the position before quitting
+				_, cmd := m.textModel.Update(msg)
+				return m, cmd
+			}
 			return m, tea.Quit
 		case "enter":
 			if item, ok := m.tocModel.SelectedItem().(item); !ok {
@@ -70,22 +120,56 @@ func (m *mainModel) Update(message tea.Msg) (tea.Model, tea.Cmd) {
 			} else {
 				log.Debugf("item selected: %s", item.Src())
 				return m.textModel, func() tea.Msg {
-					return BlockMessage{item.Src(), "select from toc"}
+					return BlockMessage{item.Src(), item.Fragment(), "select from toc"}
 				}
 			}
+		case "A":
+			if m.tocModel.FilterState() != list.Filtering && m.textModel != nil {
+				return NewAnnotationModel(m.book, m.db, m.renderer, m.textModel, m, m.width, m.height), nil
+			}
+		case "tab":
+			if m.tocModel.FilterState() == list.Unfiltered {
+				return m, m.toggleSelected()
+			}
 		}
+	case WriteErrorMessage:
+		return m, tea.Batch(msg.Cmd, m.tocModel.NewStatusMessage(msg.Err.Error()))
 	case tea.WindowSizeMsg:
 		log.Debug("window size changed: ", msg.Width, msg.Height)
 		m.width = msg.Width
 		m.height = msg.Height
-		m.tocModel = list.New(newItems(m.book), list.DefaultDelegate{
+		if m.textModel != nil {
+			// the text is wrapped again in place to keep the position
+			m.tocModel.SetSize(m.width, m.height)
+			_, cmd := m.textModel.Update(resizeMessage{m.width, m.height})
+			return m, cmd
+		}
+		m.tocModel = list.New(newItems(m.toc, m.collapsed), list.DefaultDelegate{
 			ShowDescription: false,
 			Styles:          list.NewDefaultItemStyles(),
 		}, m.width, m.height)
 		m.tocModel.Title = m.book.Title()
 		m.textModel = NewTextModel(m.book, m.db, m.renderer,
 			m.tocModel.SelectedItem().(item).Src(), m, m.width, m.height)
-		m.textModel.Init()
+		initCmd := m.textModel.Init()
+		if !m.resumed {
+			m.resumed = true
+			if pos, found, err := m.db.LoadPosition(); err != nil {
+				log.Error(err)
+			} else if found {
+				return m.textModel, tea.Batch(initCmd, func() tea.Msg {
+					return PositionMessage{pos}
+				})
+			}
+		}
+		var cmd tea.Cmd
+		m.tocModel, cmd = m.tocModel.Update(message)
+		return m, tea.Batch(initCmd, cmd)
+	case layoutMessage, parsedMessage, savePositionMessage:
+		if m.textModel != nil {
+			_, cmd := m.textModel.Update(msg)
+			return m, cmd
+		}
 	}
 
 	var cmd tea.Cmd
@@ -93,6 +177,23 @@ func (m *mainModel) Update(message tea.Msg) (tea.Model, tea.Cmd) {
 	return m, cmd
 }
 
+// toggleSelected expands or collapses the selected toc node
+func (m *mainModel) toggleSelected() tea.Cmd {
+	selected, ok := m.tocModel.SelectedItem().(item)
+	if !ok || !selected.node.HasChildren() {
+		return nil
+	}
+	m.collapsed[selected.node.ID] = !m.collapsed[selected.node.ID]
+	cmd := m.tocModel.SetItems(newItems(m.toc, m.collapsed))
+	for i, v := range m.tocModel.Items() {
+		if v.(item).node == selected.node {
+			m.tocModel.Select(i)
+			break
+		}
+	}
+	return cmd
+}
+
 func (m *mainModel) View() string {
 	return m.tocModel.View()
 }
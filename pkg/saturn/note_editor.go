@@ -0,0 +1,196 @@
+package saturn
+
+import (
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/elinx/saturn/pkg/db"
+	"github.com/elinx/saturn/pkg/util"
+	"github.com/zyedidia/go-runewidth"
+)
+
+var (
+	editorCursorStyle = lipgloss.NewStyle().Reverse(true)
+	editorHelpStyle   = lipgloss.NewStyle().Faint(true)
+)
+
+// CommentMessage is sent to the model behind the editor when the comment of
+// the annotation is saved
+type CommentMessage struct {
+	Annotation db.Annotation
+}
+
+// noteEditorModel is a multi-line editor floating over the text to write the
+// comment of an annotation, ctrl+s saves it and esc drops it.
+type noteEditorModel struct {
+	prevModel tea.Model
+	anno      db.Annotation
+	text      []rune
+	cursor    int
+	width     int
+	height    int
+}
+
+func NewNoteEditorModel(anno db.Annotation, prev tea.Model, width, height int) tea.Model {
+	text := []rune(anno.Comment)
+	return &noteEditorModel{
+		prevModel: prev,
+		anno:      anno,
+		text:      text,
+		cursor:    len(text),
+		width:     width,
+		height:    height,
+	}
+}
+
+func (m *noteEditorModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m *noteEditorModel) Update(message tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := message.(type) {
+	case WriteErrorMessage, layoutMessage, parsedMessage, savePositionMessage:
+		// the model behind shows the failure and the text wrapped again
+		_, cmd := m.prevModel.Update(msg)
+		return m, cmd
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		_, cmd := m.prevModel.Update(msg)
+		return m, cmd
+	}
+	msg, ok := message.(tea.KeyMsg)
+	if !ok {
+		return m, nil
+	}
+	switch msg.Type {
+	case tea.KeyCtrlC:
+		// the text model behind saves the position before quitting
+		_, cmd := m.prevModel.Update(msg)
+		return m, cmd
+	case tea.KeyEsc:
+		return m.prevModel, nil
+	case tea.KeyCtrlS:
+		anno := m.anno
+		anno.Comment = strings.TrimSpace(string(m.text))
+		return m.prevModel, func() tea.Msg {
+			return CommentMessage{anno}
+		}
+	case tea.KeyEnter:
+		m.insert('\n')
+	case tea.KeySpace:
+		m.insert(' ')
+	case tea.KeyRunes:
+		m.insert(msg.Runes...)
+	case tea.KeyBackspace:
+		if m.cursor > 0 {
+			m.text = append(m.text[:m.cursor-1], m.text[m.cursor:]...)
+			m.cursor--
+		}
+	case tea.KeyDelete:
+		if m.cursor < len(m.text) {
+			m.text = append(m.text[:m.cursor], m.text[m.cursor+1:]...)
+		}
+	case tea.KeyLeft:
+		m.cursor = util.MaxInt(0, m.cursor-1)
+	case tea.KeyRight:
+		m.cursor = util.MinInt(len(m.text), m.cursor+1)
+	case tea.KeyHome, tea.KeyCtrlA:
+		m.cursor = m.lineStart(m.cursor)
+	case tea.KeyEnd, tea.KeyCtrlE:
+		m.cursor = m.lineEnd(m.cursor)
+	case tea.KeyUp:
+		if start := m.lineStart(m.cursor); start > 0 {
+			m.moveToColumn(m.lineStart(start-1), m.cursor-start)
+		}
+	case tea.KeyDown:
+		if end := m.lineEnd(m.cursor); end < len(m.text) {
+			m.moveToColumn(end+1, m.cursor-m.lineStart(m.cursor))
+		}
+	}
+	return m, nil
+}
+
+func (m *noteEditorModel) insert(runes ...rune) {
+	text := make([]rune, 0, len(m.text)+len(runes))
+	text = append(text, m.text[:m.cursor]...)
+	text = append(text, runes...)
+	m.text = append(text, m.text[m.cursor:]...)
+	m.cursor += len(runes)
+}
+
+// lineStart returns the index of the first rune of the line at i
+func (m *noteEditorModel) lineStart(i int) int {
+	for i > 0 && m.text[i-1] != '\n' {
+		i--
+	}
+	return i
+}
+
+// lineEnd returns the index of the newline ending the line at i
+func (m *noteEditorModel) lineEnd(i int) int {
+	for i < len(m.text) && m.text[i] != '\n' {
+		i++
+	}
+	return i
+}
+
+// moveToColumn puts the cursor at the column of the line starting at start,
+// or at the end of the line if it is shorter
+func (m *noteEditorModel) moveToColumn(start, column int) {
+	m.cursor = util.MinInt(start+column, m.lineEnd(start))
+}
+
+// editorLines wraps the text to the width and draws the cursor, the index of
+// the line with the cursor is returned as well
+func editorLines(text []rune, cursor, width int) ([]string, int) {
+	lines := []string{}
+	var line strings.Builder
+	lineWidth, cursorLine := 0, 0
+	for i := 0; i <= len(text); i++ {
+		c := ' '
+		if i < len(text) && text[i] != '\n' {
+			c = text[i]
+		}
+		w := runewidth.RuneWidth(c)
+		if lineWidth+w > width && lineWidth > 0 {
+			lines = append(lines, line.String())
+			line.Reset()
+			lineWidth = 0
+		}
+		if i == cursor {
+			line.WriteString(editorCursorStyle.Render(string(c)))
+			cursorLine = len(lines)
+		} else if i < len(text) && text[i] != '\n' {
+			line.WriteRune(c)
+		}
+		lineWidth += w
+		if i < len(text) && text[i] == '\n' {
+			lines = append(lines, line.String())
+			line.Reset()
+			lineWidth = 0
+		}
+	}
+	return append(lines, line.String()), cursorLine
+}
+
+// View draws the editor over the bottom half of the text, the lines around
+// the cursor are shown if the comment doesn't fit
+func (m *noteEditorModel) View() string {
+	frameWidth := noteStyle.GetHorizontalFrameSize()
+	frameHeight := noteStyle.GetVerticalFrameSize()
+	innerWidth := util.MaxInt(m.width*2/3, util.MinInt(m.width, 20)) - frameWidth
+	maxLines := util.MaxInt(1, m.height/2-frameHeight-1)
+	lines, cursorLine := editorLines(m.text, m.cursor, innerWidth)
+	start := util.MaxInt(0, cursorLine-maxLines+1)
+	lines = lines[start:util.MinInt(len(lines), start+maxLines)]
+	for len(lines) < util.MinInt(maxLines, 3) {
+		lines = append(lines, "")
+	}
+	for i, line := range lines {
+		lines[i] = line + strings.Repeat(" ", util.MaxInt(0, innerWidth-lipgloss.Width(line)))
+	}
+	help := editorHelpStyle.Render(runewidth.Truncate("ctrl+s: save  esc: cancel", innerWidth, ""))
+	box := noteStyle.Render(strings.Join(append(lines, help), "\n"))
+	return overlayBox(m.prevModel.View(), box, m.width)
+}
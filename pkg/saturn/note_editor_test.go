@@ -0,0 +1,58 @@
+package saturn
+
+import (
+	"reflect"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/elinx/saturn/pkg/db"
+)
+
+func TestNoteEditor(t *testing.T) {
+	m := NewNoteEditorModel(db.Annotation{Comment: "ab"}, nil, 80, 24).(*noteEditorModel)
+	keys := []tea.KeyMsg{
+		{Type: tea.KeyEnter},
+		{Type: tea.KeyRunes, Runes: []rune("道可")},
+		{Type: tea.KeySpace},
+		{Type: tea.KeyUp},
+		{Type: tea.KeyRunes, Runes: []rune("x")},
+		{Type: tea.KeyDown},
+		{Type: tea.KeyBackspace},
+		{Type: tea.KeyHome},
+		{Type: tea.KeyDelete},
+	}
+	for _, key := range keys {
+		m.Update(key)
+	}
+	if got, expect := string(m.text), "abx\n可"; got != expect {
+		t.Errorf("got %q, expect %q", got, expect)
+	}
+	if m.cursor != 4 {
+		t.Errorf("got cursor %d, expect 4", m.cursor)
+	}
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyCtrlS})
+	if msg, ok := cmd().(CommentMessage); !ok || msg.Annotation.Comment != "abx\n可" {
+		t.Errorf("unexpected message %v", msg)
+	}
+}
+
+func TestEditorLines(t *testing.T) {
+	testcases := []struct {
+		text       string
+		cursor     int
+		width      int
+		expect     []string
+		cursorLine int
+	}{
+		{"", 0, 10, []string{editorCursorStyle.Render(" ")}, 0},
+		{"ab\ncd", 5, 10, []string{"ab", "cd" + editorCursorStyle.Render(" ")}, 1},
+		{"abcdef", 0, 4, []string{editorCursorStyle.Render("a") + "bcd", "ef"}, 0},
+		{"道可道", 3, 4, []string{"道可", "道" + editorCursorStyle.Render(" ")}, 1},
+	}
+	for _, tc := range testcases {
+		lines, cursorLine := editorLines([]rune(tc.text), tc.cursor, tc.width)
+		if !reflect.DeepEqual(lines, tc.expect) || cursorLine != tc.cursorLine {
+			t.Errorf("text %q: got %q, %d, expect %q, %d", tc.text, lines, cursorLine, tc.expect, tc.cursorLine)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package saturn
+
+import (
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/elinx/saturn/pkg/epub"
+	"github.com/elinx/saturn/pkg/util"
+	"github.com/elinx/saturn/pkg/viewport"
+)
+
+var noteStyle = lipgloss.NewStyle().
+	Border(lipgloss.RoundedBorder()).
+	Padding(0, 1)
+
+// noteModel shows a footnote in a box floating over the text, the text
+// model behind it is kept untouched so dismissing the note returns to the
+// same position.
+type noteModel struct {
+	prevModel tea.Model
+	viewport  viewport.Model
+	width     int
+	height    int
+}
+
+// NewNoteModel renders the note buffer into a box no larger than half of the
+// screen, long notes can be scrolled inside the box.
+func NewNoteModel(book *epub.Epub, note *Buffer, prev tea.Model, width, height int) tea.Model {
+	frameWidth := noteStyle.GetHorizontalFrameSize()
+	frameHeight := noteStyle.GetVerticalFrameSize()
+	boxWidth := util.MaxInt(width*2/3, util.MinInt(width, 20))
+	renderer := NewRender(book, note)
+	renderer.ShowLinum = false
+	renderer.Render(boxWidth - frameWidth)
+	// a note is short, it's wrapped at once so the box fits it
+	for note.wrapVisual(0, note.VisualLinesNum()) {
+	}
+	boxHeight := util.MaxInt(1, util.MinInt(note.VisualLinesNum(), height/2-frameHeight))
+	m := &noteModel{
+		prevModel: prev,
+		viewport:  viewport.New(boxWidth-frameWidth, boxHeight, note),
+		width:     width,
+		height:    height,
+	}
+	m.viewport.Style = lipgloss.NewStyle()
+	return m
+}
+
+func (m *noteModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m *noteModel) Update(message tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := message.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "ctrl+c":
+			// the text model behind saves the position before quitting
+			_, cmd := m.prevModel.Update(msg)
+			return m, cmd
+		case "esc", "q", "enter":
+			return m.prevModel, nil
+		}
+	case WriteErrorMessage, layoutMessage, parsedMessage, savePositionMessage:
+		// the model behind shows the failure and the text wrapped again
+		_, cmd := m.prevModel.Update(msg)
+		return m, cmd
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		_, cmd := m.prevModel.Update(msg)
+		return m, cmd
+	case tea.MouseMsg:
+		if msg.Type == tea.MouseLeft {
+			return m.prevModel, nil
+		}
+	}
+	var cmd tea.Cmd
+	m.viewport, cmd = m.viewport.Update(message)
+	return m, cmd
+}
+
+// View draws the note box over the bottom half of the text
+func (m *noteModel) View() string {
+	return overlayBox(m.prevModel.View(), noteStyle.Render(strings.TrimRight(m.viewport.View(), "\n")), m.width)
+}
+
+// overlayBox draws the box centered above the last line of the background
+func overlayBox(background, box string, width int) string {
+	lines := strings.Split(background, "\n")
+	boxLines := strings.Split(box, "\n")
+	start := util.MaxInt(0, len(lines)-len(boxLines)-1)
+	for i, line := range boxLines {
+		if start+i >= len(lines) {
+			break
+		}
+		lines[start+i] = lipgloss.PlaceHorizontal(width, lipgloss.Center, line)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -1,9 +1,12 @@
 package saturn
 
 import (
+	"fmt"
+	"runtime"
 	"strings"
 
 	"github.com/elinx/saturn/pkg/epub"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/net/html"
 )
@@ -11,6 +14,13 @@ import (
 type Parser struct {
 	book   *epub.Epub
 	buffer *Buffer
+
+	// Workers is the number of spine items parsed at once, 0 is the number
+	// of CPUs
+	Workers int
+
+	// currId is the spine item being parsed
+	currId epub.ManifestId
 }
 
 func NewParser(book *epub.Epub) *Parser {
@@ -24,22 +34,117 @@ func (p *Parser) GetBuffer() *Buffer {
 	return p.buffer
 }
 
-// Parse iterates over the spine and parses each HTML file
+// parsedItem is a spine item parsed into a buffer of its own, the lines of
+// the buffer start at 0
+type parsedItem struct {
+	id     epub.ManifestId
+	buffer *Buffer
+	err    error
+}
+
+// Parse parses the HTML files of the spine on Workers goroutines, the items
+// are appended to the buffer in the spine order so the result is the same as
+// parsing them one by one. The first error in the spine order is returned.
 func (p *Parser) Parse() error {
-	content, err := p.book.GetSpinContent()
-	if err != nil {
-		return err
+	ids := []epub.ManifestId{}
+	for _, item := range p.book.Rootfile.Spine.Items {
+		ids = append(ids, item.IDref)
 	}
-	for _, id := range content.Orders {
-		htmlContent := content.Contents[id]
-		p.buffer.BlockPos[id] = BufferLineIndex(len(p.buffer.Lines))
-		p.parse1(htmlContent)
+	var err error
+	p.parseItems(ids, func(item parsedItem) bool {
+		if item.err != nil {
+			err = item.err
+			return false
+		}
+		p.merge(item)
+		return true
+	})
+	return err
+}
+
+// ParseLazy lays out the spine items without parsing them, each item gets as
+// many blank lines as it's estimated to have from the size of its file. The
+// items are parsed by the buffer when their lines are needed.
+func (p *Parser) ParseLazy() error {
+	for _, item := range p.book.Rootfile.Spine.Items {
+		size, err := p.book.GetContentSizeByManifestId(item.IDref)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read %s", item.IDref)
+		}
+		p.buffer.BlockPos[item.IDref] = BufferLineIndex(len(p.buffer.Lines))
+		p.buffer.Lines = append(p.buffer.Lines, make([]Line, placeholderLines(size))...)
+		p.buffer.pending[item.IDref] = true
 	}
+	p.buffer.loader = newItemLoader(p)
 	return nil
 }
 
+// parseItems parses the spine items on Workers goroutines, fn gets them in
+// the order of ids until it returns false
+func (p *Parser) parseItems(ids []epub.ManifestId, fn func(parsedItem) bool) {
+	workers := p.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	jobs := make(chan int)
+	done := make(chan struct{})
+	defer close(done)
+	results := make([]chan parsedItem, len(ids))
+	for i := range results {
+		results[i] = make(chan parsedItem, 1)
+	}
+	go func() {
+		defer close(jobs)
+		for i := range ids {
+			select {
+			case jobs <- i:
+			case <-done:
+				return
+			}
+		}
+	}()
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range jobs {
+				buffer, err := p.parseItem(ids[i])
+				results[i] <- parsedItem{ids[i], buffer, err}
+			}
+		}()
+	}
+	for _, result := range results {
+		if !fn(<-result) {
+			return
+		}
+	}
+}
+
+// parseItem reads and parses the spine item into a new buffer
+func (p *Parser) parseItem(id epub.ManifestId) (*Buffer, error) {
+	content, err := p.book.GetContentByManifestId(id)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", id)
+	}
+	parser := NewParser(p.book)
+	parser.currId = id
+	if err := parser.parse1(content); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s", id)
+	}
+	return parser.buffer, nil
+}
+
+// merge appends the lines of the parsed item to the buffer, its anchors are
+// moved to where the lines are now
+func (p *Parser) merge(item parsedItem) {
+	offset := BufferLineIndex(len(p.buffer.Lines))
+	p.buffer.BlockPos[item.id] = offset
+	p.buffer.Lines = append(p.buffer.Lines, item.buffer.Lines...)
+	for anchor, linum := range item.buffer.Anchors[item.id] {
+		p.buffer.AddAnchor(item.id, anchor, offset+linum)
+	}
+}
+
 func (p *Parser) parse1(content string) error {
-	log.Infoln("Enter into parsing of HTML")
+	log.Debugln("Enter into parsing of HTML")
 	htmlNode, err := html.Parse(strings.NewReader(content))
 	if err != nil {
 		return err
@@ -56,7 +161,7 @@ func (p *Parser) parse2(n *html.Node) (*Segment, error) {
 		if len(strings.TrimSpace(n.Data)) == 0 {
 			return nil, nil
 		}
-		return &Segment{n.Data, "", 0}, nil
+		return &Segment{n.Data, "", 0, "", ""}, nil
 	case html.DocumentNode:
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
 			if _, err := p.parse2(c); err != nil {
@@ -67,6 +172,7 @@ func (p *Parser) parse2(n *html.Node) (*Segment, error) {
 	case html.CommentNode:
 		return nil, nil
 	}
+	p.recordAnchor(n)
 	var segments []Segment
 	pos := ByteIndex(0)
 	contents := []string{}
@@ -90,16 +196,102 @@ func (p *Parser) parse2(n *html.Node) (*Segment, error) {
 		// TODO: support image display
 	case "style":
 		// TODO: support inline style
-	case "i", "b", "strong", "span", "em":
+	case "i", "b", "strong", "span", "em", "sup", "sub":
 		if n.Parent.Data == "body" {
 			p.buffer.Lines = append(p.buffer.Lines, Line{lineContent, segments, n.Data})
 			return nil, nil
 		}
-		return &Segment{lineContent, n.Data, 0}, nil
+		return &Segment{lineContent, n.Data, 0, "", ""}, nil
 	case "a":
-		return &Segment{lineContent, "a", 0}, nil
+		return &Segment{lineContent, "a", 0, p.resolveLink(getAttribute(n, "href")), getAttribute(n, "epub:type")}, nil
 	default:
 		p.buffer.Lines = append(p.buffer.Lines, Line{lineContent, segments, n.Data})
 	}
 	return nil, nil
 }
+
+// ParseElement parses the element with id `anchor` in the spine item `id` into
+// a new buffer, it's used to show a footnote or an endnote alone. The closest
+// block element is parsed if the anchor is an inline element.
+func (p *Parser) ParseElement(id epub.ManifestId, anchor string) (*Buffer, error) {
+	content, err := p.book.GetContentByManifestId(id)
+	if err != nil {
+		return nil, err
+	}
+	parser := NewParser(p.book)
+	parser.currId = id
+	if err := parser.parseElement(content, anchor); err != nil {
+		return nil, err
+	}
+	return parser.buffer, nil
+}
+
+func (p *Parser) parseElement(content, anchor string) error {
+	htmlNode, err := html.Parse(strings.NewReader(content))
+	if err != nil {
+		return err
+	}
+	node := findElementById(htmlNode, anchor)
+	if node == nil {
+		return fmt.Errorf("element #%s not found", anchor)
+	}
+	for isInlineElement(node) && node.Parent != nil && node.Parent.Data != "body" {
+		node = node.Parent
+	}
+	if _, err := p.parse2(node); err != nil {
+		return err
+	}
+	return nil
+}
+
+func findElementById(n *html.Node, id string) *html.Node {
+	if n.Type == html.ElementNode && getAttribute(n, "id") == id {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElementById(c, id); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// inlineTags are the elements parsed into the segments of a line
+var inlineTags = []string{"a", "i", "b", "strong", "span", "em", "sup", "sub"}
+
+func isInlineElement(n *html.Node) bool {
+	for _, tag := range inlineTags {
+		if n.Data == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// recordAnchor maps the id of the element to the line where its content starts,
+// lines are appended after the children are parsed so the next line is the one.
+func (p *Parser) recordAnchor(n *html.Node) {
+	for _, attr := range n.Attr {
+		if attr.Key == "id" && attr.Val != "" {
+			p.buffer.AddAnchor(p.currId, attr.Val, BufferLineIndex(len(p.buffer.Lines)))
+		}
+	}
+}
+
+// resolveLink resolves the href of a link relative to the rootfile like the
+// hrefs in the manifest, external links are kept as they are.
+func (p *Parser) resolveLink(href string) epub.HRef {
+	if href == "" || p.book == nil || strings.Contains(href, "://") || strings.HasPrefix(href, "mailto:") {
+		return epub.HRef(href)
+	}
+	return epub.ResolveHref(p.book.ManifestIdToHref(p.currId), href)
+}
+
+func getAttribute(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
@@ -1,11 +1,16 @@
 package saturn
 
 import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/elinx/saturn/pkg/epub"
-	_ "github.com/elinx/saturn/pkg/logconfig"
 )
 
 func TestParse(t *testing.T) {
@@ -165,7 +170,24 @@ func TestParse(t *testing.T) {
 						Content: "The way you can go google",
 						Segments: []Segment{
 							{Content: "The way you can go ", Style: "", Pos: 0},
-							{Content: "google", Style: "a", Pos: 19},
+							{Content: "google", Style: "a", Pos: 19, Link: "http://www.google.com"},
+						},
+						Style: "p",
+					},
+				},
+			},
+		},
+		{
+			name: "note reference in p",
+			html: `<p>The way<a href="#n1"><sup>1</sup></a> you can go</p>`,
+			expect: &Buffer{
+				Lines: []Line{
+					{
+						Content: "The way1 you can go",
+						Segments: []Segment{
+							{Content: "The way", Style: "", Pos: 0},
+							{Content: "1", Style: "a", Pos: 7, Link: "#n1"},
+							{Content: " you can go", Style: "", Pos: 8},
 						},
 						Style: "p",
 					},
@@ -186,3 +208,260 @@ func TestParse(t *testing.T) {
 	}
 
 }
+
+func TestParseAnchors(t *testing.T) {
+	html := `<div id="chapter"><h1 id="title">Title</h1>` +
+		`<p>The way you can go</p>` +
+		`<p id="sec1">isn't the real <span id="inline">way</span></p></div>` +
+		`<p id="last">The name you can say</p>`
+	parser := NewParser(nil)
+	parser.currId = "chapter1"
+	if err := parser.parse1(html); err != nil {
+		t.Fatal(err)
+	}
+	expect := map[epub.ManifestId]map[string]BufferLineIndex{
+		"chapter1": {
+			"chapter": 0,
+			"title":   0,
+			"sec1":    2,
+			"inline":  2,
+			"last":    4,
+		},
+	}
+	if !reflect.DeepEqual(expect, parser.buffer.Anchors) {
+		t.Errorf("got: %v, expect: %v", parser.buffer.Anchors, expect)
+	}
+	if linum := parser.buffer.GetBufferLineNumByAnchor("chapter1", "unknown"); linum != 0 {
+		t.Errorf("unknown anchor got line %d, expect 0", linum)
+	}
+}
+
+func TestParseElement(t *testing.T) {
+	html := `<p>The way<a epub:type="noteref" href="#n1">1</a> you can go</p>` +
+		`<aside epub:type="footnote" id="n1"><p>isn't the real way.</p></aside>` +
+		`<p><a id="n2">2.</a> The name you can say</p>`
+	testcases := []struct {
+		anchor string
+		expect []string
+	}{
+		{"n1", []string{"isn't the real way.", ""}},
+		{"n2", []string{"2. The name you can say"}},
+	}
+	for _, tc := range testcases {
+		parser := NewParser(nil)
+		if err := parser.parseElement(html, tc.anchor); err != nil {
+			t.Fatal(err)
+		}
+		contents := []string{}
+		for _, line := range parser.buffer.Lines {
+			contents = append(contents, line.Content)
+		}
+		if !reflect.DeepEqual(tc.expect, contents) {
+			t.Errorf("anchor %s: got %q, expect %q", tc.anchor, contents, tc.expect)
+		}
+	}
+	if err := NewParser(nil).parseElement(html, "n3"); err == nil {
+		t.Error("expect error for unknown anchor")
+	}
+}
+
+// writeTestEpub writes a book of the chapters with the paragraphs each, the
+// paragraphs have anchors and links to be moved by the parser
+func writeTestEpub(tb testing.TB, chapters, paragraphs int) string {
+	return writeEpubFiles(tb, testEpubFiles(chapters, paragraphs))
+}
+
+// testEpubFiles returns the files of the book of writeTestEpub by their path
+func testEpubFiles(chapters, paragraphs int) map[string]string {
+	files := map[string]string{
+		"META-INF/container.xml": `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>`,
+		"OEBPS/toc.ncx": `<?xml version="1.0"?><ncx><navMap></navMap></ncx>`,
+	}
+	manifest, spine := []string{`<item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>`}, []string{}
+	for i := 0; i < chapters; i++ {
+		var body strings.Builder
+		fmt.Fprintf(&body, "<html><body><h1 id=\"c%d\">Chapter %d</h1>", i, i)
+		for j := 0; j < paragraphs; j++ {
+			fmt.Fprintf(&body, "<p id=\"p%d\">The way you can go <i>isn't</i> the real way, "+
+				"the name you can say <a href=\"ch%d.xhtml#p0\">isn't</a> the real name.</p>", j, (i+1)%chapters)
+		}
+		body.WriteString("</body></html>")
+		files[fmt.Sprintf("OEBPS/ch%d.xhtml", i)] = body.String()
+		manifest = append(manifest, fmt.Sprintf(`<item id="ch%d" href="ch%d.xhtml" media-type="application/xhtml+xml"/>`, i, i))
+		spine = append(spine, fmt.Sprintf(`<itemref idref="ch%d"/>`, i))
+	}
+	files["OEBPS/content.opf"] = `<?xml version="1.0"?><package><metadata><title>Test</title></metadata>` +
+		"<manifest>" + strings.Join(manifest, "") + "</manifest>" +
+		`<spine toc="ncx">` + strings.Join(spine, "") + "</spine></package>"
+	return files
+}
+
+// writeEpubFiles zips the files into an epub
+func writeEpubFiles(tb testing.TB, files map[string]string) string {
+	filename := filepath.Join(tb.TempDir(), "book.epub")
+	file, err := os.Create(filename)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer file.Close()
+	archive := zip.NewWriter(file)
+	for name, content := range files {
+		w, err := archive.Create(name)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		if _, err := io.WriteString(w, content); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		tb.Fatal(err)
+	}
+	return filename
+}
+
+// parseTestEpub parses the book with the number of workers
+func parseTestEpub(tb testing.TB, filename string, workers int) *Buffer {
+	book := epub.NewEpub(filename)
+	if err := book.Open(); err != nil {
+		tb.Fatal(err)
+	}
+	defer book.Close()
+	parser := NewParser(book)
+	parser.Workers = workers
+	if err := parser.Parse(); err != nil {
+		tb.Fatal(err)
+	}
+	return parser.GetBuffer()
+}
+
+// parseSequential parses the spine items one by one into the same buffer
+// without the workers of Parse
+func parseSequential(tb testing.TB, filename string) *Buffer {
+	book := epub.NewEpub(filename)
+	if err := book.Open(); err != nil {
+		tb.Fatal(err)
+	}
+	defer book.Close()
+	content, err := book.GetSpinContent()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	parser := NewParser(book)
+	for _, id := range content.Orders {
+		parser.buffer.BlockPos[id] = BufferLineIndex(len(parser.buffer.Lines))
+		parser.currId = id
+		if err := parser.parse1(content.Contents[id]); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return parser.GetBuffer()
+}
+
+func TestParseConcurrent(t *testing.T) {
+	testcases := []struct {
+		name     string
+		filename string
+	}{
+		{"tao te ching", "../../test/data/TaoTeChing.epub"},
+		{"synthetic", writeTestEpub(t, 50, 20)},
+	}
+	for _, c := range testcases {
+		expect := parseSequential(t, c.filename)
+		for _, workers := range []int{1, 2, 8} {
+			got := parseTestEpub(t, c.filename, workers)
+			if !reflect.DeepEqual(got.Lines, expect.Lines) || !reflect.DeepEqual(got.BlockPos, expect.BlockPos) ||
+				!reflect.DeepEqual(got.Anchors, expect.Anchors) {
+				t.Errorf("case %s: got a different buffer with %d workers", c.name, workers)
+			}
+		}
+	}
+}
+
+func TestParseLazy(t *testing.T) {
+	filename := writeTestEpub(t, 6, 20)
+	expect := parseTestEpub(t, filename, 0)
+	buffer := openLazyTestBook(t, filename)
+	if got := len(buffer.pending); got != 6 {
+		t.Errorf("case lazy: got %d items pending, expect 6", got)
+	}
+	for linum, line := range buffer.Lines {
+		if line.Content != "" {
+			t.Fatalf("case lazy: got %q at line %d, expect a blank line", line.Content, linum)
+		}
+	}
+	if got, end := buffer.BlockPos["ch3"], buffer.BlockPos["ch4"]; got >= end {
+		t.Errorf("case lazy: got ch3 at %d, expect it before ch4 at %d", got, end)
+	}
+
+	buffer.load("ch3")
+	if got := buffer.Lines[buffer.BlockPos["ch3"]].Content; got != "Chapter 3" {
+		t.Errorf("case load: got %q at the start of ch3, expect %q", got, "Chapter 3")
+	}
+	if got, expect := buffer.Anchors["ch3"]["p1"]-buffer.BlockPos["ch3"], expect.Anchors["ch3"]["p1"]-expect.BlockPos["ch3"]; got != expect {
+		t.Errorf("case load: got anchor at %d, expect %d", got, expect)
+	}
+	buffer.loadAll()
+	if len(buffer.pending) != 0 || !reflect.DeepEqual(buffer.Lines, expect.Lines) ||
+		!reflect.DeepEqual(buffer.BlockPos, expect.BlockPos) || !reflect.DeepEqual(buffer.Anchors, expect.Anchors) {
+		t.Error("case all: got a different buffer from Parse")
+	}
+}
+
+// openLazyTestBook lays out the book without parsing it, the book is closed
+// after the test
+func openLazyTestBook(tb testing.TB, filename string) *Buffer {
+	book := epub.NewEpub(filename)
+	if err := book.Open(); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		prefetches.Wait()
+		parses.Wait()
+		book.Close()
+	})
+	parser := NewParser(book)
+	if err := parser.ParseLazy(); err != nil {
+		tb.Fatal(err)
+	}
+	return parser.GetBuffer()
+}
+
+func TestParseError(t *testing.T) {
+	files := testEpubFiles(10, 2)
+	delete(files, "OEBPS/ch3.xhtml")
+	book := epub.NewEpub(writeEpubFiles(t, files))
+	if err := book.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer book.Close()
+	for _, workers := range []int{1, 4} {
+		parser := NewParser(book)
+		parser.Workers = workers
+		if err := parser.Parse(); err == nil || !strings.Contains(err.Error(), "ch3") {
+			t.Errorf("case %d workers: got %v, expect the error of ch3", workers, err)
+		}
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	books := []struct {
+		name     string
+		filename string
+	}{
+		{"TaoTeChing", "../../test/data/TaoTeChing.epub"},
+		{"Synthetic", writeTestEpub(b, 500, 200)},
+	}
+	for _, book := range books {
+		for _, workers := range []int{1, 0} {
+			b.Run(fmt.Sprintf("%s/workers=%d", book.name, workers), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					parseTestEpub(b, book.filename, workers)
+				}
+			})
+		}
+	}
+}
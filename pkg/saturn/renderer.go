@@ -3,8 +3,10 @@ package saturn
 import (
 	"strconv"
 	"strings"
+	"sync"
 	"unicode/utf8"
 
+	"github.com/elinx/saturn/pkg/db"
 	"github.com/elinx/saturn/pkg/epub"
 	"github.com/elinx/saturn/pkg/util"
 	log "github.com/sirupsen/logrus"
@@ -14,46 +16,280 @@ type Renderer struct {
 	book   *epub.Epub
 	buffer *Buffer
 
+	width      int
 	wrapWidth  int
 	linumWidth int
+
+	// ShowLinum shows the line number gutter in front of each line
+	ShowLinum bool
+	// MaxWidth is the widest the text is wrapped at, 0 fills the width
+	MaxWidth int
+	// Margin is the number of blank columns between the gutter and the text
+	Margin int
+
+	annotations *annotationPainter
+
+	// cache keeps the layouts for the next time, nil if the book isn't
+	// cached
+	cache *BookCache
 }
 
 func NewRender(book *epub.Epub, buffer *Buffer) *Renderer {
 	r := &Renderer{
-		book:   book,
-		buffer: buffer,
+		book:      book,
+		buffer:    buffer,
+		ShowLinum: true,
 	}
+	r.annotations = newAnnotationPainter(buffer)
 	buffer.renderer = r
 	return r
 }
 
-// Render iterates over the buffer and renders each line to the screen.
+// layout is where the sections of the buffer are shown at a width, it is
+// built by wrap and shown by apply
+type layout struct {
+	width      int
+	wrapWidth  int
+	linumWidth int
+	sections   []sectionLayout
+	// loads is Buffer.loads when the layout is built, it's built again if
+	// spine items were parsed in the meantime
+	loads int
+}
+
+// cacheWrites are the buffers and the layouts being written to the cache in
+// the background
+var cacheWrites sync.WaitGroup
+
+// Render lays out the buffer at the width, the lines rendered before are
+// replaced. The sections are wrapped and rendered to the screen when they
+// are shown.
 func (r *Renderer) Render(width int) {
-	lineNumAccum := 0
-	r.linumWidth = len(strconv.Itoa(len(r.buffer.Lines)))
-	r.wrapWidth = width - r.linumWidth
+	r.apply(r.wrap(width))
+}
+
+// wrap estimates the visual lines of each section at the width without
+// changing what is shown, the layout cached for a whole book is used instead
+// if there is. It only reads the renderer so it can run off the UI goroutine
+// on a snapshot.
+func (r *Renderer) wrap(width int) layout {
+	r.width = width
+	r.linumWidth = 0
+	if r.ShowLinum {
+		r.linumWidth = len(strconv.Itoa(len(r.buffer.Lines)))
+	}
+	r.fitWidth()
+	l := layout{
+		width:      width,
+		wrapWidth:  r.wrapWidth,
+		linumWidth: r.linumWidth,
+		sections:   r.buffer.estimateSections(r.wrapWidth),
+		loads:      r.buffer.loads,
+	}
+	if r.cache == nil || len(r.buffer.pending) > 0 || len(r.buffer.Lines) == 0 {
+		return l
+	}
+	if cached, ok := r.cache.loadLayout(r.wrapWidth); ok && len(cached.VisualLineOffset) == len(r.buffer.Lines) {
+		cached.split(l.sections)
+		return l
+	}
+	cacheWrites.Add(1)
+	go func(s *Renderer) {
+		defer cacheWrites.Done()
+		if err := s.cache.saveLayout(s.wrapWidth, s.wrapAll()); err != nil {
+			log.Warn(err)
+		}
+	}(r.snapshot())
+	return l
+}
+
+// wrapAll wraps every line of the buffer for the cache
+func (r *Renderer) wrapAll() cachedLayout {
+	cached := cachedLayout{VisualLineOffset: make([]VisualLineIndex, 0, len(r.buffer.Lines))}
 	for linum := range r.buffer.Lines {
-		r.buffer.visualLineOffset = append(r.buffer.visualLineOffset, VisualLineIndex(lineNumAccum))
-		visualLines := r.RenderLine(BufferLineIndex(linum))
-		lineNumAccum += len(visualLines)
-		r.buffer.visualLines = append(r.buffer.visualLines, visualLines...)
+		cached.VisualLineOffset = append(cached.VisualLineOffset, VisualLineIndex(len(cached.VisualLineLens)))
+		cached.VisualLineLens = append(cached.VisualLineLens, r.breakLine(BufferLineIndex(linum))...)
+	}
+	return cached
+}
+
+// fitWidth wraps the text in what is left of the width by the gutter
+func (r *Renderer) fitWidth() {
+	r.wrapWidth = r.width - r.GutterWidth()
+	if r.MaxWidth > 0 {
+		r.wrapWidth = util.MinInt(r.wrapWidth, r.MaxWidth)
+	}
+}
+
+// SetCache keeps the layouts in the cache of the book, nil doesn't cache them
+func (r *Renderer) SetCache(cache *BookCache) {
+	r.cache = cache
+}
+
+// snapshot copies the renderer for wrap and renderLines, the buffer is copied
+// too so the spine items parsed meanwhile don't change it
+func (r *Renderer) snapshot() *Renderer {
+	s := *r
+	s.buffer = r.buffer.snapshot()
+	return &s
+}
+
+// apply shows the layout, the sections rendered at the old width are
+// dropped. The layout is built again if it was built before spine items were
+// parsed.
+func (r *Renderer) apply(l layout) {
+	if l.loads != r.buffer.loads {
+		l = r.wrap(l.width)
+	}
+	r.width, r.wrapWidth, r.linumWidth = l.width, l.wrapWidth, l.linumWidth
+	r.buffer.sections = l.sections
+	r.buffer.rendered = newSectionCache()
+}
+
+// loaded is called after spine items were parsed into the buffer, their
+// annotations are painted and the line numbers are widened if needed. The
+// buffer is cached once every item is parsed.
+func (r *Renderer) loaded() {
+	r.annotations.locate()
+	if r.buffer.sections != nil {
+		r.fitLinum()
+	}
+	if r.cache != nil && len(r.buffer.pending) == 0 {
+		b := &Buffer{Lines: r.buffer.Lines, BlockPos: r.buffer.BlockPos, Anchors: r.buffer.Anchors}
+		cacheWrites.Add(1)
+		go func(cache *BookCache) {
+			defer cacheWrites.Done()
+			if err := cache.SaveBuffer(b); err != nil {
+				log.Warn(err)
+			}
+		}(r.cache)
+	}
+}
+
+// fitLinum widens the line numbers for the lines parsed, the sections are
+// wrapped again when they are shown if the text gets narrower
+func (r *Renderer) fitLinum() {
+	if !r.ShowLinum || len(strconv.Itoa(len(r.buffer.Lines))) == r.linumWidth {
+		return
 	}
+	r.linumWidth = len(strconv.Itoa(len(r.buffer.Lines)))
+	wrapWidth := r.wrapWidth
+	r.fitWidth()
+	if r.wrapWidth == wrapWidth {
+		return
+	}
+	for i := range r.buffer.sections {
+		r.buffer.sections[i].offsets, r.buffer.sections[i].lens = nil, nil
+	}
+}
+
+// renderLines renders the buffer lines [start, end), it only reads the
+// renderer so the sections can be prefetched on a snapshot
+func (r *Renderer) renderLines(start, end BufferLineIndex) []VisualLine {
+	lines := []VisualLine{}
+	for linum := start; linum < end; linum++ {
+		lines = append(lines, r.RenderLine(linum)...)
+	}
+	return lines
+}
+
+// SetAnnotations replaces the annotations painted on the text, they take
+// effect on the next Render
+func (r *Renderer) SetAnnotations(annos []db.Annotation) {
+	r.annotations = newAnnotationPainter(r.buffer)
+	for _, anno := range annos {
+		r.annotations.Add(anno)
+	}
+}
+
+// AddAnnotation paints a new annotation on the rendered text
+func (r *Renderer) AddAnnotation(anno db.Annotation) {
+	if r.annotations.Add(anno) {
+		r.annotations.Paint(anno)
+	}
+}
+
+// RefreshAnnotations replaces the painted annotations after the changed one
+// was edited or deleted, its lines are rendered again to drop the old style
+func (r *Renderer) RefreshAnnotations(annos []db.Annotation, changed db.Annotation) {
+	spans, _ := r.annotations.spansOf(changed)
+	r.SetAnnotations(annos)
+	for _, span := range spans {
+		r.renderLineAgain(span.linum)
+	}
+}
+
+// Restyle drops the rendered sections, they are rendered again with the theme
+// in use when shown. The width is not changed so the lines stay where they
+// are.
+func (r *Renderer) Restyle() {
+	if r.buffer.sections == nil {
+		return
+	}
+	r.buffer.rendered = newSectionCache()
+}
+
+// renderLineAgain replaces the visual lines of the buffer line if it is
+// rendered, the width is not changed so the number of visual lines stays the
+// same
+func (r *Renderer) renderLineAgain(linum BufferLineIndex) {
+	lines, ok := r.buffer.renderedLines(linum)
+	if !ok {
+		return
+	}
+	copy(lines, r.RenderLine(linum))
+	r.annotations.PaintLine(linum)
 }
 
 func (r *Renderer) RenderLinum(linum BufferLineIndex) string {
+	if !r.ShowLinum {
+		return ""
+	}
 	line := strconv.Itoa(int(linum))
 	line = strings.Repeat(" ", r.linumWidth-len(line)) + line
-	return linumStyle.SetString(line).String()
+	return theme.linum.SetString(line).String()
 }
 
 func (r *Renderer) RenderEmptyLinum() string {
-	return linumStyle.SetString(strings.Repeat(" ", r.linumWidth)).String()
+	if !r.ShowLinum {
+		return ""
+	}
+	return theme.linum.SetString(strings.Repeat(" ", r.linumWidth)).String()
+}
+
+// RenderMark renders the column after the line number and the margin, the
+// column shows whether the line has a comment
+func (r *Renderer) RenderMark(note bool) string {
+	margin := strings.Repeat(" ", r.Margin)
+	if !r.ShowLinum {
+		return margin
+	}
+	if note {
+		return theme.noteMark.SetString(noteMark).String() + margin
+	}
+	return theme.linum.SetString(" ").String() + margin
+}
+
+// GutterWidth returns the width of the line number, the mark and the margin
+// in front of each visual line
+func (r *Renderer) GutterWidth() int {
+	if !r.ShowLinum {
+		return r.Margin
+	}
+	return r.linumWidth + 1 + r.Margin
+}
+
+// breakLine returns the number of runes of each visual line the buffer line
+// is wrapped into, the last one is the empty line closing the paragraph. The
+// plain text is wrapped, the styles don't take any room.
+func (r *Renderer) breakLine(linum BufferLineIndex) []int {
+	return append(util.WrapLens(r.buffer.Lines[linum].Content, r.wrapWidth), 1)
 }
 
 func (r *Renderer) RenderLine(linum BufferLineIndex) []VisualLine {
 	emptyLinum := r.RenderEmptyLinum()
+	mark := r.RenderMark(false)
 	rlinum := r.RenderLinum(linum)
-	renderedLine := ""
 	line := r.buffer.Lines[linum]
 	content := line.Content
 	index := ByteIndex(0)
@@ -67,7 +303,6 @@ func (r *Renderer) RenderLine(linum BufferLineIndex) []VisualLine {
 				styled = style1(styled, s.Style)
 			}
 		}
-		renderedLine += styled.String()
 		index += ByteIndex(size)
 		content = content[size:]
 
@@ -78,27 +313,28 @@ func (r *Renderer) RenderLine(linum BufferLineIndex) []VisualLine {
 			Dirty: false,
 		})
 	}
-	visualLines := strings.Split(util.Wrap(renderedLine, r.wrapWidth), "\n")
-
 	// add empty line at the end of the paragraph with no line number
-	visualLines = append(visualLines, "\n")
 	runes = append(runes, VisualRune{C: '\n', Style: DefaultStyle, VC: "\n"})
 
 	ret := []VisualLine{}
 	start := 0
-	for i, vl := range visualLines {
+	for i, n := range r.breakLine(linum) {
 		ls := emptyLinum
 		if i == 0 {
 			ls = rlinum
 		}
-		stop := start + util.Len(vl)
+		stop := start + n
 		if len(runes) > 0 {
 			runes[start].Dirty = true
 		}
+		var vl strings.Builder
+		for _, vr := range runes[start:stop] {
+			vl.WriteString(vr.VC)
+		}
 		ret = append(ret,
 			VisualLine{
 				BufferLinum: linum,
-				Content:     vl,
+				Content:     vl.String(),
 				Runes:       runes[start:stop],
 				// The wrap may cause the style left at the end of last line, then the linum style will cancel
 				// the style of the first character in this line which will cause it's style to be lost.
@@ -107,7 +343,8 @@ func (r *Renderer) RenderLine(linum BufferLineIndex) []VisualLine {
 				// dirty flag to the VisualRune struct.
 				Dirty:      true,
 				LineNum:    ls,
-				LinumStyle: linumStyle,
+				Mark:       mark,
+				LinumStyle: theme.linum,
 			},
 		)
 		start = stop
@@ -127,24 +364,28 @@ func (r *Renderer) GetVisualLineNumById(id epub.ManifestId) VisualLineIndex {
 	return r.buffer.GetVisualLineNumById(id)
 }
 
+func (r *Renderer) GetVisualLineNumByAnchor(id epub.ManifestId, anchor string) VisualLineIndex {
+	return r.buffer.GetVisualLineNumByAnchor(id, anchor)
+}
+
 func (r *Renderer) MarkPosition(vy VisualLineIndex, vx VisualIndex) string {
 	log.Debugf("MarkPosition: %d, %d", vy, vx)
 	// TODO: show one space if the cursor is at an empty line
-	vy = VisualLineIndex(util.MinInt(int(vy), len(r.buffer.visualLines)))
-	if len(r.buffer.visualLines[vy].Content) > 0 {
-		return r.buffer.visualLines[vy].MarkPosition(vx)
+	vy = VisualLineIndex(util.MinInt(int(vy), r.buffer.VisualLinesNum()-1))
+	if line := r.buffer.visualLine(vy); len(line.Content) > 0 {
+		return line.MarkPosition(vx)
 	}
 	return ""
 }
 
 func (r *Renderer) ClearCursorStyles(vy VisualLineIndex) {
-	r.buffer.visualLines[vy].ClearLine()
+	r.buffer.visualLine(vy).ClearLine()
 }
 
 func (r *Renderer) MarkInline(vy VisualLineIndex, vxs, vxe VisualIndex) string {
-	return r.buffer.visualLines[vy].MarkInline(vxs, vxe)
+	return r.buffer.visualLine(vy).MarkInline(vxs, vxe)
 }
 
 func (r *Renderer) MarkLine(vy VisualLineIndex) string {
-	return r.buffer.visualLines[vy].MarkLine()
+	return r.buffer.visualLine(vy).MarkLine()
 }
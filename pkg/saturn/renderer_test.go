@@ -0,0 +1,131 @@
+package saturn
+
+import (
+	"strings"
+	"testing"
+)
+
+// newResizeTestBuffer returns lines long enough to be wrapped differently at
+// each width
+func newResizeTestBuffer() *Buffer {
+	buffer := NewBuffer()
+	for i := 0; i < 50; i++ {
+		buffer.Lines = append(buffer.Lines, Line{
+			Content: strings.Repeat("lorem ipsum dolor sit amet ", 1+i%4),
+			Style:   "p",
+		})
+	}
+	return buffer
+}
+
+func TestRenderAgain(t *testing.T) {
+	buffer := newResizeTestBuffer()
+	r := NewRender(nil, buffer)
+	r.Render(40)
+	lines := buffer.VisualLinesNum()
+	r.Render(40)
+	if got := buffer.VisualLinesNum(); got != lines {
+		t.Errorf("case same width: got %d lines, expect %d", got, lines)
+	}
+	r.Render(80)
+	if got := buffer.VisualLinesNum(); got >= lines {
+		t.Errorf("case wider: got %d lines, expect less than %d", got, lines)
+	}
+	wrapSections(buffer)
+	offsets := 0
+	for _, s := range buffer.sections {
+		offsets += len(s.offsets)
+	}
+	if offsets != len(buffer.Lines) {
+		t.Errorf("case offsets: got %d, expect %d", offsets, len(buffer.Lines))
+	}
+}
+
+func TestResize(t *testing.T) {
+	testcases := []struct {
+		name  string
+		from  int
+		to    int
+		start int
+	}{
+		{"narrower", 80, 30, 37},
+		{"wider", 30, 100, 61},
+		{"same", 60, 60, 20},
+	}
+	for _, c := range testcases {
+		buffer := newResizeTestBuffer()
+		m := NewTextModel(nil, nil, NewRender(nil, buffer), "", nil, c.from, 20).(*textModel)
+		m.Init()
+		m.viewport.SetYOffset(c.start)
+		m.jumps.Push(c.start + 5)
+		topLinum, topX := buffer.GetBufferPos(VisualLineIndex(c.start), 0)
+		jumpLinum, jumpX := buffer.GetBufferPos(VisualLineIndex(c.start+5), 0)
+
+		cmd := m.resize(c.to, 20)
+		if buffer.VisualLinesNum() == 0 {
+			t.Fatalf("case %s: the lines are gone before the layout arrives", c.name)
+		}
+		m.Update(cmd())
+
+		if got := m.renderer.wrapWidth; got != c.to-m.renderer.GutterWidth() {
+			t.Errorf("case %s: got wrap width %d, expect %d", c.name, got, c.to-m.renderer.GutterWidth())
+		}
+		if got, expect := m.viewport.YOffset, int(buffer.GetVisualPos(topLinum, topX)); got != expect {
+			t.Errorf("case %s: got offset %d, expect %d", c.name, got, expect)
+		}
+		if linum, _ := buffer.GetBufferPos(VisualLineIndex(m.viewport.YOffset), 0); linum != topLinum {
+			t.Errorf("case %s: got line %d at the top, expect %d", c.name, linum, topLinum)
+		}
+		if got, ok := m.jumps.Back(m.viewport.YOffset); !ok || got != int(buffer.GetVisualPos(jumpLinum, jumpX)) {
+			t.Errorf("case %s: got jump %d, expect %d", c.name, got, buffer.GetVisualPos(jumpLinum, jumpX))
+		}
+	}
+}
+
+func TestResizeStaleLayout(t *testing.T) {
+	buffer := newResizeTestBuffer()
+	m := NewTextModel(nil, nil, NewRender(nil, buffer), "", nil, 80, 20).(*textModel)
+	m.Init()
+	stale := m.resize(30, 20)
+	latest := m.resize(50, 20)
+	m.Update(latest())
+	m.Update(stale())
+	if got, expect := m.renderer.wrapWidth, 50-m.renderer.GutterWidth(); got != expect {
+		t.Errorf("got wrap width %d, expect %d", got, expect)
+	}
+}
+
+// newBenchmarkBuffer returns a book of n paragraphs
+func newBenchmarkBuffer(n int) *Buffer {
+	buffer := NewBuffer()
+	for i := 0; i < n; i++ {
+		buffer.Lines = append(buffer.Lines, Line{
+			Content: strings.Repeat("The way you can go isn't the real way. ", 1+i%8),
+			Style:   "p",
+		})
+	}
+	return buffer
+}
+
+func BenchmarkRender(b *testing.B) {
+	buffer := newBenchmarkBuffer(100000)
+	r := NewRender(nil, buffer)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Render only estimates the sections, every one is wrapped as if the
+		// whole book was read
+		r.Render(80)
+		wrapSections(buffer)
+	}
+}
+
+func BenchmarkGetBufferLineNumByVisual(b *testing.B) {
+	buffer := newBenchmarkBuffer(100000)
+	NewRender(nil, buffer).Render(80)
+	wrapSections(buffer)
+	lines := buffer.VisualLinesNum()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buffer.GetBufferLineNumByVisual(VisualLineIndex(i * 7919 % lines))
+	}
+}
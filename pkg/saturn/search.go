@@ -0,0 +1,123 @@
+package saturn
+
+import (
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+type SearchOptions struct {
+	// Regex treats the pattern as a regular expression instead of plain text
+	Regex bool
+	// SmartCase ignores case unless the pattern contains upper case letters
+	SmartCase bool
+}
+
+// Match is a search hit covering the runes [Start, End) of a buffer line
+type Match struct {
+	BufferLinum BufferLineIndex
+	Start       RuneIndex
+	End         RuneIndex
+}
+
+// CompilePattern builds the regexp of the search pattern with the options
+func CompilePattern(pattern string, opts SearchOptions) (*regexp.Regexp, error) {
+	expr := pattern
+	if !opts.Regex {
+		expr = regexp.QuoteMeta(pattern)
+	}
+	if opts.SmartCase && !hasUpper(pattern) {
+		expr = "(?i)" + expr
+	}
+	return regexp.Compile(expr)
+}
+
+func hasUpper(s string) bool {
+	for _, r := range s {
+		if unicode.IsUpper(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// Search returns all the matches of the pattern in the buffer in order, empty
+// matches are skipped. Only the spine items parsed are searched, the blank
+// lines of the others never match.
+func (b *Buffer) Search(pattern string, opts SearchOptions) ([]Match, error) {
+	matches := []Match{}
+	if pattern == "" {
+		return matches, nil
+	}
+	re, err := CompilePattern(pattern, opts)
+	if err != nil {
+		return nil, err
+	}
+	return b.SearchRegexp(re), nil
+}
+
+// SearchRegexp returns all the matches of the compiled pattern in the buffer
+// in order, it's Search for many buffers searched with the same pattern
+func (b *Buffer) SearchRegexp(re *regexp.Regexp) []Match {
+	matches := []Match{}
+	for linum, line := range b.Lines {
+		for _, loc := range re.FindAllStringIndex(line.Content, -1) {
+			if loc[0] == loc[1] {
+				continue
+			}
+			start := RuneIndex(utf8.RuneCountInString(line.Content[:loc[0]]))
+			matches = append(matches, Match{
+				BufferLinum: BufferLineIndex(linum),
+				Start:       start,
+				End:         start + RuneIndex(utf8.RuneCountInString(line.Content[loc[0]:loc[1]])),
+			})
+		}
+	}
+	return matches
+}
+
+// GetVisualPos returns the visual line where the rune x of the buffer line
+// is displayed after wrapping, the section of the line is wrapped first so
+// the position is exact
+func (b *Buffer) GetVisualPos(linum BufferLineIndex, x RuneIndex) VisualLineIndex {
+	b.wrapLine(linum)
+	return b.visualPos(linum, x)
+}
+
+// HighlightMatch paints the background of the match, current is the match
+// the reader is at. The matches are reversed on the terminals without colors.
+// The match is kept to be painted again when its line is rendered.
+func (b *Buffer) HighlightMatch(match Match, current bool) {
+	if b.highlights == nil {
+		b.highlights = make(map[Match]bool)
+	}
+	b.highlights[match] = current
+	b.styleMatch(match, current)
+}
+
+// styleMatch paints the match on its rendered line
+func (b *Buffer) styleMatch(match Match, current bool) {
+	color := theme.SearchHit
+	if current {
+		color = theme.SearchCurrent
+	}
+	b.StyleRunes(match.BufferLinum, match.Start, match.End, func(s lipgloss.Style) lipgloss.Style {
+		if !theme.hasColors() {
+			return s.Reverse(true)
+		}
+		return s.Background(theme.color(color))
+	})
+}
+
+// ClearMatch removes the highlight of the match
+func (b *Buffer) ClearMatch(match Match) {
+	delete(b.highlights, match)
+	b.StyleRunes(match.BufferLinum, match.Start, match.End, func(s lipgloss.Style) lipgloss.Style {
+		if !theme.hasColors() {
+			return s.Reverse(false)
+		}
+		return s.UnsetBackground()
+	})
+}
@@ -0,0 +1,97 @@
+package saturn
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSearch(t *testing.T) {
+	buffer := NewBuffer()
+	buffer.Lines = []Line{
+		{Content: "The way you can go"},
+		{Content: "isn't the real way."},
+		{Content: "道可道非常道 Way"},
+	}
+	testcases := []struct {
+		name    string
+		pattern string
+		opts    SearchOptions
+		expect  []Match
+	}{
+		{
+			name:    "case sensitive",
+			pattern: "way",
+			opts:    SearchOptions{},
+			expect:  []Match{{0, 4, 7}, {1, 15, 18}},
+		},
+		{
+			name:    "smart case ignores case",
+			pattern: "way",
+			opts:    SearchOptions{SmartCase: true},
+			expect:  []Match{{0, 4, 7}, {1, 15, 18}, {2, 7, 10}},
+		},
+		{
+			name:    "smart case with upper case",
+			pattern: "Way",
+			opts:    SearchOptions{SmartCase: true},
+			expect:  []Match{{2, 7, 10}},
+		},
+		{
+			name:    "plain text is quoted",
+			pattern: "way.",
+			opts:    SearchOptions{},
+			expect:  []Match{{1, 15, 19}},
+		},
+		{
+			name:    "regex",
+			pattern: "道.",
+			opts:    SearchOptions{Regex: true},
+			expect:  []Match{{2, 0, 2}, {2, 2, 4}, {2, 5, 7}},
+		},
+		{
+			name:    "empty pattern",
+			pattern: "",
+			opts:    SearchOptions{Regex: true},
+			expect:  []Match{},
+		},
+	}
+	for _, tc := range testcases {
+		matches, err := buffer.Search(tc.pattern, tc.opts)
+		if err != nil {
+			t.Errorf("case %s: %v", tc.name, err)
+		} else if !reflect.DeepEqual(tc.expect, matches) {
+			t.Errorf("case %s: got %v, expect %v", tc.name, matches, tc.expect)
+		}
+	}
+	if _, err := buffer.Search("(", SearchOptions{Regex: true}); err == nil {
+		t.Error("expect error for invalid regex")
+	}
+}
+
+func TestGetVisualPos(t *testing.T) {
+	buffer := NewBuffer()
+	buffer.Lines = []Line{
+		{Content: "The way you can go"},
+		{Content: "isn't the real way."},
+	}
+	renderer := NewRender(nil, buffer)
+	renderer.ShowLinum = false
+	renderer.Render(10)
+	testcases := []struct {
+		linum  BufferLineIndex
+		x      RuneIndex
+		expect VisualLineIndex
+	}{
+		{0, 0, 0},
+		{0, 9, 0},
+		{0, 10, 1},
+		{0, 17, 1},
+		{1, 0, 3},
+		{1, 15, 4},
+	}
+	for _, tc := range testcases {
+		if vy := buffer.GetVisualPos(tc.linum, tc.x); vy != tc.expect {
+			t.Errorf("(%d, %d): got %d, expect %d", tc.linum, tc.x, vy, tc.expect)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package saturn
+
+import (
+	"sort"
+
+	"github.com/elinx/saturn/pkg/epub"
+)
+
+// SectionIndex finds the table of content entry a buffer line belongs to
+type SectionIndex struct {
+	lines []BufferLineIndex
+	nodes []*epub.TocNode
+}
+
+// NewSectionIndex resolves the position of every toc entry in the buffer, the
+// entries which can't be found in the spine are skipped
+func NewSectionIndex(toc *epub.TableOfContent, buffer *Buffer) *SectionIndex {
+	index := &SectionIndex{}
+	toc.Walk(func(node *epub.TocNode) bool {
+		if _, ok := buffer.BlockPos[node.ManifestId]; ok {
+			index.lines = append(index.lines, buffer.GetBufferLineNumByAnchor(node.ManifestId, node.Fragment))
+			index.nodes = append(index.nodes, node)
+		}
+		return true
+	})
+	sort.Stable(index)
+	return index
+}
+
+func (s *SectionIndex) Len() int           { return len(s.lines) }
+func (s *SectionIndex) Less(i, j int) bool { return s.lines[i] < s.lines[j] }
+func (s *SectionIndex) Swap(i, j int) {
+	s.lines[i], s.lines[j] = s.lines[j], s.lines[i]
+	s.nodes[i], s.nodes[j] = s.nodes[j], s.nodes[i]
+}
+
+// Find returns the last toc entry starting at or before the line, nil if the
+// line is before the first entry
+func (s *SectionIndex) Find(linum BufferLineIndex) *epub.TocNode {
+	i := sort.Search(len(s.lines), func(i int) bool { return s.lines[i] > linum })
+	if i == 0 {
+		return nil
+	}
+	return s.nodes[i-1]
+}
@@ -0,0 +1,489 @@
+package saturn
+
+import (
+	"sort"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/elinx/saturn/pkg/epub"
+	"github.com/elinx/saturn/pkg/util"
+)
+
+const (
+	// maxSectionLines splits the long spine items, a section is rendered at
+	// once
+	maxSectionLines = 256
+	// maxRenderedSections is the number of sections kept rendered, the least
+	// recently used one is dropped first
+	maxRenderedSections = 8
+	// placeholderBytes is the size of the HTML of a paragraph, the lines of
+	// a spine item not parsed yet are estimated from the size of its file
+	placeholderBytes = 400
+	// placeholderRunes is the length of a paragraph, the visual lines of a
+	// spine item not parsed yet are estimated with it
+	placeholderRunes = 300
+)
+
+// prefetches are the sections being rendered and the spine items being parsed
+// in the background, the theme isn't switched until they are done
+var prefetches sync.WaitGroup
+
+// renderedSection is the visual lines of the buffer lines of a section
+type renderedSection struct {
+	lines []VisualLine
+	// painted tells the annotations and the search matches are applied on
+	// the lines, the prefetched sections are painted when they are used
+	painted bool
+}
+
+// sectionLayout is where the lines of a section are on the screen. A section
+// is a spine item or a part of a long one, a spine item not parsed yet is a
+// single section of blank lines. The number of visual lines is estimated
+// until the section is wrapped.
+type sectionLayout struct {
+	start  BufferLineIndex
+	vstart VisualLineIndex
+	lines  int
+
+	// offsets are the first visual line of each buffer line from vstart and
+	// lens are the runes of each visual line, they are nil until the section
+	// is wrapped
+	offsets []VisualLineIndex
+	lens    []int
+
+	// pending is the spine item parsed before the section is wrapped, empty
+	// if the lines are parsed
+	pending epub.ManifestId
+}
+
+// wrapped returns true if the visual lines are known, not estimated
+func (s *sectionLayout) wrapped() bool {
+	return s.offsets != nil
+}
+
+// estimateLine returns the line from start of the visual line in a section
+// of n buffer lines which isn't wrapped, the visual lines are spread evenly
+func (s *sectionLayout) estimateLine(vy VisualLineIndex, n int) BufferLineIndex {
+	if s.lines == 0 || n == 0 {
+		return 0
+	}
+	linum := int(vy-s.vstart) * n / s.lines
+	return BufferLineIndex(util.MaxInt(0, util.MinInt(linum, n-1)))
+}
+
+// reflow tells the estimated visual lines [vy, vy+oldVisual) were replaced by
+// newVisual lines. The buffer lines [linum, linum+oldLines) were replaced by
+// newLines ones too if a spine item was parsed, its rendered lines are gone.
+type reflow struct {
+	vy                   VisualLineIndex
+	oldVisual, newVisual int
+	linum                BufferLineIndex
+	oldLines, newLines   int
+}
+
+// moveVisual returns where the visual line is after the reflow, the ones in
+// the replaced lines keep their place relative to them
+func (r reflow) moveVisual(vy int) int {
+	switch start := int(r.vy); {
+	case vy < start:
+		return vy
+	case vy >= start+r.oldVisual:
+		return vy + r.newVisual - r.oldVisual
+	default:
+		return start + (vy-start)*r.newVisual/r.oldVisual
+	}
+}
+
+// parsed returns true if the reflow replaced the lines of a spine item
+func (r reflow) parsed() bool {
+	return r.oldLines > 0
+}
+
+// moveLine returns where the buffer line after the replaced ones is after
+// the reflow
+func (r reflow) moveLine(linum BufferLineIndex) BufferLineIndex {
+	if linum < r.linum+BufferLineIndex(r.oldLines) {
+		return linum
+	}
+	return linum + BufferLineIndex(r.newLines-r.oldLines)
+}
+
+// sectionCache keeps the rendered sections around the text being read, the
+// sections next to the one read are rendered in the background. The cache is
+// replaced when the layout, the theme or the lines change, the sections
+// prefetched for the old one are put into the old cache and dropped with it.
+type sectionCache struct {
+	mu       sync.Mutex
+	rendered map[int]*renderedSection
+	// recent are the rendered sections, the least recently used first
+	recent      []int
+	prefetching map[int]bool
+}
+
+func newSectionCache() *sectionCache {
+	return &sectionCache{
+		rendered:    make(map[int]*renderedSection),
+		prefetching: make(map[int]bool),
+	}
+}
+
+// sectionStarts splits the buffer at the start of each spine item and every
+// maxSectionLines lines of the long ones, the spine items not parsed yet are
+// not split
+func sectionStarts(b *Buffer) []BufferLineIndex {
+	pending := b.pendingStarts()
+	blocks := []BufferLineIndex{0, BufferLineIndex(len(b.Lines))}
+	for _, linum := range b.BlockPos {
+		blocks = append(blocks, linum)
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i] < blocks[j] })
+	starts := []BufferLineIndex{}
+	for i := 0; i+1 < len(blocks); i++ {
+		for linum := blocks[i]; linum < blocks[i+1]; linum += maxSectionLines {
+			starts = append(starts, linum)
+			if pending[blocks[i]] != "" {
+				break
+			}
+		}
+	}
+	if len(starts) == 0 {
+		starts = append(starts, 0)
+	}
+	return starts
+}
+
+// pendingStarts returns the spine items not parsed yet by their first line
+func (b *Buffer) pendingStarts() map[BufferLineIndex]epub.ManifestId {
+	starts := make(map[BufferLineIndex]epub.ManifestId)
+	for id := range b.pending {
+		starts[b.BlockPos[id]] = id
+	}
+	return starts
+}
+
+// placeholderLines returns the number of lines a spine item is estimated to
+// have from the size of its file
+func placeholderLines(size int64) int {
+	return util.MaxInt(1, int(size/placeholderBytes))
+}
+
+// estimateVisualLines guesses the number of visual lines the buffer lines are
+// wrapped into at the width, the blank lines of a pending spine item are
+// guessed as paragraphs of placeholderRunes
+func estimateVisualLines(lines []Line, wrapWidth int, pending bool) int {
+	wrapWidth = util.MaxInt(wrapWidth, 1)
+	n := 0
+	for _, line := range lines {
+		runes := placeholderRunes
+		if !pending {
+			runes = utf8.RuneCountInString(line.Content)
+		}
+		// the wrapped lines and the empty line closing the paragraph
+		n += runes/wrapWidth + 2
+	}
+	return n
+}
+
+// estimateSections lays out the buffer at the wrap width without wrapping
+// it, the sections are wrapped when they are shown
+func (b *Buffer) estimateSections(wrapWidth int) []sectionLayout {
+	pending := b.pendingStarts()
+	starts := sectionStarts(b)
+	sections := make([]sectionLayout, 0, len(starts))
+	vy := VisualLineIndex(0)
+	for i, start := range starts {
+		end := BufferLineIndex(len(b.Lines))
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		s := sectionLayout{start: start, vstart: vy, pending: pending[start]}
+		s.lines = estimateVisualLines(b.Lines[start:end], wrapWidth, s.pending != "")
+		sections = append(sections, s)
+		vy += VisualLineIndex(s.lines)
+	}
+	return sections
+}
+
+// get returns the rendered section and marks it as the most recently used
+func (c *sectionCache) get(i int) (*renderedSection, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.rendered[i]
+	if ok {
+		c.touch(i)
+	}
+	return s, ok
+}
+
+// peek returns the rendered section if it is painted already, the recently
+// used ones are left as they are
+func (c *sectionCache) peek(i int) (*renderedSection, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.rendered[i]
+	return s, ok && s.painted
+}
+
+// put adds the rendered lines of the section, the section rendered first is
+// kept if there are two of them
+func (c *sectionCache) put(i int, lines []VisualLine) *renderedSection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.prefetching, i)
+	if s, ok := c.rendered[i]; ok {
+		return s
+	}
+	s := &renderedSection{lines: lines}
+	c.rendered[i] = s
+	c.touch(i)
+	for len(c.recent) > maxRenderedSections {
+		delete(c.rendered, c.recent[0])
+		c.recent = c.recent[1:]
+	}
+	return s
+}
+
+// touch moves the section to the end of recent, it's called with the lock
+// held
+func (c *sectionCache) touch(i int) {
+	for j, v := range c.recent {
+		if v == i {
+			c.recent = append(c.recent[:j], c.recent[j+1:]...)
+			break
+		}
+	}
+	c.recent = append(c.recent, i)
+}
+
+// sectionBounds returns the buffer lines [start, end) of the section
+func (b *Buffer) sectionBounds(i int) (BufferLineIndex, BufferLineIndex) {
+	if i+1 < len(b.sections) {
+		return b.sections[i].start, b.sections[i+1].start
+	}
+	return b.sections[i].start, BufferLineIndex(len(b.Lines))
+}
+
+// sectionLinesNum returns the number of buffer lines of the section
+func (b *Buffer) sectionLinesNum(i int) int {
+	start, end := b.sectionBounds(i)
+	return int(end - start)
+}
+
+// sectionOf returns the section of the buffer line
+func (b *Buffer) sectionOf(linum BufferLineIndex) int {
+	return sort.Search(len(b.sections), func(i int) bool { return b.sections[i].start > linum }) - 1
+}
+
+// sectionOfVisual returns the section the visual line is shown in
+func (b *Buffer) sectionOfVisual(vy VisualLineIndex) int {
+	return sort.Search(len(b.sections), func(i int) bool { return b.sections[i].vstart > vy }) - 1
+}
+
+// visualPos returns the visual line where the rune x of the buffer line is
+// displayed, it's estimated if the section of the line isn't wrapped
+func (b *Buffer) visualPos(linum BufferLineIndex, x RuneIndex) VisualLineIndex {
+	if int(linum) >= len(b.Lines) {
+		return VisualLineIndex(b.VisualLinesNum())
+	}
+	i := b.sectionOf(linum)
+	s := &b.sections[i]
+	line := int(linum - s.start)
+	if !s.wrapped() {
+		return s.vstart + VisualLineIndex(line*s.lines/b.sectionLinesNum(i))
+	}
+	vy, end := s.offsets[line], VisualLineIndex(len(s.lens))
+	if line+1 < len(s.offsets) {
+		end = s.offsets[line+1]
+	}
+	for ; vy+1 < end; vy++ {
+		if int(x) < s.lens[vy] {
+			break
+		}
+		x -= RuneIndex(s.lens[vy])
+	}
+	return s.vstart + vy
+}
+
+// wrapLine wraps the section of the buffer line, the lines of a spine item
+// not parsed yet stay estimated
+func (b *Buffer) wrapLine(linum BufferLineIndex) {
+	if int(linum) >= len(b.Lines) || len(b.sections) == 0 {
+		return
+	}
+	if i := b.sectionOf(linum); b.sections[i].pending == "" {
+		b.wrapSection(i)
+	}
+}
+
+// wrapVisual wraps the first section of the visual lines [top, top+height)
+// which is estimated, false if they are all wrapped. The section is parsed
+// first if it is pending, so the lines have to be looked up again.
+func (b *Buffer) wrapVisual(top, height int) bool {
+	for i := util.MaxInt(0, b.sectionOfVisual(VisualLineIndex(top))); i < len(b.sections); i++ {
+		if int(b.sections[i].vstart) >= top+height {
+			break
+		}
+		if !b.sections[i].wrapped() {
+			b.wrapSection(i)
+			return true
+		}
+	}
+	return false
+}
+
+// wrapSection replaces the estimated visual lines of the section with the
+// wrapped ones, the lines rendered in the background are used if there are.
+// A pending section is parsed instead and its lines are estimated again.
+func (b *Buffer) wrapSection(i int) {
+	s := &b.sections[i]
+	if s.pending != "" {
+		b.load(s.pending)
+		return
+	}
+	if s.wrapped() {
+		return
+	}
+	start, end := b.sectionBounds(i)
+	offsets, lens := make([]VisualLineIndex, 0, end-start), []int{}
+	if rendered, ok := b.rendered.get(i); ok {
+		for vy, line := range rendered.lines {
+			if vy == 0 || line.BufferLinum != rendered.lines[vy-1].BufferLinum {
+				offsets = append(offsets, VisualLineIndex(vy))
+			}
+			lens = append(lens, len(line.Runes))
+		}
+	} else {
+		for linum := start; linum < end; linum++ {
+			offsets = append(offsets, VisualLineIndex(len(lens)))
+			lens = append(lens, b.renderer.breakLine(linum)...)
+		}
+	}
+	estimated := s.lines
+	s.offsets, s.lens, s.lines = offsets, lens, len(lens)
+	b.shiftSections(i+1, s.lines-estimated)
+	b.reflowed(reflow{vy: s.vstart, oldVisual: estimated, newVisual: s.lines})
+}
+
+// shiftSections moves the sections from i by delta visual lines
+func (b *Buffer) shiftSections(i, delta int) {
+	for ; i < len(b.sections); i++ {
+		b.sections[i].vstart += VisualLineIndex(delta)
+	}
+}
+
+// reflowed tells onReflow the estimated lines were replaced
+func (b *Buffer) reflowed(r reflow) {
+	if b.onReflow != nil && (r.oldVisual != r.newVisual || r.parsed()) {
+		b.onReflow(r)
+	}
+}
+
+// visualLine returns the visual line, its section is wrapped and rendered if
+// it isn't
+func (b *Buffer) visualLine(vy VisualLineIndex) *VisualLine {
+	i := b.sectionOfVisual(vy)
+	for !b.sections[i].wrapped() {
+		b.wrapSection(i)
+		i = b.sectionOfVisual(vy)
+	}
+	s := b.section(i)
+	return &s.lines[util.MinInt(int(vy-b.sections[i].vstart), len(s.lines)-1)]
+}
+
+// section returns the rendered section, it's rendered and painted first if
+// needed and the sections next to it are prefetched. The section has to be
+// wrapped.
+func (b *Buffer) section(i int) *renderedSection {
+	s, ok := b.rendered.get(i)
+	if !ok {
+		start, end := b.sectionBounds(i)
+		s = b.rendered.put(i, b.renderer.renderLines(start, end))
+	}
+	if !s.painted {
+		s.painted = true
+		b.paintSection(i)
+	}
+	b.prefetch(i - 1)
+	b.prefetch(i + 1)
+	return s
+}
+
+// paintSection applies the annotations and the search matches on the lines
+// of the section
+func (b *Buffer) paintSection(i int) {
+	start, end := b.sectionBounds(i)
+	for linum := start; linum < end; linum++ {
+		b.renderer.annotations.PaintLine(linum)
+	}
+	for match, current := range b.highlights {
+		if match.BufferLinum >= start && match.BufferLinum < end {
+			b.styleMatch(match, current)
+		}
+	}
+}
+
+// prefetch renders the section in the background if it isn't rendered, the
+// renderer is copied so a new layout doesn't change it in the middle. The
+// spine item of a pending section is parsed in the background instead.
+func (b *Buffer) prefetch(i int) {
+	if i < 0 || i >= len(b.sections) {
+		return
+	}
+	if id := b.sections[i].pending; id != "" {
+		b.loader.prefetch(id)
+		return
+	}
+	c := b.rendered
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.rendered[i]; ok || c.prefetching[i] {
+		return
+	}
+	c.prefetching[i] = true
+	start, end := b.sectionBounds(i)
+	r := b.renderer.snapshot()
+	prefetches.Add(1)
+	go func() {
+		defer prefetches.Done()
+		c.put(i, r.renderLines(start, end))
+	}()
+}
+
+// renderedLines returns the visual lines of the buffer line if its section
+// is rendered, they are changed in place
+func (b *Buffer) renderedLines(linum BufferLineIndex) ([]VisualLine, bool) {
+	if b.rendered == nil || linum < 0 || int(linum) >= len(b.Lines) {
+		return nil, false
+	}
+	i := b.sectionOf(linum)
+	s, ok := b.rendered.peek(i)
+	if !ok {
+		return nil, false
+	}
+	sec := &b.sections[i]
+	line := int(linum - sec.start)
+	start, end := sec.offsets[line], VisualLineIndex(len(sec.lens))
+	if line+1 < len(sec.offsets) {
+		end = sec.offsets[line+1]
+	}
+	return s.lines[start:end], true
+}
+
+// snapshot copies what wrap and renderLines read so they can run off the UI
+// goroutine, the lines are replaced by load but never changed in place so
+// they are shared
+func (b *Buffer) snapshot() *Buffer {
+	s := &Buffer{
+		Lines:    b.Lines,
+		loads:    b.loads,
+		BlockPos: make(map[epub.ManifestId]BufferLineIndex, len(b.BlockPos)),
+		pending:  make(map[epub.ManifestId]bool, len(b.pending)),
+	}
+	for id, linum := range b.BlockPos {
+		s.BlockPos[id] = linum
+	}
+	for id := range b.pending {
+		s.pending[id] = true
+	}
+	return s
+}
@@ -0,0 +1,122 @@
+package saturn
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/elinx/saturn/pkg/epub"
+)
+
+// newSectionTestBuffer returns a buffer of the spine items with the numbers
+// of lines
+func newSectionTestBuffer(items ...int) *Buffer {
+	buffer := NewBuffer()
+	for i, n := range items {
+		buffer.BlockPos[epub.ManifestId(fmt.Sprint("item", i))] = BufferLineIndex(len(buffer.Lines))
+		for j := 0; j < n; j++ {
+			buffer.Lines = append(buffer.Lines, Line{
+				Content: strings.Repeat("lorem ipsum ", 1+j%5),
+				Style:   "p",
+			})
+		}
+	}
+	return buffer
+}
+
+func TestSectionStarts(t *testing.T) {
+	testcases := []struct {
+		name   string
+		items  []int
+		expect []BufferLineIndex
+	}{
+		{"items", []int{3, 5, 2}, []BufferLineIndex{0, 3, 8}},
+		{"empty item", []int{3, 0, 2}, []BufferLineIndex{0, 3}},
+		{"long item", []int{2, maxSectionLines + 1}, []BufferLineIndex{0, 2, 2 + maxSectionLines}},
+		{"no lines", nil, []BufferLineIndex{0}},
+	}
+	for _, c := range testcases {
+		if got := sectionStarts(newSectionTestBuffer(c.items...)); !reflect.DeepEqual(got, c.expect) {
+			t.Errorf("case %s: got %v, expect %v", c.name, got, c.expect)
+		}
+	}
+}
+
+func TestLazyRender(t *testing.T) {
+	items := []int{}
+	for i := 0; i < 3*maxRenderedSections; i++ {
+		items = append(items, 10)
+	}
+	buffer := newSectionTestBuffer(items...)
+	r := NewRender(nil, buffer)
+	r.Render(20)
+	wrapSections(buffer)
+	if len(buffer.rendered.rendered) != 0 {
+		t.Errorf("case layout: got %d sections rendered, expect none", len(buffer.rendered.rendered))
+	}
+
+	lines := buffer.VisualLines(0, buffer.VisualLinesNum())
+	if len(lines) != buffer.VisualLinesNum() {
+		t.Errorf("case all: got %d lines, expect %d", len(lines), buffer.VisualLinesNum())
+	}
+	prefetches.Wait()
+	if got := len(buffer.rendered.rendered); got > maxRenderedSections {
+		t.Errorf("case evicted: got %d sections rendered, expect at most %d", got, maxRenderedSections)
+	}
+	for vy := 0; vy < buffer.VisualLinesNum(); vy++ {
+		line := buffer.visualLine(VisualLineIndex(vy))
+		if linum := buffer.GetBufferLineNumByVisual(VisualLineIndex(vy)); line.BufferLinum != linum {
+			t.Fatalf("case line %d: got buffer line %d, expect %d", vy, line.BufferLinum, linum)
+		}
+		s := buffer.sections[buffer.sectionOfVisual(VisualLineIndex(vy))]
+		if expect := s.lens[VisualLineIndex(vy)-s.vstart]; len(line.Runes) != expect {
+			t.Fatalf("case line %d: got %d runes, expect %d", vy, len(line.Runes), expect)
+		}
+	}
+}
+
+func TestHighlightUnrendered(t *testing.T) {
+	buffer := newSectionTestBuffer(10, 10, 10)
+	r := NewRender(nil, buffer)
+	r.Render(20)
+	buffer.HighlightMatch(Match{BufferLinum: 25, Start: 0, End: 5}, true)
+	buffer.HighlightMatch(Match{BufferLinum: 26, Start: 0, End: 5}, false)
+	buffer.ClearMatch(Match{BufferLinum: 26, Start: 0, End: 5})
+
+	current := buffer.visualLine(buffer.GetVisualPos(25, 0)).Runes[0].Style.GetBackground()
+	if expect := theme.color(theme.SearchCurrent); current != expect {
+		t.Errorf("case highlighted: got %v, expect %v", current, expect)
+	}
+	cleared := buffer.visualLine(buffer.GetVisualPos(26, 0)).Runes[0].Style.GetBackground()
+	if cleared == theme.color(theme.SearchHit) {
+		t.Errorf("case cleared: got %v, expect no highlight", cleared)
+	}
+}
+
+// wrapSections wraps every section of the buffer, the spine items not parsed
+// yet are parsed
+func wrapSections(b *Buffer) {
+	for b.wrapVisual(0, b.VisualLinesNum()) {
+	}
+}
+
+func TestReflowMove(t *testing.T) {
+	r := reflow{vy: 10, oldVisual: 20, newVisual: 40}
+	testcases := []struct {
+		name   string
+		vy     int
+		expect int
+	}{
+		{"before", 9, 9},
+		{"start", 10, 10},
+		{"inside", 15, 20},
+		{"end", 30, 50},
+		{"after", 35, 55},
+	}
+	for _, c := range testcases {
+		if got := r.moveVisual(c.vy); got != c.expect {
+			t.Errorf("case %s: got %d, expect %d", c.name, got, c.expect)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package saturn
+
+import (
+	"testing"
+
+	"github.com/elinx/saturn/pkg/epub"
+)
+
+func TestSectionIndex(t *testing.T) {
+	chapter1 := &epub.TocNode{ID: "0", Title: "Chapter 1", ManifestId: "c1"}
+	section := &epub.TocNode{ID: "0.0", Title: "Section 1", ManifestId: "c1", Fragment: "s1", Parent: chapter1}
+	chapter1.Children = []*epub.TocNode{section}
+	chapter2 := &epub.TocNode{ID: "1", Title: "Chapter 2", ManifestId: "c2"}
+	missing := &epub.TocNode{ID: "2", Title: "Missing", ManifestId: "c3"}
+	toc := &epub.TableOfContent{Nodes: []*epub.TocNode{chapter1, chapter2, missing}}
+
+	buffer := NewBuffer()
+	buffer.BlockPos["c1"] = 2
+	buffer.BlockPos["c2"] = 10
+	buffer.AddAnchor("c1", "s1", 5)
+
+	index := NewSectionIndex(toc, buffer)
+	testcases := []struct {
+		linum  BufferLineIndex
+		expect *epub.TocNode
+	}{
+		{0, nil},
+		{2, chapter1},
+		{4, chapter1},
+		{5, section},
+		{9, section},
+		{10, chapter2},
+		{100, chapter2},
+	}
+	for _, tc := range testcases {
+		if node := index.Find(tc.linum); node != tc.expect {
+			t.Errorf("line %d: got %v, expect %v", tc.linum, node, tc.expect)
+		}
+	}
+}
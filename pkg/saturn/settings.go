@@ -0,0 +1,105 @@
+package saturn
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/elinx/saturn/pkg/config"
+	"github.com/elinx/saturn/pkg/viewport"
+)
+
+// readerKeyMap and mouseWheelDelta are used by the viewport of the text
+var (
+	readerKeyMap    = viewport.DefaultKeyMap()
+	mouseWheelDelta = 3
+)
+
+// exportDir keeps the notes exported by the annotation browser, the working
+// directory if it's empty
+var exportDir string
+
+// Configure applies the keys, the theme, the styles, the scrolling and the
+// export directory of the config, the layout is set on the renderer with
+// SetLayout. Nothing is changed if the config doesn't fit the reader.
+func Configure(cfg config.Config) error {
+	keyMap, err := newKeyMap(cfg.Keys)
+	if err != nil {
+		return err
+	}
+	if _, err := themeByName(cfg.Reader.Theme); err != nil {
+		return fmt.Errorf("reader.theme: %v", err)
+	}
+	// every theme can be switched to, the styles have to fit them all
+	profile := lipgloss.ColorProfile()
+	actives := map[string]activeTheme{}
+	for _, t := range Themes {
+		active, err := newActiveTheme(t, cfg.Styles, profile)
+		if err != nil {
+			return err
+		}
+		actives[t.Name] = active
+	}
+	readerKeyMap = keyMap
+	mouseWheelDelta = cfg.Reader.MouseWheelDelta
+	exportDir = cfg.Export.Dir
+	styleOverrides = cfg.Styles
+	theme = actives[cfg.Reader.Theme]
+	return nil
+}
+
+// SetLayout sets the line numbers, the wrap width and the margin, they take
+// effect on the next Render
+func (r *Renderer) SetLayout(reader config.Reader) {
+	r.ShowLinum = reader.LineNumbers
+	r.MaxWidth = reader.WrapWidth
+	r.Margin = reader.Margin
+}
+
+// readerKeys are the keys handled by the text model before the viewport, the
+// actions of the viewport can't be bound to them
+var readerKeys = []string{
+	"q", "ctrl+c", "esc", "/", "?", "n", "N", "a", "c", "A", "T", "[", "]", "enter", "ctrl+o", "tab",
+}
+
+// newKeyMap rebinds the actions of the default key map, a key can't be bound
+// to two actions or to a key of the reader
+func newKeyMap(keys config.Keys) (viewport.KeyMap, error) {
+	keyMap := viewport.DefaultKeyMap()
+	bindings := map[string]*key.Binding{
+		"page_down":        &keyMap.PageDown,
+		"page_up":          &keyMap.PageUp,
+		"half_page_up":     &keyMap.HalfPageUp,
+		"half_page_down":   &keyMap.HalfPageDown,
+		"down":             &keyMap.Down,
+		"up":               &keyMap.Up,
+		"top":              &keyMap.Top,
+		"bottom":           &keyMap.Bottom,
+		"chapter_forward":  &keyMap.ChapterForward,
+		"chapter_backward": &keyMap.ChapterBackward,
+	}
+	for name, keys := range keys.Actions() {
+		if keys != nil {
+			*bindings[name] = key.NewBinding(key.WithKeys(keys...))
+		}
+	}
+	names := make([]string, 0, len(bindings))
+	for name := range bindings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	boundTo := map[string]string{}
+	for _, k := range readerKeys {
+		boundTo[k] = "the reader"
+	}
+	for _, name := range names {
+		for _, k := range bindings[name].Keys() {
+			if other, ok := boundTo[k]; ok {
+				return keyMap, fmt.Errorf("keys.%s: %q is bound to %s too", name, k, other)
+			}
+			boundTo[k] = name
+		}
+	}
+	return keyMap, nil
+}
@@ -0,0 +1,85 @@
+package saturn
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/elinx/saturn/pkg/config"
+	"github.com/elinx/saturn/pkg/viewport"
+)
+
+func TestNewKeyMap(t *testing.T) {
+	testcases := []struct {
+		name   string
+		keys   config.Keys
+		expect func(k *viewport.KeyMap) []string
+		err    string
+	}{
+		{
+			name: "default",
+			expect: func(k *viewport.KeyMap) []string {
+				return k.Down.Keys()
+			},
+		},
+		{
+			name: "rebound",
+			keys: config.Keys{Down: []string{"ctrl+n"}, ChapterForward: []string{"L"}},
+			expect: func(k *viewport.KeyMap) []string {
+				return append(k.Down.Keys(), k.ChapterForward.Keys()...)
+			},
+		},
+		{
+			name: "conflict with a default key",
+			keys: config.Keys{ChapterForward: []string{"j"}},
+			err:  `keys.down: "j" is bound to chapter_forward too`,
+		},
+		{
+			name: "conflict with a reader key",
+			keys: config.Keys{ChapterForward: []string{"L", "]"}},
+			err:  `keys.chapter_forward: "]" is bound to the reader too`,
+		},
+	}
+	expects := map[string][]string{
+		"default": {"down", "j"},
+		"rebound": {"ctrl+n", "L"},
+	}
+	for _, c := range testcases {
+		keyMap, err := newKeyMap(c.keys)
+		if c.err != "" {
+			if err == nil || err.Error() != c.err {
+				t.Errorf("case %s: got %v, expect %s", c.name, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %s: unexpected error %v", c.name, err)
+			continue
+		}
+		if got := c.expect(&keyMap); !reflect.DeepEqual(got, expects[c.name]) {
+			t.Errorf("case %s: got %v, expect %v", c.name, got, expects[c.name])
+		}
+	}
+}
+
+func TestGutterWidth(t *testing.T) {
+	testcases := []struct {
+		name   string
+		layout config.Reader
+		width  int
+		expect []int
+	}{
+		{"line numbers", config.Reader{LineNumbers: true}, 80, []int{4, 76}},
+		{"margin", config.Reader{LineNumbers: true, Margin: 2}, 80, []int{6, 74}},
+		{"no line numbers", config.Reader{Margin: 3}, 80, []int{3, 77}},
+		{"wrap width", config.Reader{LineNumbers: true, WrapWidth: 60}, 80, []int{4, 60}},
+	}
+	for _, c := range testcases {
+		r := NewRender(nil, &Buffer{Lines: make([]Line, 120)})
+		r.SetLayout(c.layout)
+		r.Render(c.width)
+		got := []int{r.GutterWidth(), r.wrapWidth}
+		if !reflect.DeepEqual(got, c.expect) {
+			t.Errorf("case %s: got %v, expect %v", c.name, got, c.expect)
+		}
+	}
+}
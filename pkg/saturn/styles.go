@@ -2,14 +2,12 @@ package saturn
 
 import (
 	"github.com/charmbracelet/lipgloss"
+	"github.com/elinx/saturn/pkg/config"
+	"github.com/elinx/saturn/pkg/db"
 )
 
 var DefaultStyle = lipgloss.NewStyle()
 
-var linumStyle lipgloss.Style = lipgloss.NewStyle().
-	Background(lipgloss.Color("#333")).
-	Foreground(lipgloss.Color("#ccc"))
-
 var styles = map[string]lipgloss.Style{
 	"p": DefaultStyle,
 }
@@ -22,24 +20,55 @@ func Style(tag string) lipgloss.Style {
 	}
 }
 
+// style1 applies the style of the tag in the theme on baseStyle
 func style1(baseStyle lipgloss.Style, style string) lipgloss.Style {
-	switch style {
-	case "title":
-		return baseStyle.Bold(true).Foreground(lipgloss.Color("9"))
-	case "highlight":
-		return baseStyle.Foreground(lipgloss.Color("5"))
-	case "italic", "i":
-		return baseStyle.Italic(true)
-	case "bold":
-		return baseStyle.Bold(true).Foreground(lipgloss.Color("9"))
-	case "underline":
-		return baseStyle.Underline(true)
-	case "p":
-		return baseStyle.Foreground(lipgloss.Color("12"))
-	case "h1", "h2", "h3", "h4", "h5", "h6":
-		return baseStyle.Bold(true).Foreground(lipgloss.Color("9"))
-	case "cursor":
-		return baseStyle.Reverse(true)
+	if s, ok := theme.Styles[style]; ok {
+		return theme.apply(baseStyle, s)
+	}
+	return baseStyle
+}
+
+// noteMark is shown in the gutter of the lines with a comment
+const noteMark = "✎"
+
+// annotationPalette are the colors an annotation cycles through in the
+// annotation browser, the empty one is the default of the type
+var annotationPalette = []string{"", "3", "2", "6", "5", "1"}
+
+// annotationTypes are the types an annotation cycles through
+var annotationTypes = []db.AnnotationType{
+	db.AnnotationHighlight,
+	db.AnnotationUnderscore,
+	db.AnnotationComment,
+}
+
+// annotationColor returns the color of the annotation, the theme decides the
+// color of the annotations without one
+func annotationColor(anno db.Annotation) string {
+	if anno.Color != "" {
+		return anno.Color
+	}
+	return theme.Annotations[anno.Type]
+}
+
+// styleAnnotation decorates the style of a rune covered by the annotation,
+// the text of a comment is underlined and italic to mark there is a note.
+// The highlights are underlined on the terminals without colors.
+func styleAnnotation(baseStyle lipgloss.Style, anno db.Annotation) lipgloss.Style {
+	color := annotationColor(anno)
+	switch {
+	case anno.Type == db.AnnotationUnderscore || !theme.hasColors():
+		baseStyle = baseStyle.Underline(true)
+		if color != "" {
+			baseStyle = theme.apply(baseStyle, config.Style{Foreground: color})
+		}
+	case anno.Type == db.AnnotationComment:
+		baseStyle = theme.apply(baseStyle, config.Style{Foreground: color})
+	default:
+		baseStyle = theme.apply(baseStyle, config.Style{Background: color})
+	}
+	if anno.Type == db.AnnotationComment || anno.Comment != "" {
+		baseStyle = baseStyle.Underline(true).Italic(true)
 	}
 	return baseStyle
 }
@@ -1,6 +1,10 @@
 package saturn
 
 import (
+	"fmt"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/elinx/saturn/pkg/db"
@@ -24,8 +28,64 @@ type textModel struct {
 	selectionEnd   Pos
 	selectText     string
 	cursorReleased bool
+
+	// focusedLink is the link selected by keyboard, nil if there is none
+	focusedLink *Link
+	jumps       jumpList
+
+	// searching is true while the search prompt is open, the matches are
+	// kept after the prompt is closed for n/N
+	searching      bool
+	searchInput    textinput.Model
+	searchBackward bool
+	searchOpts     SearchOptions
+	searchOrigin   int
+	matches        []Match
+	matchIndex     int
+	statusMsg      string
+
+	// the position saved last time, it's saved again when the chapter
+	// changes or savePositionInterval passed
+	savedPosition db.Position
+	savedAt       time.Time
+
+	// layoutSeq numbers the wraps after resizing, only the layout of the
+	// last one is shown
+	layoutSeq int
+
+	// unanchored are the annotations made with screen coordinates which
+	// aren't found in the spine items parsed yet
+	unanchored []db.Annotation
+	// waitingParsed is true while a command waits for the spine items parsed
+	// in the background
+	waitingParsed bool
 }
 
+// resizeMessage asks the text model to wrap the text for the new size, the
+// main model receives the size first and sends it
+type resizeMessage struct {
+	width  int
+	height int
+}
+
+// layoutMessage carries the text wrapped off the UI goroutine, the models in
+// front of the text model pass it back
+type layoutMessage struct {
+	seq    int
+	layout layout
+}
+
+// parsedMessage tells spine items were parsed in the background, the models
+// in front of the text model pass it back
+type parsedMessage struct{}
+
+// savePositionMessage asks the text model to save the position if it moved,
+// it's sent every savePositionInterval. The models in front of the text model
+// pass it back.
+type savePositionMessage struct{}
+
+const savePositionInterval = 30 * time.Second
+
 func NewTextModel(book *epub.Epub, db *db.DB, renderer *Renderer,
 	currentId epub.ManifestId, prev tea.Model, width, height int) tea.Model {
 	return &textModel{
@@ -40,41 +100,140 @@ func NewTextModel(book *epub.Epub, db *db.DB, renderer *Renderer,
 		selectionEnd:   InvalidPos,
 		selectText:     "",
 		cursorReleased: true,
+		searchInput:    textinput.New(),
+		searchOpts:     SearchOptions{Regex: false, SmartCase: true},
 	}
 }
 
 func (m *textModel) Init() tea.Cmd {
 	m.renderer.Render(m.width)
-	m.viewport = viewport.New(m.width, m.height, m.renderer.buffer)
+	m.renderer.buffer.onReflow = m.reflow
+	// the last line is left for the status
+	m.viewport = viewport.New(m.width, m.height-1, m.renderer.buffer)
 	m.viewport.Style = lipgloss.NewStyle()
-	return nil
+	m.viewport.KeyMap = readerKeyMap
+	m.viewport.MouseWheelDelta = mouseWheelDelta
+	if m.db == nil {
+		return nil
+	}
+	annos, err := m.db.UnanchoredAnnotations()
+	if err != nil {
+		log.Error(err)
+	}
+	m.unanchored = annos
+	m.migrate()
+	if len(m.unanchored) > 0 {
+		return tea.Batch(tickSavePosition(), m.parseRest())
+	}
+	return tickSavePosition()
+}
+
+// tickSavePosition sends savePositionMessage after savePositionInterval
+func tickSavePosition() tea.Cmd {
+	return tea.Tick(savePositionInterval, func(time.Time) tea.Msg {
+		return savePositionMessage{}
+	})
 }
 
 func (m *textModel) Update(message tea.Msg) (tea.Model, tea.Cmd) {
+	if msg, ok := message.(tea.KeyMsg); ok && m.searching {
+		return m, m.updateSearch(msg)
+	}
 	switch msg := message.(type) {
 	case tea.KeyMsg:
+		m.statusMsg = ""
 		switch msg.String() {
 		case "q", "ctrl+c":
+			m.SavePosition()
 			return m, tea.Quit
 		case "esc":
+			if len(m.matches) > 0 {
+				m.clearMatches()
+				return m, nil
+			}
+			m.SavePosition()
 			return m.prevModel, nil
+		case "/", "?":
+			return m, m.startSearch(msg.String() == "?")
+		case "n":
+			m.nextMatch(!m.searchBackward)
+		case "N":
+			m.nextMatch(m.searchBackward)
 		case "a":
-			if m.selectionStart != InvalidPos && m.selectionEnd != InvalidPos {
-				anno := db.NewAnnotation(db.AnnotationHighlight, m.selectText,
-					db.WithLocation(m.selectionStart.X, m.selectionStart.Y, m.selectionEnd.X, m.selectionEnd.Y))
-				m.db.Commit(anno)
+			if anno, ok := m.selectionAnnotation(db.AnnotationHighlight); ok {
+				m.commit(anno)
+			}
+		case "c":
+			if anno, ok := m.selectionAnnotation(db.AnnotationComment); ok {
+				return NewNoteEditorModel(anno, m, m.width, m.height), nil
+			}
+			m.statusMsg = "select the text to comment"
+		case "A":
+			m.SavePosition()
+			return NewAnnotationModel(m.book, m.db, m.renderer, m, m, m.width, m.height), nil
+		case "T":
+			m.cycleTheme()
+		case "]":
+			m.focusLink(true)
+		case "[":
+			m.focusLink(false)
+		case "enter":
+			if m.focusedLink != nil {
+				return m.followLink(*m.focusedLink), nil
+			}
+		case "ctrl+o":
+			if offset, ok := m.jumps.Back(m.viewport.YOffset); ok {
+				m.viewport.SetYOffset(offset)
+			}
+		case "tab": // ctrl+i
+			if offset, ok := m.jumps.Forward(m.viewport.YOffset); ok {
+				m.viewport.SetYOffset(offset)
 			}
 		}
+	case tea.WindowSizeMsg:
+		// the main model resizes the toc and sends resizeMessage back
+		_, cmd := m.prevModel.Update(msg)
+		return m, cmd
+	case resizeMessage:
+		return m, m.resize(msg.width, msg.height)
+	case layoutMessage:
+		if msg.seq == m.layoutSeq {
+			m.applyLayout(msg.layout)
+		}
+		return m, nil
+	case parsedMessage:
+		m.waitingParsed = false
+		m.renderer.GetBuffer().loadParsed()
+		m.searchParsed()
+		return m, m.parseRest()
+	case savePositionMessage:
+		// the position is saved while the reader stays in a chapter too
+		if m.currentPosition() != m.savedPosition {
+			m.SavePosition()
+		}
+		return m, tickSavePosition()
 	case BlockMessage:
-		id := message.(BlockMessage).ID
-		pos := m.renderer.GetVisualLineNumById(id)
-		m.viewport.SetYOffset(int(pos))
+		pos := m.renderer.GetVisualLineNumByAnchor(msg.ID, msg.Fragment)
+		m.jumpTo(pos)
+	case PositionMessage:
+		m.restorePosition(msg.Position)
+	case CommentMessage:
+		m.commit(msg.Annotation)
+	case WriteErrorMessage:
+		m.statusMsg = msg.Err.Error()
+		return m, msg.Cmd
+	case AnnotationMessage:
+		buffer := m.renderer.GetBuffer()
+		buffer.load(epub.ManifestId(msg.Annotation.Start.ManifestId))
+		if linum, x, ok := BufferPosOf(buffer, msg.Annotation.Start); ok {
+			m.jumpTo(buffer.GetVisualPos(linum, x))
+		}
 	case tea.MouseMsg:
 		switch msg.Type {
 		case tea.MouseLeft:
 			// So strange x start from one while y start from zero
 			curr := Pos{
-				X: util.MaxInt(0, msg.X-1),
+				X: util.MaxInt(0, msg.X-1-m.renderer.GutterWidth()),
 				Y: msg.Y,
 			}
 			log.Debugf("mouse left clicked: %v", curr)
@@ -88,15 +247,106 @@ func (m *textModel) Update(message tea.Msg) (tea.Model, tea.Cmd) {
 			m.cursorReleased = false
 		case tea.MouseRelease:
 			m.cursorReleased = true
+			// a click without dragging on a link follows it
+			if m.selectionStart != InvalidPos && m.selectionStart == m.selectionEnd {
+				if link, ok := m.linkAt(m.selectionStart); ok {
+					m.clearCursor(m.selectionStart, m.selectionEnd)
+					m.selectionStart, m.selectionEnd = InvalidPos, InvalidPos
+					return m.followLink(link), nil
+				}
+			}
 		}
 	}
 	var cmd tea.Cmd
 	m.viewport, cmd = m.viewport.Update(message)
+	m.savePositionIfDue()
 	return m, cmd
 }
 
 func (m *textModel) View() string {
-	return m.viewport.View()
+	m.reveal()
+	return m.viewport.View() + "\n" + m.statusView()
+}
+
+// reveal wraps the sections shown in the viewport before they are drawn, the
+// estimated lines are replaced so the text is where it's shown. The viewport
+// is moved by reflow, it's kept in the book when the end gets shorter.
+func (m *textModel) reveal() {
+	buffer := m.renderer.GetBuffer()
+	for {
+		for buffer.wrapVisual(m.viewport.YOffset, m.viewport.Height) {
+		}
+		top := m.viewport.YOffset
+		m.viewport.SetYOffset(top)
+		if m.viewport.YOffset == top {
+			return
+		}
+	}
+}
+
+// reflow keeps the positions on the same text after the estimated lines
+// were replaced, the lines rendered are gone if a spine item was parsed and
+// the annotations not anchored are looked for in it
+func (m *textModel) reflow(r reflow) {
+	m.viewport.YOffset = r.moveVisual(m.viewport.YOffset)
+	m.searchOrigin = r.moveVisual(m.searchOrigin)
+	m.jumps.Remap(r.moveVisual)
+	if r.parsed() {
+		for i := range m.matches {
+			m.matches[i].BufferLinum = r.moveLine(m.matches[i].BufferLinum)
+		}
+		m.restyled()
+		m.migrate()
+	}
+}
+
+// migrate anchors the annotations made with screen coordinates which are
+// found in the spine items parsed
+func (m *textModel) migrate() {
+	if len(m.unanchored) == 0 {
+		return
+	}
+	rest, err := MigrateAnnotations(m.db, m.renderer.GetBuffer(), m.unanchored)
+	if err != nil {
+		log.Error(err)
+	}
+	m.unanchored = rest
+}
+
+// parseRest parses the spine items not parsed yet in the background, they are
+// put into the buffer as parsedMessage arrives
+func (m *textModel) parseRest() tea.Cmd {
+	buffer := m.renderer.GetBuffer()
+	if m.waitingParsed || !buffer.parseAll() {
+		return nil
+	}
+	m.waitingParsed = true
+	ready := buffer.loader.ready
+	return func() tea.Msg {
+		<-ready
+		return parsedMessage{}
+	}
+}
+
+func (m *textModel) statusView() string {
+	if m.searching {
+		flags := ""
+		if m.searchOpts.Regex {
+			flags += " [regex]"
+		}
+		if m.searchOpts.SmartCase {
+			flags += " [smartcase]"
+		}
+		return m.searchInput.View() + flags
+	}
+	if m.statusMsg != "" {
+		return m.statusMsg
+	}
+	if len(m.matches) > 0 {
+		return fmt.Sprintf("[%d/%d] %s%s", m.matchIndex+1, len(m.matches),
+			m.searchInput.Prompt, m.searchInput.Value())
+	}
+	return ""
 }
 
 func (m *textModel) markPosition(p Pos) string {
@@ -154,3 +404,428 @@ func (m *textModel) clearCursor(start, end Pos) {
 		m.renderer.ClearCursorStyles(visualLineNum)
 	}
 }
+
+// jumpTo scrolls to the visual line and records the position we left in the
+// jumplist
+func (m *textModel) jumpTo(pos VisualLineIndex) {
+	m.jumps.Push(m.viewport.YOffset)
+	m.viewport.SetYOffset(int(pos))
+}
+
+// followLink jumps to the target of an internal link, external links are
+// ignored. Notes referred by noteref links are shown in a popup instead.
+func (m *textModel) followLink(link Link) tea.Model {
+	m.unfocusLink()
+	href, fragment := epub.SplitHref(link.Target)
+	id := m.book.HrefToManifestId(href)
+	if id == "" {
+		log.Debugf("not an internal link: %s", link.Target)
+		return m
+	}
+	if link.IsNoteRef() && fragment != "" {
+		if note, err := NewParser(m.book).ParseElement(id, fragment); err != nil {
+			log.Errorf("failed to parse note %s: %v", link.Target, err)
+		} else {
+			return NewNoteModel(m.book, note, m, m.width, m.height)
+		}
+	}
+	m.jumpTo(m.renderer.GetVisualLineNumByAnchor(id, fragment))
+	return m
+}
+
+// linkAt returns the link displayed at the screen position
+func (m *textModel) linkAt(p Pos) (Link, bool) {
+	vy := p.Y + m.viewport.YOffset
+	buffer := m.renderer.GetBuffer()
+	if vy < 0 || vy >= buffer.VisualLinesNum() {
+		return Link{}, false
+	}
+	linum, x := buffer.GetBufferPos(VisualLineIndex(vy), VisualIndex(p.X))
+	return buffer.GetLinkAt(linum, x)
+}
+
+// visibleLinks returns the links in the lines shown in the viewport
+func (m *textModel) visibleLinks() []Link {
+	buffer := m.renderer.GetBuffer()
+	if buffer.VisualLinesNum() == 0 {
+		return nil
+	}
+	bottom := util.MinInt(m.viewport.YOffset+m.viewport.Height, buffer.VisualLinesNum()) - 1
+	start := buffer.GetBufferLineNumByVisual(VisualLineIndex(m.viewport.YOffset))
+	end := buffer.GetBufferLineNumByVisual(VisualLineIndex(bottom)) + 1
+	return buffer.GetLinks(start, end)
+}
+
+// focusLink moves the focus to the next or previous link in the viewport, it
+// starts over from the first(or the last) link if the focused one is gone
+func (m *textModel) focusLink(next bool) {
+	links := m.visibleLinks()
+	if len(links) == 0 {
+		m.unfocusLink()
+		return
+	}
+	index := -1
+	for i, link := range links {
+		if m.focusedLink != nil && link == *m.focusedLink {
+			index = i
+			break
+		}
+	}
+	switch {
+	case index == -1 && next:
+		index = 0
+	case index == -1:
+		index = len(links) - 1
+	case next:
+		index = (index + 1) % len(links)
+	default:
+		index = (index - 1 + len(links)) % len(links)
+	}
+	m.unfocusLink()
+	link := links[index]
+	m.focusedLink = &link
+	m.renderer.GetBuffer().StyleRunes(link.BufferLinum, link.Start, link.End, func(s lipgloss.Style) lipgloss.Style {
+		return s.Reverse(true)
+	})
+}
+
+func (m *textModel) unfocusLink() {
+	if m.focusedLink == nil {
+		return
+	}
+	link := m.focusedLink
+	m.renderer.GetBuffer().StyleRunes(link.BufferLinum, link.Start, link.End, func(s lipgloss.Style) lipgloss.Style {
+		return s.Reverse(false)
+	})
+	m.focusedLink = nil
+}
+
+// startSearch opens the search prompt, matches are highlighted while typing.
+// The spine items not parsed yet are parsed in the background and searched
+// when they arrive.
+func (m *textModel) startSearch(backward bool) tea.Cmd {
+	m.clearMatches()
+	m.searching = true
+	m.searchBackward = backward
+	m.searchOrigin = m.viewport.YOffset
+	m.searchInput.Prompt = "/"
+	if backward {
+		m.searchInput.Prompt = "?"
+	}
+	m.searchInput.Reset()
+	return tea.Batch(m.searchInput.Focus(), m.parseRest())
+}
+
+func (m *textModel) updateSearch(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "enter":
+		m.searching = false
+		m.searchInput.Blur()
+		if len(m.matches) > 0 {
+			m.jumps.Push(m.searchOrigin)
+		} else if m.searchInput.Value() != "" {
+			m.statusMsg = "Pattern not found: " + m.searchInput.Value()
+		}
+		return nil
+	case "esc":
+		m.searching = false
+		m.searchInput.Blur()
+		m.clearMatches()
+		m.viewport.SetYOffset(m.searchOrigin)
+		return nil
+	case "ctrl+r":
+		m.searchOpts.Regex = !m.searchOpts.Regex
+		m.search()
+		return nil
+	case "ctrl+g":
+		m.searchOpts.SmartCase = !m.searchOpts.SmartCase
+		m.search()
+		return nil
+	}
+	prev := m.searchInput.Value()
+	var cmd tea.Cmd
+	m.searchInput, cmd = m.searchInput.Update(msg)
+	if m.searchInput.Value() != prev {
+		m.search()
+	}
+	return cmd
+}
+
+// search highlights all the matches of the pattern in the prompt and shows
+// the first one from where the search started
+func (m *textModel) search() {
+	m.clearMatches()
+	buffer := m.renderer.GetBuffer()
+	matches, err := buffer.Search(m.searchInput.Value(), m.searchOpts)
+	if err != nil {
+		m.statusMsg = err.Error()
+		return
+	}
+	m.statusMsg = ""
+	if len(matches) == 0 {
+		m.viewport.SetYOffset(m.searchOrigin)
+		return
+	}
+	m.matches = matches
+	for _, match := range m.matches {
+		buffer.HighlightMatch(match, false)
+	}
+	index := -1
+	for i, match := range m.matches {
+		// the lines of the matches far away are estimated, only the one
+		// selected is wrapped
+		vy := int(buffer.visualPos(match.BufferLinum, match.Start))
+		if m.searchBackward && vy < m.searchOrigin {
+			index = i
+		} else if !m.searchBackward && vy >= m.searchOrigin {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		// wrap around
+		index = 0
+		if m.searchBackward {
+			index = len(m.matches) - 1
+		}
+	}
+	m.matchIndex = index
+	m.viewport.SetYOffset(m.searchOrigin)
+	m.selectMatch(index)
+}
+
+// searchParsed looks for the pattern again after more spine items were
+// parsed, the match selected stays selected. The first match is shown if
+// nothing was found while typing.
+func (m *textModel) searchParsed() {
+	if m.searchInput.Value() == "" || (!m.searching && len(m.matches) == 0) {
+		return
+	}
+	if len(m.matches) == 0 {
+		m.search()
+		return
+	}
+	buffer := m.renderer.GetBuffer()
+	matches, err := buffer.Search(m.searchInput.Value(), m.searchOpts)
+	if err != nil {
+		return
+	}
+	current := m.matches[m.matchIndex]
+	m.clearMatches()
+	m.matches = matches
+	for i, match := range matches {
+		if match == current {
+			m.matchIndex = i
+		}
+		buffer.HighlightMatch(match, match == current)
+	}
+}
+
+// nextMatch moves to the next(or previous) match, it wraps around at the end
+// of the book
+func (m *textModel) nextMatch(forward bool) {
+	if len(m.matches) == 0 {
+		return
+	}
+	index := m.matchIndex + 1
+	if !forward {
+		index = m.matchIndex - 1
+	}
+	index = (index + len(m.matches)) % len(m.matches)
+	origin := m.viewport.YOffset
+	m.selectMatch(index)
+	if m.viewport.YOffset != origin {
+		m.jumps.Push(origin)
+	}
+}
+
+// selectMatch marks the match as the current one and scrolls to it if it is
+// out of the viewport
+func (m *textModel) selectMatch(index int) {
+	buffer := m.renderer.GetBuffer()
+	buffer.HighlightMatch(m.matches[m.matchIndex], false)
+	m.matchIndex = index
+	match := m.matches[index]
+	buffer.HighlightMatch(match, true)
+	vy := int(buffer.GetVisualPos(match.BufferLinum, match.Start))
+	if vy < m.viewport.YOffset || vy >= m.viewport.YOffset+m.viewport.Height {
+		m.viewport.SetYOffset(vy - m.viewport.Height/3)
+	}
+}
+
+func (m *textModel) clearMatches() {
+	buffer := m.renderer.GetBuffer()
+	for _, match := range m.matches {
+		buffer.ClearMatch(match)
+	}
+	m.matches = nil
+	m.matchIndex = 0
+}
+
+// currentPosition returns the position of the first visible rune
+func (m *textModel) currentPosition() db.Position {
+	buffer := m.renderer.GetBuffer()
+	if buffer.VisualLinesNum() == 0 {
+		return db.Position{}
+	}
+	vy := util.MinInt(m.viewport.YOffset, buffer.VisualLinesNum()-1)
+	linum, x := buffer.GetBufferPos(VisualLineIndex(vy), 0)
+	return PositionOf(buffer, linum, x)
+}
+
+func (m *textModel) restorePosition(pos db.Position) {
+	buffer := m.renderer.GetBuffer()
+	buffer.load(epub.ManifestId(pos.ManifestId))
+	linum, x, ok := BufferPosOf(buffer, pos)
+	if !ok {
+		log.Warnf("position in unknown spine item: %v", pos)
+		return
+	}
+	m.viewport.SetYOffset(int(buffer.GetVisualPos(linum, x)))
+	m.savedPosition = pos
+	m.savedAt = time.Now()
+}
+
+// selectionAnnotation creates an annotation of the selected text anchored on
+// the buffer, false if nothing is selected
+func (m *textModel) selectionAnnotation(t db.AnnotationType, opts ...db.AnnotationOption) (db.Annotation, bool) {
+	if m.selectionStart == InvalidPos || m.selectionEnd == InvalidPos {
+		return db.Annotation{}, false
+	}
+	buffer := m.renderer.GetBuffer()
+	if buffer.VisualLinesNum() == 0 {
+		return db.Annotation{}, false
+	}
+	toBuffer := func(p Pos) (BufferLineIndex, RuneIndex) {
+		vy := util.MinInt(util.MaxInt(0, p.Y+m.viewport.YOffset), buffer.VisualLinesNum()-1)
+		return buffer.GetBufferPos(VisualLineIndex(vy), VisualIndex(p.X))
+	}
+	startLinum, startX := toBuffer(m.selectionStart)
+	endLinum, endX := toBuffer(m.selectionEnd)
+	if endLinum < startLinum || (endLinum == startLinum && endX < startX) {
+		startLinum, startX, endLinum, endX = endLinum, endX, startLinum, startX
+	}
+	// the selection includes the rune under the end
+	return NewAnchoredAnnotation(t, buffer, startLinum, startX, endLinum, endX+1, opts...), true
+}
+
+// cycleTheme switches to the next theme and renders the book again, the
+// selection and the focused link are dropped, the search matches are
+// painted again
+func (m *textModel) cycleTheme() {
+	name, err := nextTheme()
+	if err != nil {
+		m.statusMsg = err.Error()
+		return
+	}
+	m.renderer.Restyle()
+	m.restyled()
+	m.statusMsg = "theme: " + name
+}
+
+// restyled drops the selection and the focused link after the lines were
+// rendered again, the search matches are painted again
+func (m *textModel) restyled() {
+	m.focusedLink = nil
+	m.selectionStart, m.selectionEnd = InvalidPos, InvalidPos
+	m.cursorReleased = true
+	buffer := m.renderer.GetBuffer()
+	for i, match := range m.matches {
+		buffer.HighlightMatch(match, i == m.matchIndex)
+	}
+}
+
+// resize wraps the text for the new width in the background, the old lines
+// are shown until the layout arrives
+func (m *textModel) resize(width, height int) tea.Cmd {
+	m.width, m.height = width, height
+	m.viewport.Width = width
+	m.viewport.Height = height - 1
+	m.layoutSeq++
+	seq := m.layoutSeq
+	renderer := m.renderer.snapshot()
+	return func() tea.Msg {
+		return layoutMessage{seq, renderer.wrap(width)}
+	}
+}
+
+// applyLayout shows the text wrapped again, the first visible rune stays at
+// the top and the jumps keep pointing at the same text
+func (m *textModel) applyLayout(l layout) {
+	type anchor struct {
+		linum BufferLineIndex
+		x     RuneIndex
+	}
+	buffer := m.renderer.GetBuffer()
+	anchors := map[int]anchor{}
+	// remember replaces the position with a key of its anchor
+	remember := func(vy int) int {
+		if buffer.VisualLinesNum() == 0 {
+			return 0
+		}
+		vy = util.MinInt(util.MaxInt(0, vy), buffer.VisualLinesNum()-1)
+		linum, x := buffer.GetBufferPos(VisualLineIndex(vy), 0)
+		anchors[vy] = anchor{linum, x}
+		return vy
+	}
+	restore := func(key int) int {
+		a, ok := anchors[key]
+		if !ok {
+			return 0
+		}
+		return int(buffer.visualPos(a.linum, a.x))
+	}
+	top := remember(m.viewport.YOffset)
+	origin := remember(m.searchOrigin)
+	m.jumps.Remap(remember)
+
+	m.renderer.apply(l)
+	m.restyled()
+	// the lines of the anchors are wrapped before any of them is restored so
+	// they don't move anymore, the positions are all restored below instead
+	// of being moved by reflow
+	onReflow := buffer.onReflow
+	buffer.onReflow = nil
+	for _, a := range anchors {
+		buffer.wrapLine(a.linum)
+	}
+	buffer.onReflow = onReflow
+	m.jumps.Remap(restore)
+	m.searchOrigin = restore(origin)
+	m.viewport.SetYOffset(restore(top))
+}
+
+// commit stores the annotation in the background and paints it
+func (m *textModel) commit(anno db.Annotation) {
+	if err := m.db.Commit(anno); err != nil {
+		log.Error(err)
+		m.statusMsg = err.Error()
+		return
+	}
+	m.renderer.AddAnnotation(anno)
+}
+
+// SavePosition stores the current position of the book in the database
+func (m *textModel) SavePosition() {
+	pos := m.currentPosition()
+	if err := m.db.SavePosition(pos); err != nil {
+		log.Error(err)
+		return
+	}
+	// the progress shown in the library
+	if err := m.db.SaveProgress(m.viewport.ScrollPercent()); err != nil {
+		log.Error(err)
+	}
+	m.savedPosition = pos
+	m.savedAt = time.Now()
+}
+
+func (m *textModel) savePositionIfDue() {
+	pos := m.currentPosition()
+	if pos == m.savedPosition {
+		return
+	}
+	if pos.ManifestId != m.savedPosition.ManifestId || time.Since(m.savedAt) > savePositionInterval {
+		m.SavePosition()
+	}
+}
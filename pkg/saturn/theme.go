@@ -0,0 +1,316 @@
+package saturn
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/elinx/saturn/pkg/config"
+	"github.com/elinx/saturn/pkg/db"
+	"github.com/muesli/termenv"
+)
+
+// Theme is the look of the book. Styles holds the styles of the tags(the
+// body text is "p", the links "a", the headings "title" and "h1" to "h6", the
+// inline tags and the other block tags the parser emits),
+// "linum" and "note_mark" are the gutter.
+//
+// A color is a list of alternatives separated by spaces like "#d7875f 173 3",
+// the first one the terminal supports is used: hex needs true color, 16-255
+// needs 256 colors. The first one is converted if none is supported.
+type Theme struct {
+	Name   string
+	Styles map[string]config.Style
+	// SearchHit and SearchCurrent are the backgrounds of the search matches
+	SearchHit     string
+	SearchCurrent string
+	// Emphasis is the color of em, strong, b and i, the styles with a color of
+	// their own keep it
+	Emphasis string
+	// Selection is drawn over the selected text, it's the "selection" style
+	// of the config
+	Selection config.Style
+	// Annotations are the colors of the annotations without one of their own
+	Annotations map[db.AnnotationType]string
+}
+
+// headingStyle is shared by the headings of a theme
+func headingStyle(color string) config.Style {
+	return config.Style{Bold: config.Bool(true), Foreground: color}
+}
+
+// blockTags are the tags of the lines besides p, the headings and title, the
+// parser makes a line of every block element
+var blockTags = []string{
+	"div", "section", "article", "aside", "header", "footer", "nav", "figure", "figcaption",
+	"blockquote", "pre", "code", "address", "ul", "ol", "li", "dl", "dt", "dd",
+	"table", "caption", "tr", "th", "td", "small", "cite", "q", "u", "s", "mark", "abbr",
+}
+
+// textStyles are the styles of the tags every theme has, the colors are
+// filled in by each theme
+func textStyles(body, heading, highlight string) map[string]config.Style {
+	styles := map[string]config.Style{
+		"p":         {Foreground: body},
+		"title":     headingStyle(heading),
+		"bold":      headingStyle(heading),
+		"h1":        headingStyle(heading),
+		"h2":        headingStyle(heading),
+		"h3":        headingStyle(heading),
+		"h4":        headingStyle(heading),
+		"h5":        headingStyle(heading),
+		"h6":        headingStyle(heading),
+		"highlight": {Foreground: highlight},
+		"italic":    {Italic: config.Bool(true)},
+		"i":         {Italic: config.Bool(true)},
+		"em":        {Italic: config.Bool(true)},
+		"b":         {Bold: config.Bool(true)},
+		"strong":    {Bold: config.Bool(true)},
+		"span":      {},
+		"sup":       {},
+		"sub":       {},
+		"underline": {Underline: config.Bool(true)},
+		"a":         {Underline: config.Bool(true)},
+		"cursor":    {Reverse: config.Bool(true)},
+	}
+	for _, tag := range blockTags {
+		styles[tag] = config.Style{Foreground: body}
+	}
+	return styles
+}
+
+// emphasisTags are colored with the emphasis of the theme
+var emphasisTags = []string{"em", "strong", "b", "i"}
+
+// withStyles adds the styles to the map, it is used to fill in the gutter
+func withStyles(styles map[string]config.Style, more map[string]config.Style) map[string]config.Style {
+	for name, style := range more {
+		styles[name] = style
+	}
+	return styles
+}
+
+// Themes are the built-in themes, the first one is the default
+var Themes = []Theme{
+	{
+		Name: "dark",
+		Styles: withStyles(textStyles("12", "9", "5"), map[string]config.Style{
+			"linum":     {Foreground: "#ccc 252 7", Background: "#333 236 0"},
+			"note_mark": {Foreground: "13"},
+		}),
+		SearchHit:     "3",
+		SearchCurrent: "208 3",
+		Emphasis:      "14",
+		Selection:     config.Style{Foreground: "#1c1c1c 234 0", Background: "#87afd7 110 7"},
+		Annotations: map[db.AnnotationType]string{
+			db.AnnotationHighlight:  "3",
+			db.AnnotationUnderscore: "",
+			db.AnnotationComment:    "13",
+		},
+	},
+	{
+		Name: "light",
+		Styles: withStyles(textStyles("#1c1c1c 234 0", "#005faf 25 4", "#af005f 125 5"), map[string]config.Style{
+			"linum":     {Foreground: "#585858 240 8", Background: "#e4e4e4 254 7"},
+			"note_mark": {Foreground: "#af00af 127 5"},
+		}),
+		SearchHit:     "#ffd75f 221 11",
+		SearchCurrent: "#ff8700 208 3",
+		Emphasis:      "#005f5f 23 6",
+		Selection:     config.Style{Background: "#afd7ff 153 14"},
+		Annotations: map[db.AnnotationType]string{
+			db.AnnotationHighlight:  "#ffff87 228 11",
+			db.AnnotationUnderscore: "",
+			db.AnnotationComment:    "#af00af 127 5",
+		},
+	},
+	{
+		Name: "sepia",
+		Styles: withStyles(textStyles("#5f4b32 95 3", "#8b3a0f 130 1", "#9c5a1a 130 3"), map[string]config.Style{
+			"linum":     {Foreground: "#8a7560 101 8", Background: "#eee3c9 223 7"},
+			"note_mark": {Foreground: "#a0522d 130 1"},
+		}),
+		SearchHit:     "#e8c07d 180 11",
+		SearchCurrent: "#d98c3f 173 3",
+		Emphasis:      "#6b3e26 94 1",
+		Selection:     config.Style{Background: "#d7c49e 180 11"},
+		Annotations: map[db.AnnotationType]string{
+			db.AnnotationHighlight:  "#f3d58a 222 11",
+			db.AnnotationUnderscore: "",
+			db.AnnotationComment:    "#a0522d 130 1",
+		},
+	},
+}
+
+// asciiStyles replace the colors of the terminals without colors, the text
+// is told apart by the attributes instead
+var asciiStyles = map[string]config.Style{
+	"highlight": {Underline: config.Bool(true)},
+	"selection": {Reverse: config.Bool(true)},
+}
+
+// activeTheme is the theme in use, the styles of the config are applied on
+// it and the colors are resolved for the color profile of the terminal
+type activeTheme struct {
+	Theme
+	profile  termenv.Profile
+	linum    lipgloss.Style
+	noteMark lipgloss.Style
+}
+
+// theme is the theme in use, it never fails without overrides
+var theme, _ = newActiveTheme(Themes[0], nil, lipgloss.ColorProfile())
+
+// styleOverrides are the styles of the config, they are kept to be applied
+// on the next theme
+var styleOverrides map[string]config.Style
+
+// themeByName returns the built-in theme with the name
+func themeByName(name string) (Theme, error) {
+	names := []string{}
+	for _, t := range Themes {
+		if t.Name == name {
+			return t, nil
+		}
+		names = append(names, t.Name)
+	}
+	return Theme{}, fmt.Errorf("unknown theme %q, expect one of %s", name, strings.Join(names, ", "))
+}
+
+// newActiveTheme applies the overrides on the theme, the names of the
+// overrides have to be in the theme
+func newActiveTheme(t Theme, overrides map[string]config.Style, profile termenv.Profile) (activeTheme, error) {
+	styles := make(map[string]config.Style, len(t.Styles))
+	for name, style := range t.Styles {
+		styles[name] = style
+	}
+	for _, tag := range emphasisTags {
+		if style := styles[tag]; style.Foreground == "" {
+			style.Foreground = t.Emphasis
+			styles[tag] = style
+		}
+	}
+	styles["selection"] = t.Selection
+	if profile == termenv.Ascii {
+		for name, style := range asciiStyles {
+			styles[name] = styles[name].Merge(style)
+		}
+	}
+	names := make([]string, 0, len(overrides))
+	for name := range overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, ok := styles[name]; !ok {
+			return activeTheme{}, fmt.Errorf("styles.%s: unknown style", name)
+		}
+		styles[name] = styles[name].Merge(overrides[name])
+	}
+	t.Selection = styles["selection"]
+	delete(styles, "selection")
+	t.Styles = styles
+	active := activeTheme{Theme: t, profile: profile}
+	active.linum = active.apply(lipgloss.NewStyle(), styles["linum"])
+	active.noteMark = active.apply(lipgloss.NewStyle(), styles["note_mark"])
+	return active, nil
+}
+
+// useTheme switches to the built-in theme for the same terminal, the lines
+// already rendered keep their styles until they are rendered again
+func useTheme(name string) error {
+	t, err := themeByName(name)
+	if err != nil {
+		return err
+	}
+	active, err := newActiveTheme(t, styleOverrides, theme.profile)
+	if err != nil {
+		return err
+	}
+	prefetches.Wait()
+	theme = active
+	return nil
+}
+
+// nextTheme switches to the built-in theme after the one in use
+func nextTheme() (string, error) {
+	index := 0
+	for i, t := range Themes {
+		if t.Name == theme.Name {
+			index = (i + 1) % len(Themes)
+			break
+		}
+	}
+	if err := useTheme(Themes[index].Name); err != nil {
+		return theme.Name, err
+	}
+	return theme.Name, nil
+}
+
+// selected returns the style of a selected rune over its own style
+func (t activeTheme) selected(s lipgloss.Style) lipgloss.Style {
+	return t.apply(s.Copy(), t.Selection)
+}
+
+// hasColors tells whether the terminal shows colors, the themes fall back on
+// the attributes without them
+func (t activeTheme) hasColors() bool {
+	return t.profile != termenv.Ascii
+}
+
+// color resolves the alternatives of the color for the profile
+func (t activeTheme) color(value string) lipgloss.Color {
+	return lipgloss.Color(resolveColor(value, t.profile))
+}
+
+// apply sets the attributes of the style on baseStyle, the unset ones are
+// left as they are
+func (t activeTheme) apply(baseStyle lipgloss.Style, s config.Style) lipgloss.Style {
+	if s.Foreground != "" && t.hasColors() {
+		baseStyle = baseStyle.Foreground(t.color(s.Foreground))
+	}
+	if s.Background != "" && t.hasColors() {
+		baseStyle = baseStyle.Background(t.color(s.Background))
+	}
+	if s.Bold != nil {
+		baseStyle = baseStyle.Bold(*s.Bold)
+	}
+	if s.Italic != nil {
+		baseStyle = baseStyle.Italic(*s.Italic)
+	}
+	if s.Underline != nil {
+		baseStyle = baseStyle.Underline(*s.Underline)
+	}
+	if s.Reverse != nil {
+		baseStyle = baseStyle.Reverse(*s.Reverse)
+	}
+	return baseStyle
+}
+
+// resolveColor picks the first alternative of the color the profile
+// supports, the first one is left to be converted by termenv if none is
+func resolveColor(value string, profile termenv.Profile) string {
+	alternatives := strings.Fields(value)
+	if len(alternatives) == 0 || profile == termenv.Ascii {
+		return ""
+	}
+	for _, c := range alternatives {
+		if colorProfile(c) <= profile {
+			return c
+		}
+	}
+	return alternatives[0]
+}
+
+// colorProfile returns the least profile showing the color as it is
+func colorProfile(color string) termenv.Profile {
+	if strings.HasPrefix(color, "#") {
+		return termenv.TrueColor
+	}
+	if n, err := strconv.Atoi(color); err == nil && n < 16 {
+		return termenv.ANSI
+	}
+	return termenv.ANSI256
+}
@@ -0,0 +1,147 @@
+package saturn
+
+import (
+	"os"
+	"testing"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/elinx/saturn/pkg/config"
+	"github.com/elinx/saturn/pkg/db"
+	"github.com/muesli/termenv"
+)
+
+// TestMain draws with colors, the tests don't depend on the terminal running
+// them
+func TestMain(m *testing.M) {
+	theme, _ = newActiveTheme(Themes[0], nil, termenv.ANSI256)
+	os.Exit(m.Run())
+}
+
+func TestResolveColor(t *testing.T) {
+	testcases := []struct {
+		name    string
+		color   string
+		profile termenv.Profile
+		expect  string
+	}{
+		{"true color", "#d7875f 173 3", termenv.TrueColor, "#d7875f"},
+		{"256 colors", "#d7875f 173 3", termenv.ANSI256, "173"},
+		{"16 colors", "#d7875f 173 3", termenv.ANSI, "3"},
+		{"no colors", "#d7875f 173 3", termenv.Ascii, ""},
+		{"converted", "#d7875f", termenv.ANSI, "#d7875f"},
+		{"ansi", "12", termenv.ANSI, "12"},
+		{"empty", "", termenv.TrueColor, ""},
+	}
+	for _, c := range testcases {
+		if got := resolveColor(c.color, c.profile); got != c.expect {
+			t.Errorf("case %s: got %q, expect %q", c.name, got, c.expect)
+		}
+	}
+}
+
+func TestNewActiveTheme(t *testing.T) {
+	active, err := newActiveTheme(Themes[0], map[string]config.Style{
+		"h1":        {Foreground: "#ff0000 196", Bold: config.Bool(false)},
+		"linum":     {Background: "236"},
+		"strong":    {Foreground: "1"},
+		"selection": {Background: "4"},
+	}, termenv.ANSI256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h1 := active.apply(lipgloss.NewStyle(), active.Styles["h1"])
+	if h1.GetForeground() != lipgloss.Color("196") || h1.GetBold() {
+		t.Errorf("case h1: got %v bold %v, expect 196 not bold", h1.GetForeground(), h1.GetBold())
+	}
+	if active.linum.GetBackground() != lipgloss.Color("236") {
+		t.Errorf("case linum: got %v, expect 236", active.linum.GetBackground())
+	}
+	if Themes[0].Styles["h1"].Foreground != "9" {
+		t.Errorf("case built-in: got %q, expect the theme to be untouched", Themes[0].Styles["h1"].Foreground)
+	}
+
+	emphasis := []struct {
+		tag    string
+		expect string
+	}{
+		{"em", Themes[0].Emphasis},
+		{"b", Themes[0].Emphasis},
+		{"strong", "1"},
+	}
+	for _, c := range emphasis {
+		if got := active.Styles[c.tag].Foreground; got != c.expect {
+			t.Errorf("case emphasis %s: got %q, expect %q", c.tag, got, c.expect)
+		}
+	}
+	if got := active.Selection; got.Background != "4" || got.Foreground != Themes[0].Selection.Foreground {
+		t.Errorf("case selection: got %+v, expect the background overridden", got)
+	}
+
+	ascii, _ := newActiveTheme(Themes[0], nil, termenv.Ascii)
+	highlight := ascii.apply(lipgloss.NewStyle(), ascii.Styles["highlight"])
+	if !highlight.GetUnderline() || highlight.GetForeground() != (lipgloss.NoColor{}) {
+		t.Errorf("case ascii: got underline %v color %v, expect underline without color",
+			highlight.GetUnderline(), highlight.GetForeground())
+	}
+	if selection := ascii.apply(lipgloss.NewStyle(), ascii.Selection); !selection.GetReverse() {
+		t.Error("case ascii selection: got no reverse, expect reverse")
+	}
+
+	for _, builtin := range Themes {
+		for _, tag := range append(append([]string{"p", "title", "h1", "h6"}, inlineTags...), blockTags...) {
+			if _, err := newActiveTheme(builtin, map[string]config.Style{tag: {Italic: config.Bool(true)}}, termenv.ANSI256); err != nil {
+				t.Errorf("case %s %s: got %v, expect the tag to be styled", builtin.Name, tag, err)
+			}
+		}
+	}
+
+	if _, err := newActiveTheme(Themes[0], map[string]config.Style{"blink": {}}, termenv.ANSI256); err == nil ||
+		err.Error() != "styles.blink: unknown style" {
+		t.Errorf("case unknown: got %v, expect styles.blink: unknown style", err)
+	}
+}
+
+func TestNextTheme(t *testing.T) {
+	defer func(saved activeTheme) { theme = saved }(theme)
+	buffer := &Buffer{Lines: []Line{{Content: "abc", Style: "p"}}}
+	r := NewRender(nil, buffer)
+	r.AddAnnotation(db.Annotation{Type: db.AnnotationHighlight, Text: "ab",
+		Start: db.Position{Line: 0, Rune: 0}, End: db.Position{Line: 0, Rune: 2}})
+	r.Render(20)
+
+	names := []string{}
+	for range Themes {
+		name, err := nextTheme()
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+		r.Restyle()
+		runes := buffer.visualLine(0).Runes
+		if got, expect := runes[2].Style.GetForeground(), theme.color(theme.Styles["p"].Foreground); got != expect {
+			t.Errorf("case %s text: got %v, expect %v", name, got, expect)
+		}
+		if got, expect := runes[0].Style.GetBackground(), theme.color(theme.Annotations[db.AnnotationHighlight]); got != expect {
+			t.Errorf("case %s annotation: got %v, expect %v", name, got, expect)
+		}
+	}
+	if expect := []string{"light", "sepia", "dark"}; names[0] != expect[0] || names[1] != expect[1] || names[2] != expect[2] {
+		t.Errorf("got %v, expect %v", names, expect)
+	}
+}
+
+func TestSelection(t *testing.T) {
+	style := theme.apply(DefaultStyle.SetString("a"), theme.Styles["p"])
+	line := VisualLine{Runes: []VisualRune{{C: 'a', Style: style, VC: style.String()}}}
+	line.MarkLine()
+	if got, expect := line.Render(), theme.selected(style).String(); got != expect {
+		t.Errorf("case selected: got %q, expect %q", got, expect)
+	}
+	line.ClearLine()
+	if got, expect := line.Render(), style.String(); got != expect {
+		t.Errorf("case cleared: got %q, expect %q", got, expect)
+	}
+	if got := style.GetBackground(); got == theme.selected(style).GetBackground() {
+		t.Errorf("case shared: got the selection background %v, expect the style unchanged", got)
+	}
+}
@@ -1,6 +1,7 @@
 package util
 
 import (
+	"strings"
 	"unicode/utf8"
 
 	"github.com/zyedidia/go-runewidth"
@@ -22,13 +23,14 @@ func Wrap(line string, limit int) string {
 		return line
 	}
 	lineWidth := 0
-	result := ""
+	var result strings.Builder
+	result.Grow(len(line) + len(line)/limit + 1)
 	ansi := false
 	for len(line) > 0 {
 		rune, size := utf8.DecodeRuneInString(line)
 		if rune == '\x1b' {
 			ansi = true
-			result += string(rune)
+			result.WriteRune(rune)
 			line = line[size:]
 			continue
 		}
@@ -36,28 +38,64 @@ func Wrap(line string, limit int) string {
 			if IsTerminator(rune) {
 				ansi = false
 			}
-			result += string(rune)
+			result.WriteRune(rune)
 			line = line[size:]
 			continue
 		}
 		if rune == '\n' {
 			lineWidth = 0
 			line = line[size:]
-			result += "\n"
+			result.WriteByte('\n')
 			continue
 		}
 		// FIXME(elinx): support tab key
 		cellWidth := runewidth.RuneWidth(rune)
 		if lineWidth+cellWidth <= limit {
-			result += string(rune)
+			result.WriteRune(rune)
 			lineWidth += cellWidth
 			line = line[size:]
 		} else if lineWidth+cellWidth > limit {
-			result += "\n"
+			result.WriteByte('\n')
 			lineWidth = 0
 		}
 	}
-	return result
+	return result.String()
+}
+
+// WrapLens returns the number of runes of each line Wrap breaks the line into
+// without building the wrapped string, the ansi escape sequences are not
+// counted like Len.
+func WrapLens(line string, limit int) []int {
+	wrapped := len(line) > limit && limit >= 2
+	lens := []int{0}
+	lineWidth := 0
+	ansi := false
+	for len(line) > 0 {
+		rune, size := utf8.DecodeRuneInString(line)
+		switch {
+		case rune == '\x1b':
+			ansi = true
+		case ansi:
+			if IsTerminator(rune) {
+				ansi = false
+			}
+		case rune == '\n':
+			lens = append(lens, 0)
+			lineWidth = 0
+		default:
+			cellWidth := runewidth.RuneWidth(rune)
+			if wrapped && lineWidth+cellWidth > limit {
+				// the rune starts the next line
+				lens = append(lens, 0)
+				lineWidth = 0
+				continue
+			}
+			lens[len(lens)-1]++
+			lineWidth += cellWidth
+		}
+		line = line[size:]
+	}
+	return lens
 }
 
 // LocBeforeWraped returns the original position of the character before the
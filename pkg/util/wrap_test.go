@@ -1,6 +1,7 @@
 package util
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -140,6 +141,13 @@ func TestWrap(t *testing.T) {
 		if actual != tc.Expected {
 			t.Errorf("Test %d, expected:\n\n`%s`\n\nActual Output:\n\n`%s`", i, tc.Expected, actual)
 		}
+		lens := []int{}
+		for _, line := range strings.Split(tc.Expected, "\n") {
+			lens = append(lens, Len(line))
+		}
+		if actual := WrapLens(tc.Input, tc.Limit); !reflect.DeepEqual(actual, lens) {
+			t.Errorf("Test %d, expected lens %v, actual %v", i, lens, actual)
+		}
 	}
 }
 
@@ -245,3 +253,10 @@ func TestWrapPos(t *testing.T) {
 		}
 	}
 }
+
+func BenchmarkWrap(b *testing.B) {
+	paragraph := strings.Repeat("The way you can go isn't the real way. 道可道非常道 ", 100)
+	for i := 0; i < b.N; i++ {
+		Wrap(paragraph, 80)
+	}
+}